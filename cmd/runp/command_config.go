@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/enr/runp/lib/core"
+)
+
+func doConfig(c *cli.Context) error {
+	format := c.String("format")
+	if format == "" {
+		format = "yaml"
+	}
+	if format != "yaml" && format != "json" {
+		return exitErrorf(2, "Invalid format %s: use yaml or json", format)
+	}
+	key, err := resolveSecretKey(c, "")
+	if err != nil {
+		return err
+	}
+	runpfile, err := loadRunpfile(c.String("f"), key)
+	if err != nil {
+		return err
+	}
+	runpfile.SecretKey = key
+	vars, err := resolveVars(c, runpfile)
+	if err != nil {
+		return err
+	}
+	runpfile.Vars = vars.Values
+	config, err := core.NewResolvedConfig(runpfile)
+	if err != nil {
+		return exitErrorf(3, "Failed to resolve Runpfile: %v", err)
+	}
+	var data []byte
+	if format == "json" {
+		data, err = json.MarshalIndent(config, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(config)
+	}
+	if err != nil {
+		return exitErrorf(3, "Failed to print configuration: %v", err)
+	}
+	fmt.Print(string(data))
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/enr/runp/lib/core"
+	"github.com/urfave/cli/v2"
+)
+
+const encryptedPrefix = "encrypted:"
+
+func doDecrypt(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return exitErrorf(3, "Encrypted value parameter is required")
+	}
+	if !c.Bool(`reveal`) {
+		return exitErrorf(3, "The decrypted secret is printed in plain text: add --reveal to confirm")
+	}
+	key, err := resolveSecretKey(c, "")
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return exitErrorf(3, "Encryption key required: use --key, --key-env or --key-file")
+	}
+	encrypted := strings.TrimPrefix(strings.TrimSpace(c.Args().First()), encryptedPrefix)
+	plain, err := core.DecryptBase64(encrypted, key)
+	if err != nil {
+		return exitErrorf(3, "Decryption operation failed: %v", err)
+	}
+	ui.WriteLinef("Decrypted secret: %s", string(plain))
+	return nil
+}
@@ -8,21 +8,16 @@ import (
 )
 
 func doEncrypt(c *cli.Context) error {
+	if c.String("file") != "" {
+		return doEncryptFile(c)
+	}
 	if c.Args().Len() != 1 {
 		return exitErrorf(3, "Secret value parameter is required")
 	}
 
-	kev := c.String(`key-env`)
-	key := c.String(`key`)
-	if kev != "" && key != "" {
-		return exitErrorf(3, "Options --key and --key-env are mutually exclusive")
-	}
-	if kev != "" {
-		ev := os.Getenv(kev)
-		if ev == "" {
-			return exitErrorf(3, "Environment variable %s is empty", kev)
-		}
-		key = ev
+	key, err := resolveSecretKey(c, "")
+	if err != nil {
+		return err
 	}
 	if key == "" {
 		ui.WriteLinef("No encryption key provided, generating random key")
@@ -37,3 +32,40 @@ func doEncrypt(c *cli.Context) error {
 	ui.WriteLinef("Encrypted secret: %s", secret)
 	return nil
 }
+
+// doEncryptFile encrypts in place the whole Runpfile or the section at --path.
+func doEncryptFile(c *cli.Context) error {
+	if c.Args().Len() != 0 {
+		return exitErrorf(3, "Secret value parameter and --file are mutually exclusive")
+	}
+	key, err := resolveSecretKey(c, "")
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return exitErrorf(3, "Encryption key required to encrypt a file: use --key, --key-env or --key-file")
+	}
+	file := c.String("file")
+	info, err := os.Stat(file)
+	if err != nil {
+		return exitErrorf(2, "File %s not found", file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return exitErrorf(2, "Failed to read %s: %v", file, err)
+	}
+	path := c.String("path")
+	encrypted, err := core.EncryptRunpfileData(data, path, key)
+	if err != nil {
+		return exitErrorf(3, "Encryption operation failed: %v", err)
+	}
+	if err := os.WriteFile(file, encrypted, info.Mode().Perm()); err != nil {
+		return exitErrorf(3, "Failed to write %s: %v", file, err)
+	}
+	if path == "" {
+		ui.WriteLinef("Encrypted %s", file)
+	} else {
+		ui.WriteLinef("Encrypted section %s in %s", path, file)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/enr/go-files/files"
+	"github.com/enr/runp/lib/core"
+	"github.com/urfave/cli/v2"
+)
+
+func doImportCompose(c *cli.Context) error {
+	composeFile := c.String("file")
+	data, err := os.ReadFile(composeFile)
+	if err != nil {
+		return exitErrorf(2, "Failed to read compose file %s: %v", composeFile, err)
+	}
+	out, warnings, err := core.ImportCompose(data)
+	for _, w := range warnings {
+		ui.WriteLinef("Unsupported: %s", w)
+	}
+	if err != nil {
+		return exitErrorf(2, "Failed to convert compose file %s: %v", composeFile, err)
+	}
+	output := c.String("output")
+	if output == "-" {
+		fmt.Print(string(out))
+		return nil
+	}
+	if files.Exists(output) && !c.Bool("force") {
+		return exitErrorf(2, "Output file %s already exists: use --force to overwrite it", output)
+	}
+	if err := os.WriteFile(output, out, 0644); err != nil {
+		return exitErrorf(2, "Failed to write Runpfile %s: %v", output, err)
+	}
+	ui.WriteLinef("Runpfile written to %s", output)
+	return nil
+}
@@ -8,13 +8,22 @@ import (
 )
 
 func doList(c *cli.Context) error {
-	runpfile, err := loadRunpfile(c.String("f"))
+	key, err := resolveSecretKey(c, "")
+	if err != nil {
+		return err
+	}
+	runpfile, err := loadRunpfile(c.String("f"), key)
 	if err != nil {
 		return err
 	}
 	ui.WriteLine("Units defined in Runpfile:")
 	for _, u := range runpfile.Units {
 		ui.WriteLinef(listLine(u))
+		if c.Bool("verbose") {
+			for _, d := range u.Details() {
+				ui.WriteLinef("    %s", d)
+			}
+		}
 	}
 
 	return nil
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/enr/runp/lib/core"
+	"github.com/urfave/cli/v2"
+)
+
+func doRekey(c *cli.Context) error {
+	runpfilePath, err := core.ResolveRunpfilePath(c.String("f"))
+	if err != nil {
+		return exitErrorf(2, "Runpfile %s not found", runpfilePath)
+	}
+	oldKey, err := resolveSecretKey(c, "old-")
+	if err != nil {
+		return err
+	}
+	newKey, err := resolveSecretKey(c, "new-")
+	if err != nil {
+		return err
+	}
+	if oldKey == "" || newKey == "" {
+		return exitErrorf(3, "Old and new keys are required: use --old-key, --old-key-env or --old-key-file and --new-key, --new-key-env or --new-key-file")
+	}
+	rekeyed, err := core.Rekey(runpfilePath, oldKey, newKey)
+	for _, f := range rekeyed {
+		ui.WriteLinef("Rekeyed %d value(s) in %s", f.Values, f.Path)
+	}
+	if err != nil {
+		return exitErrorf(3, "Rekey operation failed: %v", err)
+	}
+	if len(rekeyed) == 0 {
+		ui.WriteLinef("No encrypted values found")
+	}
+	return nil
+}
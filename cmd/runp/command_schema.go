@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/enr/runp/lib/core"
+)
+
+func doSchema(c *cli.Context) error {
+	schema, err := core.RunpfileSchema()
+	if err != nil {
+		return exitErrorf(3, "Failed to generate the Runpfile schema: %v", err)
+	}
+	fmt.Print(string(schema))
+	return nil
+}
@@ -10,28 +10,26 @@ import (
 )
 
 func doUp(c *cli.Context) error {
-	runpfile, err := loadRunpfile(c.String("f"))
+	secretKey, err := resolveSecretKey(c, "")
 	if err != nil {
 		return err
 	}
-	vars, err := applyUserVars(runpfile.Vars, c.StringSlice(`var`))
+	runpfile, err := loadRunpfile(c.String("f"), secretKey)
 	if err != nil {
 		return err
 	}
-	wd, err := os.Getwd()
-	if err != nil {
-		ui.WriteLinef("Failed to resolve current working directory: %v", err)
-	}
-	vars[`runp_root`] = runpfile.Root
-	vars[`runp_workdir`] = wd
-	vars[`runp_file_separator`] = string(os.PathSeparator)
-	runpfile.Vars = vars
-
-	secretKey, err := resolveSecretKey(c.String(`key-env`), c.String(`key`))
+	ui.WriteLinef("Runpfile root directory: %s", runpfile.Root)
+	runpfile.SecretKey = secretKey
+	vars, err := resolveVars(c, runpfile)
 	if err != nil {
 		return err
 	}
-	runpfile.SecretKey = secretKey
+	runpfile.Vars = vars.Values
+	dryRun := c.Bool("dry-run")
+	if !dryRun {
+		startSession(runpfile, vars)
+		defer appContext.RemoveSessionFile()
+	}
 
 	preconditions := runpfile.Preconditions
 	preconditionVerifyResult := preconditions.Verify()
@@ -42,15 +40,94 @@ func doUp(c *cli.Context) error {
 
 	ui.Debugf("Starting execution with Runpfile root: %s", runpfile.Root)
 	executor := core.NewExecutor(runpfile)
+	if dryRun {
+		return printPlan(executor, runpfile, vars)
+	}
 	if err := executor.Start(); err != nil {
 		return exitErrorf(3, "Failed to execute Runpfile: %s", c.String("f"))
 	}
 	return nil
 }
 
+// resolveVars returns the vars from all the sources, see core.Vars for the precedence.
+func resolveVars(c *cli.Context, runpfile *core.Runpfile) (*core.Vars, error) {
+	vars, err := core.LoadVars(runpfile, c.StringSlice(`var-file`))
+	if err != nil {
+		return nil, exitErrorf(4, "Failed to load vars: %v", err)
+	}
+	userVars := c.StringSlice(`var`)
+	if _, err := applyUserVars(vars.Values, userVars); err != nil {
+		return nil, err
+	}
+	for _, v := range userVars {
+		vars.Sources[strings.SplitN(v, `=`, 2)[0]] = core.VarSourceFlag
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		ui.WriteLinef("Failed to resolve current working directory: %v", err)
+	}
+	vars.Set(`runp_root`, runpfile.Root, core.VarSourceImplicit)
+	vars.Set(`runp_workdir`, wd, core.VarSourceImplicit)
+	vars.Set(`runp_file_separator`, string(os.PathSeparator), core.VarSourceImplicit)
+	return vars, nil
+}
+
+// startSession prints the allocated ports and writes the session file.
+func startSession(runpfile *core.Runpfile, vars *core.Vars) {
+	for _, name := range runpfile.Ports {
+		ui.WriteLinef("Port %s: %s (%s)", name, vars.Values[name], vars.Sources[name])
+	}
+	sessionFile, err := core.WriteSession(core.NewSession(runpfile, vars.Values))
+	if err != nil {
+		ui.WriteLinef("Failed to write session file: %v", err)
+		return
+	}
+	ui.Debugf("Session file: %s", sessionFile)
+	appContext.SetSessionFile(sessionFile)
+}
+
+// printPlan prints the units that runp up would start, without starting them.
+func printPlan(executor *core.RunpfileExecutor, runpfile *core.Runpfile, vars *core.Vars) error {
+	plan, err := executor.Plan()
+	if err != nil {
+		return exitErrorf(3, "Invalid Runpfile %s: %v", runpfile.Root, err)
+	}
+	ui.WriteLinef("Dry run: no unit is started")
+	for _, name := range runpfile.Ports {
+		ui.WriteLinef("Port %s: %s (%s)", name, vars.Values[name], vars.Sources[name])
+	}
+	for i, u := range plan.Units {
+		if u.Skipped {
+			ui.WriteLinef("%d. %s (%s): skipped, %s", i+1, u.Name, u.Kind, strings.Join(u.Reasons, ", "))
+			continue
+		}
+		if u.AwaitTimeout != "" {
+			ui.WriteLinef("%d. %s (%s): starts after %s is available (timeout %s)", i+1, u.Name, u.Kind, u.AwaitResource, u.AwaitTimeout)
+		} else {
+			ui.WriteLinef("%d. %s (%s): starts immediately", i+1, u.Name, u.Kind)
+		}
+		if u.Dir != "" {
+			ui.WriteLinef("   dir: %s", u.Dir)
+		}
+		if len(u.Command) > 0 {
+			ui.WriteLinef("   command: %q", u.Command)
+		}
+		if u.CommandLine != "" {
+			ui.WriteLinef("   command line: %s", u.CommandLine)
+		}
+		for _, kv := range u.Env {
+			ui.WriteLinef("   env: %s", kv)
+		}
+		for _, d := range u.Details {
+			ui.WriteLinef("   %s", d)
+		}
+	}
+	return nil
+}
+
 func applyUserVars(vars map[string]string, userVars []string) (map[string]string, error) {
 	if len(vars) == 0 && len(userVars) > 0 {
-		return nil, exitErrorf(4, "Variables provided via --var but Runpfile has no 'vars:' section: declare variable names under 'vars:' in the Runpfile before using --var")
+		return nil, exitErrorf(4, "Variables provided via --var but no vars declared: declare variable names under 'vars:' in the Runpfile or in a var file before using --var")
 	}
 	for _, v := range userVars {
 		kv := strings.SplitN(v, `=`, 2)
@@ -58,7 +135,7 @@ func applyUserVars(vars map[string]string, userVars []string) (map[string]string
 			return nil, exitErrorf(4, "Invalid --var value %q: expected key=value", v)
 		}
 		if _, declared := vars[kv[0]]; !declared {
-			return nil, exitErrorf(4, "Unknown variable %q: not declared in Runpfile", kv[0])
+			return nil, exitErrorf(4, "Unknown variable %q: not declared in Runpfile or var files", kv[0])
 		}
 		vars[kv[0]] = kv[1]
 	}
@@ -68,9 +145,19 @@ func applyUserVars(vars map[string]string, userVars []string) (map[string]string
 	return vars, nil
 }
 
-func resolveSecretKey(kev, key string) (string, error) {
-	if kev != "" && key != "" {
-		return "", exitErrorf(3, "Options --key and --key-env are mutually exclusive")
+// resolveSecretKey returns the key given using the flags key, key-env or key-file, with the prefix.
+func resolveSecretKey(c *cli.Context, prefix string) (string, error) {
+	key := c.String(prefix + `key`)
+	kev := c.String(prefix + `key-env`)
+	keyFile := c.String(prefix + `key-file`)
+	given := 0
+	for _, v := range []string{key, kev, keyFile} {
+		if v != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return "", exitErrorf(3, "Options --%[1]skey, --%[1]skey-env and --%[1]skey-file are mutually exclusive", prefix)
 	}
 	if kev != "" {
 		ev := os.Getenv(kev)
@@ -79,5 +166,21 @@ func resolveSecretKey(kev, key string) (string, error) {
 		}
 		return ev, nil
 	}
+	if keyFile != "" {
+		return readKeyFile(keyFile)
+	}
+	return key, nil
+}
+
+// readKeyFile returns the content of the key file, without leading and trailing spaces.
+func readKeyFile(keyFile string) (string, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return "", exitErrorf(3, "Failed to read key file %s: %v", keyFile, err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", exitErrorf(3, "Key file %s is empty", keyFile)
+	}
 	return key, nil
 }
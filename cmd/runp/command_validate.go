@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/enr/runp/lib/core"
+)
+
+func doValidate(c *cli.Context) error {
+	key, err := resolveSecretKey(c, "")
+	if err != nil {
+		return err
+	}
+	runpfilePath, err := core.ResolveRunpfilePath(c.String("f"))
+	if err != nil {
+		return exitErrorf(2, "Runpfile %s not found", runpfilePath)
+	}
+	runpfile, err := core.LoadRunpfileFromPathWithKey(runpfilePath, key)
+	if err != nil {
+		return exitErrorf(2, "Failed to load Runpfile %s: %v", runpfilePath, err)
+	}
+	runpfile.SecretKey = key
+	vars, err := resolveVars(c, runpfile)
+	if err != nil {
+		return err
+	}
+	runpfile.Vars = vars.Values
+	errs := core.Validate(runpfile)
+	// one error per line in the form file:line:column: message, for editors and CI
+	for _, e := range errs {
+		fmt.Println(e.Error())
+	}
+	if len(errs) > 0 {
+		return exitErrorf(2, "Invalid Runpfile %s: %d error(s)", runpfilePath, len(errs))
+	}
+	ui.WriteLinef("Runpfile %s is valid", runpfilePath)
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+)
+
+func doVars(c *cli.Context) error {
+	key, err := resolveSecretKey(c, "")
+	if err != nil {
+		return err
+	}
+	runpfile, err := loadRunpfile(c.String("f"), key)
+	if err != nil {
+		return err
+	}
+	vars, err := resolveVars(c, runpfile)
+	if err != nil {
+		return err
+	}
+	ui.WriteLine("Vars:")
+	for _, name := range vars.Names() {
+		ui.WriteLinef("%s=%s (%s)", name, vars.Values[name], vars.Sources[name])
+	}
+	return nil
+}
@@ -15,39 +15,153 @@ const (
 var commands = []*cli.Command{
 	&commandUp,
 	&commandEncrypt,
+	&commandDecrypt,
+	&commandRekey,
 	&commandList,
+	&commandVars,
+	&commandConfig,
+	&commandValidate,
+	&commandSchema,
+	&commandImport,
 }
 
 var commandUp = cli.Command{
 	Name:        "up",
-	Usage:       "up [--var K=V] [--key KEY] [--key-env KEYENV] [--file RUNPFILE]",
+	Usage:       "up [--dry-run] [--var K=V] [--var-file FILE] [--key KEY] [--key-env KEYENV] [--key-file KEYFILE] [--file RUNPFILE]",
 	Description: `Start all processes defined in the Runpfile`,
 	Action:      doUp,
 	Flags: []cli.Flag{
 		&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Value: configFileBaseName, Usage: `Path to Runpfile`},
 		&cli.StringSliceFlag{Name: "var", Aliases: []string{"V"}, Usage: `Runtime variables in format "key=value"`},
+		&cli.StringSliceFlag{Name: "var-file", Usage: `File with variables, YAML (.yml, .yaml) or dotenv format`},
 		&cli.StringFlag{Name: "key", Aliases: []string{"k"}, Usage: `Encryption key used to decrypt secrets`},
 		&cli.StringFlag{Name: "key-env", Usage: `Environment variable name containing the encryption key for secrets`},
+		&cli.StringFlag{Name: "key-file", Usage: `File containing the encryption key for secrets`},
+		&cli.BoolFlag{Name: "dry-run", Usage: `Print the units to start and their commands without starting them`},
 	},
 }
 var commandEncrypt = cli.Command{
 	Name:        "encrypt",
-	Usage:       "encrypt [--key KEY] [--key-env KEYENV] SECRET",
-	Description: `Encrypt a secret value for use in Runpfile`,
+	Usage:       "encrypt [--key KEY] [--key-env KEYENV] [--key-file KEYFILE] SECRET | --file RUNPFILE [--path SECTION]",
+	Description: `Encrypt a secret value for use in Runpfile, or a whole Runpfile or one of its sections in place`,
 	Action:      doEncrypt,
 	Flags: []cli.Flag{
 		&cli.StringFlag{Name: "key", Aliases: []string{"k"}, Usage: `Encryption key used to encrypt the secret`},
 		&cli.StringFlag{Name: "key-env", Usage: `Environment variable name containing the encryption key`},
+		&cli.StringFlag{Name: "key-file", Usage: `File containing the encryption key`},
+		&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: `Runpfile to encrypt in place`},
+		&cli.StringFlag{Name: "path", Usage: `Section of the Runpfile to encrypt, as in "units.db.container.env", default is the whole file`},
+	},
+}
+var commandDecrypt = cli.Command{
+	Name:        "decrypt",
+	Usage:       "decrypt --reveal [--key KEY] [--key-env KEYENV] [--key-file KEYFILE] ENCRYPTED",
+	Description: `Decrypt a secret value and print it in plain text`,
+	Action:      doDecrypt,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "key", Aliases: []string{"k"}, Usage: `Encryption key used to encrypt the secret`},
+		&cli.StringFlag{Name: "key-env", Usage: `Environment variable name containing the encryption key`},
+		&cli.StringFlag{Name: "key-file", Usage: `File containing the encryption key`},
+		&cli.BoolFlag{Name: "reveal", Usage: `Confirm printing the secret in plain text`},
+	},
+}
+var commandRekey = cli.Command{
+	Name:        "rekey",
+	Usage:       "rekey [--old-key KEY] [--old-key-env KEYENV] [--old-key-file KEYFILE] [--new-key KEY] [--new-key-env KEYENV] [--new-key-file KEYFILE] [--file RUNPFILE]",
+	Description: `Encrypt again using a new key every encrypted value in the Runpfile, in the included ones and in vault files`,
+	Action:      doRekey,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Value: configFileBaseName, Usage: `Path to Runpfile`},
+		&cli.StringFlag{Name: "old-key", Usage: `Encryption key used to encrypt the values`},
+		&cli.StringFlag{Name: "old-key-env", Usage: `Environment variable name containing the old encryption key`},
+		&cli.StringFlag{Name: "old-key-file", Usage: `File containing the old encryption key`},
+		&cli.StringFlag{Name: "new-key", Usage: `Encryption key to use`},
+		&cli.StringFlag{Name: "new-key-env", Usage: `Environment variable name containing the new encryption key`},
+		&cli.StringFlag{Name: "new-key-file", Usage: `File containing the new encryption key`},
 	},
 }
 var commandList = cli.Command{
 	Name:        "list",
 	Aliases:     []string{"ls"},
-	Usage:       "list",
+	Usage:       "list [--verbose] [--key KEY] [--key-env KEYENV] [--key-file KEYFILE]",
 	Description: `List all units defined in the Runpfile`,
 	Action:      doList,
 	Flags: []cli.Flag{
 		&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Value: configFileBaseName, Usage: `Path to Runpfile`},
+		&cli.BoolFlag{Name: "verbose", Aliases: []string{"v"}, Usage: `Show the resolved settings of every unit`},
+		&cli.StringFlag{Name: "key", Aliases: []string{"k"}, Usage: `Encryption key used to decrypt encrypted sections`},
+		&cli.StringFlag{Name: "key-env", Usage: `Environment variable name containing the encryption key`},
+		&cli.StringFlag{Name: "key-file", Usage: `File containing the encryption key`},
+	},
+}
+var commandVars = cli.Command{
+	Name:        "vars",
+	Usage:       "vars [--var K=V] [--var-file FILE] [--key KEY] [--key-env KEYENV] [--key-file KEYFILE] [--file RUNPFILE]",
+	Description: `Print the resolved variables and the source of each value`,
+	Action:      doVars,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Value: configFileBaseName, Usage: `Path to Runpfile`},
+		&cli.StringSliceFlag{Name: "var", Aliases: []string{"V"}, Usage: `Runtime variables in format "key=value"`},
+		&cli.StringSliceFlag{Name: "var-file", Usage: `File with variables, YAML (.yml, .yaml) or dotenv format`},
+		&cli.StringFlag{Name: "key", Aliases: []string{"k"}, Usage: `Encryption key used to decrypt encrypted sections`},
+		&cli.StringFlag{Name: "key-env", Usage: `Environment variable name containing the encryption key`},
+		&cli.StringFlag{Name: "key-file", Usage: `File containing the encryption key`},
+	},
+}
+var commandValidate = cli.Command{
+	Name:        "validate",
+	Usage:       "validate [--var K=V] [--var-file FILE] [--key KEY] [--key-env KEYENV] [--key-file KEYFILE] [--file RUNPFILE]",
+	Description: `Validate the Runpfile without running it: exit code 0 if valid, 2 if invalid`,
+	Action:      doValidate,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Value: configFileBaseName, Usage: `Path to Runpfile`},
+		&cli.StringSliceFlag{Name: "var", Aliases: []string{"V"}, Usage: `Runtime variables in format "key=value"`},
+		&cli.StringSliceFlag{Name: "var-file", Usage: `File with variables, YAML (.yml, .yaml) or dotenv format`},
+		&cli.StringFlag{Name: "key", Aliases: []string{"k"}, Usage: `Encryption key used to decrypt encrypted sections`},
+		&cli.StringFlag{Name: "key-env", Usage: `Environment variable name containing the encryption key`},
+		&cli.StringFlag{Name: "key-file", Usage: `File containing the encryption key`},
+	},
+}
+
+var commandSchema = cli.Command{
+	Name:        "schema",
+	Usage:       "schema",
+	Description: `Print the JSON Schema of the Runpfile format, for editors and linters`,
+	Action:      doSchema,
+}
+
+var commandConfig = cli.Command{
+	Name:        "config",
+	Usage:       "config [--format yaml|json] [--var K=V] [--var-file FILE] [--key KEY] [--key-env KEYENV] [--key-file KEYFILE] [--file RUNPFILE]",
+	Description: `Print the resolved Runpfile: includes and templates merged, vars applied, paths, shells and container command lines resolved, secrets redacted`,
+	Action:      doConfig,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Value: configFileBaseName, Usage: `Path to Runpfile`},
+		&cli.StringFlag{Name: "format", Value: "yaml", Usage: `Output format: yaml or json`},
+		&cli.StringSliceFlag{Name: "var", Aliases: []string{"V"}, Usage: `Runtime variables in format "key=value"`},
+		&cli.StringSliceFlag{Name: "var-file", Usage: `File with variables, YAML (.yml, .yaml) or dotenv format`},
+		&cli.StringFlag{Name: "key", Aliases: []string{"k"}, Usage: `Encryption key used to decrypt encrypted sections`},
+		&cli.StringFlag{Name: "key-env", Usage: `Environment variable name containing the encryption key`},
+		&cli.StringFlag{Name: "key-file", Usage: `File containing the encryption key`},
+	},
+}
+
+var commandImport = cli.Command{
+	Name:        "import",
+	Usage:       "import compose [--file COMPOSEFILE] [--output RUNPFILE] [--force]",
+	Description: `Create a Runpfile from other formats`,
+	Subcommands: []*cli.Command{
+		{
+			Name:        "compose",
+			Usage:       "compose [--file COMPOSEFILE] [--output RUNPFILE] [--force]",
+			Description: `Create a Runpfile with a container unit for every docker-compose service`,
+			Action:      doImportCompose,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Value: "docker-compose.yml", Usage: `Path to docker-compose file`},
+				&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Value: configFileBaseName, Usage: `Path to the Runpfile to write, "-" for stdout`},
+				&cli.BoolFlag{Name: "force", Usage: `Overwrite the output file if it exists`},
+			},
+		},
 	},
 }
 
@@ -57,13 +171,13 @@ func exitError(exitCode int, message string) error {
 }
 
 // when error it returns an `exitError`
-func loadRunpfile(f string) (*core.Runpfile, error) {
+func loadRunpfile(f string, key string) (*core.Runpfile, error) {
 	runpfilePath, err := core.ResolveRunpfilePath(f)
 	if err != nil {
 		return &core.Runpfile{}, exitErrorf(2, "Runpfile %s not found", runpfilePath)
 	}
 	ui.Debugf("Using Runpfile %s", runpfilePath)
-	runpfile, err := core.LoadRunpfileFromPath(runpfilePath)
+	runpfile, err := core.LoadRunpfileFromPathWithKey(runpfilePath, key)
 	if err != nil {
 		return &core.Runpfile{}, exitErrorf(2, "Failed to load Runpfile %s: %s", runpfilePath, err.Error())
 	}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -104,7 +105,7 @@ func TestLoadRunpfile(t *testing.T) {
 
 	// Success case
 	t.Run("success", func(t *testing.T) {
-		runpfile, err := loadRunpfile("../../testdata/runpfiles/env.yml")
+		runpfile, err := loadRunpfile("../../testdata/runpfiles/env.yml", "")
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
 		}
@@ -119,7 +120,7 @@ func TestLoadRunpfile(t *testing.T) {
 
 	// File not found case
 	t.Run("file not found", func(t *testing.T) {
-		_, err := loadRunpfile("non-existent-file.yml")
+		_, err := loadRunpfile("non-existent-file.yml", "")
 		if err == nil {
 			t.Fatal("Expected an error for non-existent file, got nil")
 		}
@@ -148,7 +149,7 @@ func TestLoadRunpfile(t *testing.T) {
 		}
 		tmpfile.Close()
 
-		_, err = loadRunpfile(tmpfile.Name())
+		_, err = loadRunpfile(tmpfile.Name(), "")
 		if err == nil {
 			t.Fatal("Expected an error for invalid file, got nil")
 		}
@@ -163,7 +164,7 @@ func TestLoadRunpfile(t *testing.T) {
 
 	// Invalid runpfile structure case
 	t.Run("invalid structure", func(t *testing.T) {
-		_, err := loadRunpfile("../../testdata/runpfiles/validation-error-01.yml")
+		_, err := loadRunpfile("../../testdata/runpfiles/validation-error-01.yml", "")
 		if err == nil {
 			t.Fatal("Expected an error for invalid runpfile structure, got nil")
 		}
@@ -285,6 +286,28 @@ func TestDoUpVarMissingEquals(t *testing.T) {
 	}
 }
 
+func TestDoUpDryRun(t *testing.T) {
+	s := &stubLogger{}
+	ui = s
+	core.ConfigureUI(s, core.LoggerConfig{})
+
+	app := cli.NewApp()
+	set := flag.NewFlagSet("test", 0)
+	set.String("f", "../../testdata/runpfiles/templates/Runpfile.yml", "doc")
+	set.Bool("dry-run", true, "doc")
+	c := cli.NewContext(app, set, nil)
+
+	if err := doUp(c); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	output := strings.Join(s.lines, "\n")
+	for _, expected := range []string{"Dry run: no unit is started", "billing (host): starts immediately", "./run --port 8083"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output containing <%s>, got\n%s", expected, output)
+		}
+	}
+}
+
 func TestDoList(t *testing.T) {
 	s := &stubLogger{}
 	ui = s
@@ -332,6 +355,73 @@ func TestDoList(t *testing.T) {
 			t.Errorf("Expected exit code 2, got %d", exitErr.ExitCode())
 		}
 	})
+
+	t.Run("verbose", func(t *testing.T) {
+		s.lines = []string{}
+		app := cli.NewApp()
+		set := flag.NewFlagSet("test", 0)
+		set.String("f", "../../testdata/runpfiles/ssh-config.yml", "doc")
+		set.Bool("verbose", true, "doc")
+		c := cli.NewContext(app, set, nil)
+
+		err := doList(c)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		output := s.getLines()
+		for _, expected := range []string{
+			"- bastion (SSH tunnel localhost:5432 -> bastion.example.com:2222 -> db.internal:5432)",
+			"    ssh config host: bastion-prod",
+			"    user: deploy",
+			"    identity file: ~/.ssh/bastion_ed25519",
+		} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("Expected output to contain '%s', got '%s'", expected, output)
+			}
+		}
+	})
+}
+
+func TestDoVars(t *testing.T) {
+	s := &stubLogger{}
+	ui = s
+	core.ConfigureUI(s, core.LoggerConfig{})
+	t.Setenv("RUNP_VAR_FROM_ENVIRONMENT", "environment")
+	t.Setenv("RUNP_VAR_NOT_DECLARED", "ignored")
+
+	app := cli.NewApp()
+	set := flag.NewFlagSet("test", 0)
+	set.String("f", "../../testdata/vars-sources/Runpfile", "doc")
+	varFlag := cli.StringSlice{}
+	varFlag.Set("from_flag=flag")
+	set.Var(&varFlag, "var", "doc")
+	varFileFlag := cli.StringSlice{}
+	varFileFlag.Set("../../testdata/vars-sources/vars.yml")
+	varFileFlag.Set("../../testdata/vars-sources/vars.env")
+	set.Var(&varFileFlag, "var-file", "doc")
+	c := cli.NewContext(app, set, nil)
+
+	if err := doVars(c); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	output := s.getLines()
+	for _, expected := range []string{
+		"from_runpfile=runpfile (Runpfile)",
+		"from_dotenv=dotenv (.env ",
+		"from_var_file=yaml (var file ../../testdata/vars-sources/vars.yml)",
+		"from_env_file=env file (var file ../../testdata/vars-sources/vars.env)",
+		"from_environment=environment (environment RUNP_VAR_FROM_ENVIRONMENT)",
+		"from_flag=flag (--var)",
+		"runp_root=",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain '%s', got '%s'", expected, output)
+		}
+	}
+	if strings.Contains(strings.ToLower(output), "not_declared=") {
+		t.Errorf("Expected undeclared environment var ignored, got '%s'", output)
+	}
 }
 
 func TestDoEncrypt(t *testing.T) {
@@ -472,4 +562,260 @@ func TestDoEncrypt(t *testing.T) {
 			t.Errorf("Expected error message to contain 'is empty', got '%s'", exitErr.Error())
 		}
 	})
+
+	t.Run("encrypt file section", func(t *testing.T) {
+		s.lines = []string{}
+		runpfile := filepath.Join(t.TempDir(), "Runpfile")
+		content := "units:\n  db:\n    container:\n      image: postgres\n      env:\n        POSTGRES_PASSWORD: pa$$word\n"
+		if err := os.WriteFile(runpfile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		encrypt := func(key string) error {
+			app := cli.NewApp()
+			set := flag.NewFlagSet("test", 0)
+			set.String("key", key, "doc")
+			set.String("file", runpfile, "doc")
+			set.String("path", "units.db.container.env", "doc")
+			c := cli.NewContext(app, set, nil)
+			return doEncrypt(c)
+		}
+
+		if err := encrypt(""); err == nil || !strings.Contains(err.Error(), "Encryption key required to encrypt a file") {
+			t.Fatalf("Expected error for missing key, got %v", err)
+		}
+		if err := encrypt("testkey123"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		output := s.getLines()
+		if !strings.Contains(output, "Encrypted section units.db.container.env in") {
+			t.Errorf("Expected output to contain 'Encrypted section units.db.container.env in', got '%s'", output)
+		}
+		runpf, err := loadRunpfile(runpfile, "testkey123")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if runpf.Units["db"].Container.Env["POSTGRES_PASSWORD"] != "pa$$word" {
+			t.Errorf("Expected encrypted section decrypted, got %v", runpf.Units["db"].Container.Env)
+		}
+	})
+}
+
+func TestDoDecrypt(t *testing.T) {
+	s := &stubLogger{}
+	ui = s
+	core.ConfigureUI(s, core.LoggerConfig{})
+	encrypted, err := core.EncryptToBase64([]byte("secret-value"), "testkey123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "runp.key")
+	if err := os.WriteFile(keyFile, []byte("testkey123\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		flags    map[string]string
+		reveal   bool
+		arg      string
+		expected string
+		err      string
+	}{
+		{"key", map[string]string{"key": "testkey123"}, true, encrypted, "Decrypted secret: secret-value", ""},
+		{"key-file and prefix", map[string]string{"key-file": keyFile}, true, "encrypted:" + encrypted, "Decrypted secret: secret-value", ""},
+		{"no reveal", map[string]string{"key": "testkey123"}, false, encrypted, "", "add --reveal to confirm"},
+		{"no key", map[string]string{}, true, encrypted, "", "Encryption key required"},
+		{"wrong key", map[string]string{"key": "other"}, true, encrypted, "", "Decryption operation failed"},
+		{"missing key file", map[string]string{"key-file": keyFile + ".missing"}, true, encrypted, "", "Failed to read key file"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s.lines = []string{}
+			app := cli.NewApp()
+			set := flag.NewFlagSet("test", 0)
+			for name, value := range tc.flags {
+				set.String(name, value, "doc")
+			}
+			set.Bool("reveal", tc.reveal, "doc")
+			set.Parse([]string{tc.arg})
+			c := cli.NewContext(app, set, nil)
+
+			err := doDecrypt(c)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error containing '%s', got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if output := s.getLines(); !strings.Contains(output, tc.expected) {
+				t.Errorf("Expected output to contain '%s', got '%s'", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestDoRekey(t *testing.T) {
+	s := &stubLogger{}
+	ui = s
+	core.ConfigureUI(s, core.LoggerConfig{})
+	dir := t.TempDir()
+	encrypted, err := core.EncryptToBase64([]byte("secret-value"), "oldkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runpfile := filepath.Join(dir, "Runpfile")
+	content := fmt.Sprintf("units:\n  app:\n    host:\n      command: echo\n      env:\n        PASSWORD: encrypted:%s\n", encrypted)
+	if err := os.WriteFile(runpfile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	newKeyFile := filepath.Join(dir, "new.key")
+	if err := os.WriteFile(newKeyFile, []byte("newkey"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rekey := func(oldKey string) error {
+		app := cli.NewApp()
+		set := flag.NewFlagSet("test", 0)
+		set.String("f", runpfile, "doc")
+		set.String("old-key", oldKey, "doc")
+		set.String("new-key-file", newKeyFile, "doc")
+		c := cli.NewContext(app, set, nil)
+		return doRekey(c)
+	}
+
+	if err := rekey("wrongkey"); err == nil || !strings.Contains(err.Error(), "cannot decrypt value using the old key") {
+		t.Fatalf("Expected error for wrong old key, got %v", err)
+	}
+	if data, _ := os.ReadFile(runpfile); string(data) != content {
+		t.Errorf("Expected Runpfile unchanged after a failed rekey, got %s", data)
+	}
+
+	s.lines = []string{}
+	if err := rekey("oldkey"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if output := s.getLines(); !strings.Contains(output, "Rekeyed 1 value(s) in") {
+		t.Errorf("Expected output to contain 'Rekeyed 1 value(s) in', got '%s'", output)
+	}
+	data, err := os.ReadFile(runpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := strings.TrimSpace(strings.SplitN(string(data), "encrypted:", 2)[1])
+	if plain, err := core.DecryptBase64(value, "newkey"); err != nil || string(plain) != "secret-value" {
+		t.Errorf("Expected value encrypted using the new key, got <%s> %v", plain, err)
+	}
+}
+
+func TestDoConfig(t *testing.T) {
+	s := &stubLogger{}
+	ui = s
+	core.ConfigureUI(s, core.LoggerConfig{})
+
+	testCases := []struct {
+		file     string
+		format   string
+		expected string
+	}{
+		{"../../testdata/runpfiles/templates/Runpfile.yml", "yaml", ""},
+		{"../../testdata/runpfiles/config/Runpfile.yml", "json", ""},
+		{"../../testdata/runpfiles/config/Runpfile.yml", "toml", "Invalid format toml"},
+		{"../../testdata/runpfiles/templates/missing-param.yml", "yaml", "param port not defined"},
+	}
+	for _, tc := range testCases {
+		app := cli.NewApp()
+		set := flag.NewFlagSet("test", 0)
+		set.String("f", tc.file, "doc")
+		set.String("format", tc.format, "doc")
+		c := cli.NewContext(app, set, nil)
+
+		err := doConfig(c)
+		if tc.expected == "" && err != nil {
+			t.Errorf("%s: expected no error, got %v", tc.file, err)
+		}
+		if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Errorf("%s: expected error containing '%s', got %v", tc.file, tc.expected, err)
+		}
+	}
+}
+
+func TestDoValidate(t *testing.T) {
+	s := &stubLogger{}
+	ui = s
+	core.ConfigureUI(s, core.LoggerConfig{})
+
+	for file, expected := range map[string]int{
+		"../../testdata/runpfiles/templates/Runpfile.yml": 0,
+		"../../testdata/runpfiles/validate/Runpfile.yml":  2,
+		"../../testdata/runpfiles/missing.yml":            2,
+	} {
+		app := cli.NewApp()
+		set := flag.NewFlagSet("test", 0)
+		set.String("f", file, "doc")
+		c := cli.NewContext(app, set, nil)
+
+		err := doValidate(c)
+		if expected == 0 {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", file, err)
+			}
+			continue
+		}
+		exitErr, ok := err.(cli.ExitCoder)
+		if !ok || exitErr.ExitCode() != expected {
+			t.Errorf("%s: expected exit code %d, got %v", file, expected, err)
+		}
+	}
+}
+
+func TestDoSchema(t *testing.T) {
+	s := &stubLogger{}
+	ui = s
+	core.ConfigureUI(s, core.LoggerConfig{})
+
+	c := cli.NewContext(cli.NewApp(), flag.NewFlagSet("test", 0), nil)
+	if err := doSchema(c); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestDoImportCompose(t *testing.T) {
+	s := &stubLogger{}
+	ui = s
+	core.ConfigureUI(s, core.LoggerConfig{})
+
+	output := fmt.Sprintf("%s/Runpfile", t.TempDir())
+	newContext := func(force bool) *cli.Context {
+		set := flag.NewFlagSet("test", 0)
+		set.String("file", "../../testdata/compose/docker-compose.yml", "doc")
+		set.String("output", output, "doc")
+		set.Bool("force", force, "doc")
+		return cli.NewContext(cli.NewApp(), set, nil)
+	}
+
+	if err := doImportCompose(newContext(false)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	runpfile, err := loadRunpfile(output, "")
+	if err != nil {
+		t.Fatalf("Generated Runpfile not loadable: %v", err)
+	}
+	if len(runpfile.Units) != 2 {
+		t.Errorf("Expected 2 units, got %d", len(runpfile.Units))
+	}
+	if !strings.Contains(s.getLines(), `key "restart" is not supported`) {
+		t.Errorf("Expected unsupported keys to be reported, got '%s'", s.getLines())
+	}
+
+	err = doImportCompose(newContext(false))
+	exitErr, ok := err.(cli.ExitCoder)
+	if !ok || exitErr.ExitCode() != 2 || !strings.Contains(exitErr.Error(), "already exists") {
+		t.Errorf("Expected exit error for existing output, got %v", err)
+	}
+	if err := doImportCompose(newContext(true)); err != nil {
+		t.Errorf("Expected overwrite with --force, got %v", err)
+	}
 }
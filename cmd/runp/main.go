@@ -22,6 +22,7 @@ Build date: %s
 func listenForShutdown(ch <-chan os.Signal) {
 	<-ch
 	appContext.SetShuttingDown()
+	appContext.RemoveSessionFile()
 	runningProcesses := appContext.GetRunningProcesses()
 	ui.Debug("Initiating graceful shutdown sequence")
 	if len(runningProcesses) == 0 {
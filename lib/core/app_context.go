@@ -1,6 +1,7 @@
 package core
 
 import (
+	"os"
 	"sync"
 )
 
@@ -10,6 +11,7 @@ type ApplicationContext struct {
 	runningProcesses map[string]RunpProcess
 	report           []string
 	shuttingDown     bool
+	sessionFile      string
 }
 
 // RegisterRunningProcess add process to the list of running ones.
@@ -57,6 +59,26 @@ func (c *ApplicationContext) IsShuttingDown() bool {
 	return c.shuttingDown
 }
 
+// SetSessionFile sets the path of the session file, removed on shutdown.
+func (c *ApplicationContext) SetSessionFile(path string) {
+	c.Lock()
+	defer c.Unlock()
+	c.sessionFile = path
+}
+
+// RemoveSessionFile removes the session file, if any.
+func (c *ApplicationContext) RemoveSessionFile() {
+	c.Lock()
+	defer c.Unlock()
+	if c.sessionFile == "" {
+		return
+	}
+	if err := os.Remove(c.sessionFile); err != nil && !os.IsNotExist(err) {
+		ui.Debugf("Failed to remove session file %s: %v", c.sessionFile, err)
+	}
+	c.sessionFile = ""
+}
+
 var (
 	once     sync.Once
 	instance *ApplicationContext
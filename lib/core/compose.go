@@ -0,0 +1,510 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	composeDefaultAwaitTimeout = "30s"
+	composeDefaultName         = "Imported from docker-compose"
+)
+
+// supported service keys, the mapping to ContainerProcess is in serviceToUnit
+var composeServiceKeys = []string{
+	"image",
+	"container_name",
+	"ports",
+	"volumes",
+	"volumes_from",
+	"environment",
+	"command",
+	"working_dir",
+	"shm_size",
+	"depends_on",
+	"healthcheck",
+}
+
+type composeHealthcheck struct {
+	Test        yaml.Node
+	Interval    string
+	Timeout     string
+	Retries     int
+	StartPeriod string `yaml:"start_period"`
+	Disable     bool
+}
+
+type composeService struct {
+	name string
+	keys map[string]yaml.Node
+}
+
+// isComposeData returns true if data looks like a docker-compose file: a top level `services` key and no `units`.
+func isComposeData(data []byte) bool {
+	top := map[string]yaml.Node{}
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return false
+	}
+	_, services := top["services"]
+	_, units := top["units"]
+	return services && !units
+}
+
+// RunpfileFromCompose converts a docker-compose file in a Runpfile where every service is a container unit.
+// It returns the list of compose keys not supported by Runp.
+func RunpfileFromCompose(data []byte) (*Runpfile, []string, error) {
+	top := map[string]yaml.Node{}
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return nil, nil, err
+	}
+	warnings := []string{}
+	rf := &Runpfile{
+		Name:  composeDefaultName,
+		Units: map[string]*RunpUnit{},
+	}
+	for _, k := range sortedNodeKeys(top) {
+		v := top[k]
+		switch {
+		case k == "services":
+		case k == "version":
+			// obsolete in the compose specification
+		case k == "name":
+			rf.Name = v.Value
+		case strings.HasPrefix(k, "x-"):
+			// extension fields
+		default:
+			warnings = append(warnings, fmt.Sprintf("top level key %q is not supported", k))
+		}
+	}
+	servicesNode, ok := top["services"]
+	if !ok {
+		return nil, warnings, fmt.Errorf("no services defined in compose file")
+	}
+	rawServices := map[string]map[string]yaml.Node{}
+	if err := servicesNode.Decode(&rawServices); err != nil {
+		return nil, warnings, err
+	}
+	services := map[string]composeService{}
+	for name, keys := range rawServices {
+		services[name] = composeService{name: name, keys: keys}
+	}
+	for _, name := range sortedServiceNames(services) {
+		unit, ws, err := serviceToUnit(services[name], services)
+		warnings = append(warnings, ws...)
+		if err != nil {
+			return nil, warnings, err
+		}
+		rf.Units[name] = unit
+	}
+	return rf, warnings, nil
+}
+
+// ImportCompose converts a docker-compose file in the YAML of the equivalent Runpfile.
+func ImportCompose(data []byte) ([]byte, []string, error) {
+	rf, warnings, err := RunpfileFromCompose(data)
+	if err != nil {
+		return nil, warnings, err
+	}
+	doc := mappingNode()
+	appendScalar(doc, "name", rf.Name)
+	appendScalar(doc, "description", "Runpfile generated by `runp import compose`")
+	units := mappingNode()
+	names := make([]string, 0, len(rf.Units))
+	for name := range rf.Units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		unit := mappingNode()
+		appendScalar(unit, "description", rf.Units[name].Description)
+		appendNode(unit, "container", containerNode(rf.Units[name].Container))
+		appendNode(units, name, unit)
+	}
+	appendNode(doc, "units", units)
+	out, err := yaml.Marshal(doc)
+	return out, warnings, err
+}
+
+func serviceToUnit(service composeService, services map[string]composeService) (*RunpUnit, []string, error) {
+	warnings := []string{}
+	for _, k := range sortedNodeKeys(service.keys) {
+		if !sliceContains(composeServiceKeys, k) && !strings.HasPrefix(k, "x-") {
+			warnings = append(warnings, fmt.Sprintf("service %s: key %q is not supported", service.name, k))
+		}
+	}
+	image := service.scalar("image")
+	if image == "" {
+		return nil, warnings, fmt.Errorf("service %s: image is required (build is not supported)", service.name)
+	}
+	container := &ContainerProcess{
+		Image:      image,
+		Name:       service.scalar("container_name"),
+		WorkingDir: service.scalar("working_dir"),
+		ShmSize:    service.scalar("shm_size"),
+	}
+	// keep the service name as host name, like containers in a compose network
+	if container.Name == "" {
+		container.Name = service.name
+	}
+	var err error
+	if container.Ports, err = service.ports(); err != nil {
+		return nil, warnings, err
+	}
+	if container.Volumes, container.Mounts, err = service.volumes(); err != nil {
+		return nil, warnings, err
+	}
+	if container.VolumesFrom, err = service.stringList("volumes_from"); err != nil {
+		return nil, warnings, err
+	}
+	if container.Env, err = service.environment(); err != nil {
+		return nil, warnings, err
+	}
+	if container.Command, err = service.command(); err != nil {
+		return nil, warnings, err
+	}
+	await, ws, err := service.await(services)
+	warnings = append(warnings, ws...)
+	if err != nil {
+		return nil, warnings, err
+	}
+	container.Await = await
+	unit := &RunpUnit{
+		Name:        service.name,
+		Description: fmt.Sprintf("Compose service %s", service.name),
+		Container:   container,
+	}
+	return unit, warnings, nil
+}
+
+func (s composeService) scalar(key string) string {
+	n, ok := s.keys[key]
+	if !ok {
+		return ""
+	}
+	return n.Value
+}
+
+func (s composeService) stringList(key string) ([]string, error) {
+	n, ok := s.keys[key]
+	if !ok {
+		return nil, nil
+	}
+	var out []string
+	if err := n.Decode(&out); err != nil {
+		return nil, fmt.Errorf("service %s: invalid %s: %v", s.name, key, err)
+	}
+	return out, nil
+}
+
+// ports supports both the short ("8080:80") and the long syntax.
+func (s composeService) ports() ([]string, error) {
+	n, ok := s.keys["ports"]
+	if !ok {
+		return nil, nil
+	}
+	ports := []string{}
+	for _, p := range n.Content {
+		if p.Kind == yaml.ScalarNode {
+			ports = append(ports, p.Value)
+			continue
+		}
+		var long struct {
+			Target    string
+			Published string
+			HostIP    string `yaml:"host_ip"`
+			Protocol  string
+		}
+		if err := p.Decode(&long); err != nil {
+			return nil, fmt.Errorf("service %s: invalid port: %v", s.name, err)
+		}
+		port := long.Target
+		if long.Published != "" {
+			port = long.Published + ":" + port
+		}
+		if long.HostIP != "" {
+			port = long.HostIP + ":" + port
+		}
+		if long.Protocol != "" {
+			port = port + "/" + long.Protocol
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// volumes returns short syntax entries as volumes and long syntax entries as mounts.
+// Relative host paths are resolved against the Runpfile directory.
+func (s composeService) volumes() ([]string, []string, error) {
+	n, ok := s.keys["volumes"]
+	if !ok {
+		return nil, nil, nil
+	}
+	volumes := []string{}
+	mounts := []string{}
+	for _, v := range n.Content {
+		if v.Kind == yaml.ScalarNode {
+			volumes = append(volumes, composeHostPath(v.Value))
+			continue
+		}
+		var long struct {
+			Type     string
+			Source   string
+			Target   string
+			ReadOnly bool `yaml:"read_only"`
+		}
+		if err := v.Decode(&long); err != nil {
+			return nil, nil, fmt.Errorf("service %s: invalid volume: %v", s.name, err)
+		}
+		if long.Type == "" {
+			long.Type = "volume"
+		}
+		parts := []string{"type=" + long.Type}
+		if long.Source != "" {
+			parts = append(parts, "src="+composeHostPath(long.Source))
+		}
+		parts = append(parts, "dst="+long.Target)
+		if long.ReadOnly {
+			parts = append(parts, "readonly")
+		}
+		mounts = append(mounts, strings.Join(parts, ","))
+	}
+	return volumes, mounts, nil
+}
+
+func composeHostPath(p string) string {
+	if p == "." || p == "./" {
+		return "{{vars runp_root}}"
+	}
+	if strings.HasPrefix(p, "./") {
+		return "{{vars runp_root}}/" + strings.TrimPrefix(p, "./")
+	}
+	if strings.HasPrefix(p, "../") {
+		return "{{vars runp_root}}/" + p
+	}
+	return p
+}
+
+// environment supports both the list ("KEY=value") and the mapping syntax.
+// Keys without value are taken from the runp environment.
+func (s composeService) environment() (map[string]string, error) {
+	n, ok := s.keys["environment"]
+	if !ok {
+		return nil, nil
+	}
+	env := map[string]string{}
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i].Value, n.Content[i+1]
+			if v.Tag == "!!null" {
+				env[k] = "${" + k + "}"
+				continue
+			}
+			env[k] = v.Value
+		}
+		return env, nil
+	}
+	items := []string{}
+	if err := n.Decode(&items); err != nil {
+		return nil, fmt.Errorf("service %s: invalid environment: %v", s.name, err)
+	}
+	for _, item := range items {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) == 1 {
+			env[kv[0]] = "${" + kv[0] + "}"
+			continue
+		}
+		env[kv[0]] = kv[1]
+	}
+	return env, nil
+}
+
+func (s composeService) command() (string, error) {
+	n, ok := s.keys["command"]
+	if !ok {
+		return "", nil
+	}
+	if n.Kind == yaml.ScalarNode {
+		return n.Value, nil
+	}
+	args := []string{}
+	if err := n.Decode(&args); err != nil {
+		return "", fmt.Errorf("service %s: invalid command: %v", s.name, err)
+	}
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"'$") {
+			args[i] = strconv.Quote(a)
+		}
+	}
+	return strings.Join(args, " "), nil
+}
+
+func (s composeService) dependencies() ([]string, error) {
+	n, ok := s.keys["depends_on"]
+	if !ok {
+		return nil, nil
+	}
+	if n.Kind == yaml.MappingNode {
+		deps := []string{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			deps = append(deps, n.Content[i].Value)
+		}
+		return deps, nil
+	}
+	return s.stringList("depends_on")
+}
+
+// await maps depends_on to the first published port of the dependency.
+// The timeout is computed from the dependency healthcheck.
+// A unit awaits a single resource: services depending on more services are not imported.
+func (s composeService) await(services map[string]composeService) (AwaitCondition, []string, error) {
+	warnings := []string{}
+	deps, err := s.dependencies()
+	if err != nil || len(deps) == 0 {
+		return AwaitCondition{}, warnings, err
+	}
+	for _, d := range deps {
+		if _, ok := services[d]; !ok {
+			return AwaitCondition{}, warnings, fmt.Errorf("service %s: depends on unknown service %s", s.name, d)
+		}
+	}
+	if len(deps) > 1 {
+		return AwaitCondition{}, warnings, fmt.Errorf("service %s: depends on %s but a unit awaits a single service, keep one in depends_on",
+			s.name, strings.Join(deps, ", "))
+	}
+	dep := services[deps[0]]
+	await := AwaitCondition{Timeout: dep.healthcheckTimeout()}
+	ports, err := dep.ports()
+	if err != nil {
+		return AwaitCondition{}, warnings, err
+	}
+	for _, p := range ports {
+		if hp := hostPort(p); hp != "" {
+			await.Resource = fmt.Sprintf("tcp4://localhost:%s/", hp)
+			break
+		}
+	}
+	if await.Resource == "" {
+		warnings = append(warnings, fmt.Sprintf("service %s: dependency %s publishes no port, waiting %s", s.name, dep.name, await.Timeout))
+	}
+	return await, warnings, nil
+}
+
+// healthcheckTimeout returns start_period + interval * retries, the time compose needs to declare the service unhealthy.
+func (s composeService) healthcheckTimeout() string {
+	n, ok := s.keys["healthcheck"]
+	if !ok {
+		return composeDefaultAwaitTimeout
+	}
+	hc := composeHealthcheck{}
+	if err := n.Decode(&hc); err != nil || hc.Disable {
+		return composeDefaultAwaitTimeout
+	}
+	interval, err := time.ParseDuration(hc.Interval)
+	if err != nil {
+		interval = 30 * time.Second
+	}
+	retries := hc.Retries
+	if retries == 0 {
+		retries = 3
+	}
+	total := interval * time.Duration(retries)
+	if sp, err := time.ParseDuration(hc.StartPeriod); err == nil {
+		total += sp
+	}
+	return total.String()
+}
+
+// hostPort returns the published port of a mapping like "127.0.0.1:8080:80/tcp".
+func hostPort(mapping string) string {
+	mapping = strings.SplitN(mapping, "/", 2)[0]
+	parts := strings.Split(mapping, ":")
+	if len(parts) < 2 {
+		return ""
+	}
+	hp := parts[len(parts)-2]
+	if _, err := strconv.Atoi(hp); err != nil {
+		return ""
+	}
+	return hp
+}
+
+func sortedNodeKeys(m map[string]yaml.Node) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedServiceNames(m map[string]composeService) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containerNode(c *ContainerProcess) *yaml.Node {
+	n := mappingNode()
+	appendScalar(n, "image", c.Image)
+	appendScalar(n, "name", c.Name)
+	appendScalar(n, "workdir", c.WorkingDir)
+	appendScalar(n, "shm_size", c.ShmSize)
+	appendScalar(n, "command", c.Command)
+	appendList(n, "ports", c.Ports)
+	appendList(n, "volumes", c.Volumes)
+	appendList(n, "volumes_from", c.VolumesFrom)
+	appendList(n, "mounts", c.Mounts)
+	if len(c.Env) > 0 {
+		env := mappingNode()
+		keys := make([]string, 0, len(c.Env))
+		for k := range c.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			appendScalar(env, k, c.Env[k])
+		}
+		appendNode(n, "env", env)
+	}
+	if c.Await.Timeout != "" {
+		await := mappingNode()
+		appendScalar(await, "resource", c.Await.Resource)
+		appendScalar(await, "timeout", c.Await.Timeout)
+		appendNode(n, "await", await)
+	}
+	return n
+}
+
+func mappingNode() *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
+
+func appendNode(n *yaml.Node, key string, value *yaml.Node) {
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func appendScalar(n *yaml.Node, key string, value string) {
+	if value == "" {
+		return
+	}
+	appendNode(n, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+func appendList(n *yaml.Node, key string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, v := range values {
+		seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v, Style: yaml.DoubleQuotedStyle})
+	}
+	appendNode(n, key, seq)
+}
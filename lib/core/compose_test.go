@@ -0,0 +1,131 @@
+package core
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestRunpfileFromCompose(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	data, err := os.ReadFile("../../testdata/compose/docker-compose.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf, warnings, err := RunpfileFromCompose(data)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if rf.Name != "shop" {
+		t.Errorf("Expected name shop, got %s", rf.Name)
+	}
+	if len(rf.Units) != 2 {
+		t.Fatalf("Expected 2 units, got %d", len(rf.Units))
+	}
+
+	db := rf.Units["db"].Container
+	if db.Name != "db" {
+		t.Errorf("Expected container name db, got %s", db.Name)
+	}
+	assertSliceEquals(db.Ports, []string{"5432:5432"}, "db ports", t)
+	assertSliceEquals(db.Volumes, []string{"{{vars runp_root}}/data:/var/lib/postgresql/data"}, "db volumes", t)
+	assertSliceEquals(db.Mounts, []string{"type=volume,src=dbcache,dst=/cache,readonly"}, "db mounts", t)
+	if db.Env["POSTGRES_USER"] != "user" || db.Env["HOME"] != "${HOME}" {
+		t.Errorf("Unexpected db env %v", db.Env)
+	}
+
+	app := rf.Units["app"].Container
+	if app.Name != "shop-app" {
+		t.Errorf("Expected container name shop-app, got %s", app.Name)
+	}
+	if app.Command != "serve --port 8080" {
+		t.Errorf("Expected command 'serve --port 8080', got '%s'", app.Command)
+	}
+	assertSliceEquals(app.Ports, []string{"8000:8080"}, "app ports", t)
+	if app.Env["DB_HOST"] != "db" || app.Env["DEBUG"] != "${DEBUG}" {
+		t.Errorf("Unexpected app env %v", app.Env)
+	}
+	if app.Await.Resource != "tcp4://localhost:5432/" {
+		t.Errorf("Expected await on db port, got '%s'", app.Await.Resource)
+	}
+	if app.Await.Timeout != "40s" {
+		t.Errorf("Expected await timeout from db healthcheck 40s, got '%s'", app.Await.Timeout)
+	}
+
+	expectedWarnings := []string{
+		`top level key "networks" is not supported`,
+		`top level key "volumes" is not supported`,
+		`service app: key "networks" is not supported`,
+		`service app: key "restart" is not supported`,
+	}
+	assertSliceEquals(warnings, expectedWarnings, "warnings", t)
+}
+
+func TestRunpfileFromComposeErrors(t *testing.T) {
+	testCases := []struct {
+		data     string
+		expected string
+	}{
+		{data: "services:\n  web:\n    build: .\n", expected: "image is required"},
+		{data: "services:\n  web:\n    image: x\n    depends_on: [db]\n", expected: "unknown service db"},
+		{data: "services:\n  db:\n    image: x\n  web:\n    image: x\n    depends_on: [db, cache]\n", expected: "unknown service cache"},
+		{data: "services:\n  db:\n    image: x\n  cache:\n    image: x\n  web:\n    image: x\n    depends_on: [db, cache]\n",
+			expected: "service web: depends on db, cache but a unit awaits a single service, keep one in depends_on"},
+		{data: "version: '3'\n", expected: "no services"},
+	}
+	for _, tc := range testCases {
+		_, _, err := RunpfileFromCompose([]byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("Expected error containing '%s', got %v", tc.expected, err)
+		}
+	}
+}
+
+func TestImportCompose(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/compose/docker-compose.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := ImportCompose(data)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf := &Runpfile{}
+	if err := unmarshalStrict(out, rf); err != nil {
+		t.Fatalf("Generated Runpfile is not valid:\n%s\n%v", out, err)
+	}
+	if valid, errs := IsRunpfileValid(rf); !valid {
+		t.Errorf("Generated Runpfile is not valid: %v", errs)
+	}
+	if rf.Units["app"].Container.Await.Resource != "tcp4://localhost:5432/" {
+		t.Errorf("Await not written in generated Runpfile:\n%s", out)
+	}
+}
+
+func TestIsComposeData(t *testing.T) {
+	if !isComposeData([]byte("services:\n  web:\n    image: x\n")) {
+		t.Error("Expected compose data to be detected")
+	}
+	rf, _ := yaml.Marshal(map[string]interface{}{"units": map[string]string{}})
+	if isComposeData(rf) {
+		t.Error("Expected Runpfile not to be detected as compose data")
+	}
+}
+
+func TestIncludeCompose(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	rp, err := LoadRunpfileFromPath("../../testdata/runpfiles/include/compose.yml")
+	if err != nil {
+		t.Fatalf("Load error %v", err)
+	}
+	for _, u := range []string{"unit-in-compose", "db", "app"} {
+		if _, ok := rp.Units[u]; !ok {
+			t.Errorf("Expected unit %s in %v", u, rp.Units)
+		}
+	}
+	if rp.Units["db"].Container == nil {
+		t.Error("Expected compose service to be a container unit")
+	}
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ResolvedConfig is the Runpfile as runp executes it: includes, templates and overrides merged,
+// paths resolved and vars applied. Secrets and encrypted values are redacted.
+type ResolvedConfig struct {
+	Name        string                  `yaml:"name,omitempty" json:"name,omitempty"`
+	Description string                  `yaml:"description,omitempty" json:"description,omitempty"`
+	Root        string                  `yaml:"root" json:"root"`
+	Vars        map[string]string       `yaml:"vars,omitempty" json:"vars,omitempty"`
+	Units       map[string]ResolvedUnit `yaml:"units" json:"units"`
+}
+
+// ResolvedUnit is a unit as runp executes it.
+type ResolvedUnit struct {
+	Kind        string `yaml:"kind" json:"kind"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	// directory of the Runpfile declaring the unit
+	Root    string `yaml:"root,omitempty" json:"root,omitempty"`
+	Workdir string `yaml:"workdir,omitempty" json:"workdir,omitempty"`
+	// shell running the command line of host units
+	Shell string `yaml:"shell,omitempty" json:"shell,omitempty"`
+	// executable and arguments of host and kube forward units
+	Command []string `yaml:"command,omitempty" json:"command,omitempty"`
+	// command line of container units
+	CommandLine string `yaml:"command_line,omitempty" json:"command_line,omitempty"`
+	// environment set by runp, without the inherited variables
+	Env         map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	Await       *AwaitCondition   `yaml:"await,omitempty" json:"await,omitempty"`
+	StopTimeout string            `yaml:"stop_timeout" json:"stop_timeout"`
+	// tunnel endpoints and proxy routes
+	Details []string `yaml:"details,omitempty" json:"details,omitempty"`
+}
+
+// NewResolvedConfig returns the resolved configuration of the Runpfile, with the vars in rf.Vars.
+// Secret providers are not called: secrets and encrypted values are printed as ********.
+func NewResolvedConfig(rf *Runpfile) (*ResolvedConfig, error) {
+	e := NewExecutor(rf)
+	e.dryRun = true
+	e.initializeUnits()
+	defer setActiveSecrets(nil)
+
+	errs := []string{}
+	ids := make([]string, 0, len(rf.Units))
+	for id, unit := range rf.Units {
+		ids = append(ids, id)
+		for _, err := range append(unit.templateErrors(), unit.resolvePorts()...) {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, errors.Errorf("invalid Runpfile:\n- %s", strings.Join(errs, "\n- "))
+	}
+	sort.Strings(ids)
+	config := &ResolvedConfig{
+		Name:        rf.Name,
+		Description: rf.Description,
+		Root:        rf.Root,
+		Units:       map[string]ResolvedUnit{},
+	}
+	if len(rf.Vars) > 0 {
+		config.Vars = map[string]string{}
+		for name, value := range rf.Vars {
+			config.Vars[name] = redact(value)
+		}
+	}
+	for _, id := range ids {
+		unit, err := resolveUnit(rf.Units[id])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unit %s", id)
+		}
+		config.Units[id] = unit
+	}
+	return config, nil
+}
+
+func resolveUnit(u *RunpUnit) (ResolvedUnit, error) {
+	process := u.Process()
+	ru := ResolvedUnit{
+		Kind:        strings.Join(u.processKinds(), ","),
+		Description: u.Description,
+		Root:        u.root,
+		StopTimeout: process.StopTimeout().String(),
+	}
+	p := newCliPreprocessor(u.vars)
+	var err error
+	switch {
+	case u.Host != nil:
+		ru.Workdir = u.Host.resolveWorkingDir()
+		if u.Host.Executable != "" {
+			ru.Command = append([]string{u.Host.Executable}, p.processArgs(u.Host.Args)...)
+		} else {
+			exe, args, _ := u.Host.resolveShell()
+			ru.Shell = strings.Join(append([]string{exe}, args[:len(args)-1]...), " ")
+			ru.Command = append([]string{exe}, p.processArgs(args)...)
+		}
+		var fileEnv map[string]string
+		if fileEnv, err = loadEnvFiles(u.Host.EnvFile, u.Host.root, u.vars); err != nil {
+			return ru, err
+		}
+		ru.Env = resolvedEnv(mergeEnv(u.Host.globalEnv, fileEnv, u.Host.Env), p)
+	case u.Container != nil:
+		ru.Workdir = u.Container.WorkingDir
+		if ru.CommandLine, err = resolvedContainerCommandLine(u.Container); err != nil {
+			return ru, err
+		}
+	case u.SSHTunnel != nil:
+		ru.Details = append(u.SSHTunnel.Details(), "forwards: "+u.SSHTunnel.ForwardsDescription())
+		ru.Env = resolvedEnv(u.SSHTunnel.Env, p)
+	case u.KubeForward != nil:
+		ru.Command = append([]string{u.KubeForward.kubectl()}, u.KubeForward.buildArgs()...)
+		ru.Env = resolvedEnv(mergeEnv(u.KubeForward.globalEnv, u.KubeForward.Env), p)
+	case u.Proxy != nil:
+		ru.Details = []string{"listen: " + u.Proxy.Listen.String(), "routes: " + u.Proxy.RoutesDescription()}
+	}
+	ru.Await = resolvedAwait(u.Process())
+	ru.Workdir = redact(ru.Workdir)
+	ru.CommandLine = redact(ru.CommandLine)
+	for i, detail := range ru.Details {
+		ru.Details[i] = redact(detail)
+	}
+	for i, arg := range ru.Command {
+		ru.Command[i] = redact(arg)
+	}
+	return ru, nil
+}
+
+// resolvedEnv returns the env with vars applied, encrypted values are redacted.
+func resolvedEnv(env map[string]string, p *cliPreprocessor) map[string]string {
+	if len(env) == 0 {
+		return nil
+	}
+	resolved := map[string]string{}
+	for name, value := range env {
+		if isEncryptedEnv(value) {
+			resolved[name] = redactedText
+			continue
+		}
+		resolved[name] = redact(os.ExpandEnv(p.process(value)))
+	}
+	return resolved
+}
+
+// resolvedAwait returns the await condition of the process with vars applied, nil if not set.
+func resolvedAwait(process RunpProcess) *AwaitCondition {
+	a := AwaitCondition{Resource: process.AwaitResource(), Timeout: process.AwaitTimeout()}
+	if a.Resource == "" && a.Timeout == "" {
+		return nil
+	}
+	return &a
+}
+
+// resolvedContainerCommandLine returns the command line of the container: encrypted values are redacted
+// and the container runner is printed as configured if it is not found.
+func resolvedContainerCommandLine(c *ContainerProcess) (string, error) {
+	redacted := *c
+	redacted.Env = redactedEncryptedEnv(c.Env)
+	redacted.globalEnv = redactedEncryptedEnv(c.globalEnv)
+	runner := c.environmentSettings.ContainerRunnerExe
+	if path, err := exec.LookPath(runner); err == nil {
+		runner = path
+	}
+	cl, _, err := redacted.commandLine(runner)
+	return cl, err
+}
+
+func redactedEncryptedEnv(env map[string]string) map[string]string {
+	out := map[string]string{}
+	for name, value := range env {
+		if isEncryptedEnv(value) {
+			value = redactedText
+		}
+		out[name] = value
+	}
+	return out
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolvedConfig(t *testing.T) {
+	setupTestUI(t)
+	t.Setenv("RUNP_CONFIG_TEST_TOKEN", "plain-token")
+	rf, err := LoadRunpfileFromPath("../../testdata/runpfiles/config/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.Vars = map[string]string{"api_port": "9090", "runp_root": rf.Root}
+	config, err := NewResolvedConfig(rf)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if config.Name != "config" || config.Root != rf.Root || len(config.Units) != 3 {
+		t.Fatalf("Unexpected config %+v", config)
+	}
+
+	api := config.Units["api"]
+	shell := defaultShell()
+	expectedCommand := append(append([]string{shell.Path}, shell.Args...), "./api --port 9090")
+	if strings.Join(api.Command, " ") != strings.Join(expectedCommand, " ") {
+		t.Errorf("Unit api, expected command %q, got %q", expectedCommand, api.Command)
+	}
+	if expected := strings.Join(append([]string{shell.Path}, shell.Args...), " "); api.Shell != expected {
+		t.Errorf("Unit api, expected shell <%s>, got <%s>", expected, api.Shell)
+	}
+	if expected := filepath.Join(rf.Root, "api"); api.Workdir != expected {
+		t.Errorf("Unit api, expected workdir <%s>, got <%s>", expected, api.Workdir)
+	}
+	assertMapEquals(api.Env, map[string]string{"API_TOKEN": redactedText, "LOG_LEVEL": "info"}, "api env", t)
+	if api.Await == nil || api.Await.Resource != "tcp4://localhost:5432/" {
+		t.Errorf("Unit api, expected await, got %+v", api.Await)
+	}
+
+	tool := config.Units["tool"]
+	if strings.Join(tool.Command, " ") != "echo "+rf.Root || tool.Shell != "" {
+		t.Errorf("Unit tool, expected executable with vars applied, got %q shell <%s>", tool.Command, tool.Shell)
+	}
+	if tool.StopTimeout != "2s" {
+		t.Errorf("Unit tool, expected stop timeout 2s, got %s", tool.StopTimeout)
+	}
+
+	db := config.Units["db"]
+	if db.Kind != "container" {
+		t.Errorf("Unit db, expected kind container, got %s", db.Kind)
+	}
+	for _, expected := range []string{" run ", "--name runp-db", "-p 5432:5432", `-e "POSTGRES_PASSWORD=` + redactedText + `"`, "docker.io/postgres:16"} {
+		if !strings.Contains(db.CommandLine, expected) {
+			t.Errorf("Unit db, expected command line containing <%s>, got <%s>", expected, db.CommandLine)
+		}
+	}
+	if strings.Contains(db.CommandLine, "cnVucAEBAAGG") {
+		t.Errorf("Unit db, expected encrypted value redacted, got <%s>", db.CommandLine)
+	}
+}
+
+func TestResolvedConfigErrors(t *testing.T) {
+	setupTestUI(t)
+	rf, err := LoadRunpfileFromPath("../../testdata/runpfiles/config/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.Vars = map[string]string{"runp_root": rf.Root}
+	_, err = NewResolvedConfig(rf)
+	if err == nil || !strings.Contains(err.Error(), "unit api:") {
+		t.Errorf("Expected error for the missing var, got %v", err)
+	}
+}
+
+func TestResolvedConfigEncryptedVars(t *testing.T) {
+	setupTestUI(t)
+	restoreRedacted(t)
+	data := []byte(`vars:
+  db_password: hunter2secret
+units:
+  tunnel:
+    ssh_tunnel:
+      user: tunnel-user-secret
+      auth:
+        identity_file: /keys/tunnel
+      local:
+        port: 15432
+      jump:
+        host: sshserver
+        port: 22
+      target:
+        host: db
+        port: 5432
+`)
+	var err error
+	for _, path := range []string{"vars", "units.tunnel.ssh_tunnel"} {
+		if data, err = EncryptRunpfileData(data, path, "thekey"); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	runpfile := filepath.Join(t.TempDir(), "Runpfile")
+	if err := os.WriteFile(runpfile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := LoadRunpfileFromPathWithKey(runpfile, "thekey")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.SecretKey = "thekey"
+	config, err := NewResolvedConfig(rf)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	assertMapEquals(config.Vars, map[string]string{"db_password": redactedText}, "config vars", t)
+	details := strings.Join(config.Units["tunnel"].Details, "\n")
+	if strings.Contains(details, "tunnel-user-secret") || !strings.Contains(details, "user: "+redactedText) {
+		t.Errorf("Expected encrypted tunnel settings redacted, got %s", details)
+	}
+	if rf.Vars["db_password"] != "hunter2secret" {
+		t.Errorf("Expected Runpfile vars unchanged, got %v", rf.Vars)
+	}
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"os"
 	"strings"
 	"testing"
 )
@@ -147,3 +148,43 @@ await:
 
 	assertContainerProcess(cp, expected, t)
 }
+
+func TestContainerCommandLineVars(t *testing.T) {
+	setupTestUI(t)
+	p := &ContainerProcess{
+		Name:       "{{vars name}}",
+		Image:      "{{vars image}}",
+		Ports:      []string{"{{vars port}}:5432"},
+		WorkingDir: "/{{vars name}}",
+		Env:        map[string]string{"LITERAL": `{{"{{"}}vars name{{"}}"}}`},
+		Command:    "postgres -c {{vars setting}}",
+		vars: map[string]string{
+			"name":    "db",
+			"image":   "postgres:16",
+			"port":    "15432",
+			"setting": "fsync=off",
+		},
+		environmentSettings: &EnvironmentSettings{},
+	}
+	cl, _, err := p.commandLine("docker")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := `docker run -t --rm --name db --network runp-network --workdir /db -p 15432:5432 -e "LITERAL={{vars name}}" postgres:16 postgres -c fsync=off `
+	if cl != expected {
+		t.Errorf("Expected command line\n%s\ngot\n%s", expected, cl)
+	}
+	// the command line is not processed again
+	runner, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.environmentSettings.ContainerRunnerExe = runner
+	c, err := p.buildCmdImage()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if actual := c.Args[len(c.Args)-1]; !strings.HasSuffix(actual, expected[len("docker"):]) {
+		t.Errorf("Expected command line ending with\n%s\ngot\n%s", expected[len("docker"):], actual)
+	}
+}
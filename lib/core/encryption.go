@@ -1,13 +1,16 @@
 package core
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/pbkdf2"
@@ -17,32 +20,40 @@ const (
 	kdfSaltSize = 16
 	kdfIter     = 100_000
 	kdfKeyLen   = 32
+	// kdfMaxIter bounds the iterations read from a ciphertext header
+	kdfMaxIter = 10_000_000
+
+	// ciphertexts start with the magic followed by the format version
+	ciphertextMagic = "runp"
+	// version 1: magic | version | kdf | iterations (uint32) | salt size | salt | nonce | ciphertext+tag
+	ciphertextV1 = 1
+	// PBKDF2 with SHA-256
+	kdfPBKDF2SHA256 = 1
+
+	randomKeySize = 32
 )
 
-func deriveKey(passphrase string, salt []byte) []byte {
-	return pbkdf2.Key([]byte(passphrase), salt, kdfIter, kdfKeyLen, sha256.New)
+func deriveKey(passphrase string, salt []byte, iter int) []byte {
+	return pbkdf2.Key([]byte(passphrase), salt, iter, kdfKeyLen, sha256.New)
 }
 
 // RandomKey generates a random string usable as key to encrypt secrets
 func RandomKey() string {
-	b := make([]byte, 6)
+	b := make([]byte, randomKeySize)
 	if _, err := io.ReadFull(rand.Reader, b); err != nil {
 		panic("crypto/rand unavailable: " + err.Error())
 	}
 	return hex.EncodeToString(b)
 }
 
-// Encrypt a secret using passphrase. Ciphertext format: salt | nonce | ciphertext+tag.
+// Encrypt a secret using passphrase. Ciphertext format is the version 1:
+// magic | version | kdf | iterations | salt size | salt | nonce | ciphertext+tag.
 func Encrypt(data []byte, passphrase string) ([]byte, error) {
 	salt := make([]byte, kdfSaltSize)
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
 		return nil, err
 	}
-	block, err := aes.NewCipher(deriveKey(passphrase, salt))
-	if err != nil {
-		return nil, err
-	}
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(passphrase, salt, kdfIter)
 	if err != nil {
 		return nil, err
 	}
@@ -50,13 +61,25 @@ func Encrypt(data []byte, passphrase string) ([]byte, error) {
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
-	out := make([]byte, 0, kdfSaltSize+gcm.NonceSize()+len(data)+gcm.Overhead())
+	out := make([]byte, 0, len(ciphertextMagic)+8+kdfSaltSize+gcm.NonceSize()+len(data)+gcm.Overhead())
+	out = append(out, ciphertextMagic...)
+	out = append(out, ciphertextV1, kdfPBKDF2SHA256)
+	out = binary.BigEndian.AppendUint32(out, kdfIter)
+	out = append(out, byte(kdfSaltSize))
 	out = append(out, salt...)
 	out = append(out, nonce...)
 	out = gcm.Seal(out, nonce, data, nil)
 	return out, nil
 }
 
+func newGCM(passphrase string, salt []byte, iter int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(passphrase, salt, iter))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 // EncryptToBase64 encrypts secret and encodes in base64
 func EncryptToBase64(data []byte, passphrase string) (string, error) {
 	encrypted, err := Encrypt(data, passphrase)
@@ -66,19 +89,54 @@ func EncryptToBase64(data []byte, passphrase string) (string, error) {
 	return base64.StdEncoding.EncodeToString(encrypted), nil
 }
 
-// Decrypt a secret using passphrase. Expects ciphertext format: salt | nonce | ciphertext+tag.
+// Decrypt a secret using passphrase. Ciphertexts without header are in the original format:
+// salt | nonce | ciphertext+tag, with the default KDF parameters.
+// The random salt of the original format can start with the magic: if the header is invalid or the
+// ciphertext is not authenticated, the original format is tried before returning the error.
 func Decrypt(data []byte, passphrase string) ([]byte, error) {
-	if len(data) < kdfSaltSize {
-		return nil, errors.New("ciphertext too short")
+	if !bytes.HasPrefix(data, []byte(ciphertextMagic)) {
+		return decryptPayload(data, passphrase, kdfSaltSize, kdfIter)
 	}
-	salt := data[:kdfSaltSize]
-	data = data[kdfSaltSize:]
-
-	block, err := aes.NewCipher(deriveKey(passphrase, salt))
+	plain, err := decryptV1(data[len(ciphertextMagic):], passphrase)
 	if err != nil {
+		if legacy, legacyErr := decryptPayload(data, passphrase, kdfSaltSize, kdfIter); legacyErr == nil {
+			return legacy, nil
+		}
 		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	return plain, nil
+}
+
+// decryptV1 decrypts version | kdf | iterations | salt size | salt | nonce | ciphertext+tag.
+func decryptV1(header []byte, passphrase string) ([]byte, error) {
+	if len(header) < 1 {
+		return nil, errors.New("ciphertext too short")
+	}
+	if header[0] != ciphertextV1 {
+		return nil, fmt.Errorf("unsupported ciphertext version %d", header[0])
+	}
+	if len(header) < 7 {
+		return nil, errors.New("ciphertext too short")
+	}
+	if header[1] != kdfPBKDF2SHA256 {
+		return nil, fmt.Errorf("unsupported key derivation function %d", header[1])
+	}
+	iter := binary.BigEndian.Uint32(header[2:6])
+	if iter == 0 || iter > kdfMaxIter {
+		return nil, fmt.Errorf("invalid key derivation iterations %d", iter)
+	}
+	return decryptPayload(header[7:], passphrase, int(header[6]), int(iter))
+}
+
+// decryptPayload decrypts salt | nonce | ciphertext+tag.
+func decryptPayload(data []byte, passphrase string, saltSize int, iter int) ([]byte, error) {
+	if saltSize == 0 || len(data) < saltSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	salt := data[:saltSize]
+	data = data[saltSize:]
+
+	gcm, err := newGCM(passphrase, salt, iter)
 	if err != nil {
 		return nil, err
 	}
@@ -10,7 +10,7 @@ import (
 const pbkdf2SaltSize = 16
 
 func TestEncrypt_EmbedsSalt(t *testing.T) {
-	// Ciphertext must be salt | nonce | data | GCM-tag.
+	// Ciphertext must be header | salt | nonce | data | GCM-tag.
 	// With MD5-only key derivation (no salt) the length is nonce+data+tag, which is shorter.
 	passphrase := "test-passphrase"
 	plaintext := []byte("hello")
@@ -22,16 +22,18 @@ func TestEncrypt_EmbedsSalt(t *testing.T) {
 
 	block, _ := aes.NewCipher(make([]byte, 32))
 	gcm, _ := cipher.NewGCM(block)
-	want := pbkdf2SaltSize + gcm.NonceSize() + len(plaintext) + gcm.Overhead()
+	// magic, version, kdf, iterations, salt size
+	headerSize := len(ciphertextMagic) + 1 + 1 + 4 + 1
+	want := headerSize + pbkdf2SaltSize + gcm.NonceSize() + len(plaintext) + gcm.Overhead()
 	if len(ct) != want {
-		t.Errorf("ciphertext len = %d, want %d (salt %d + nonce %d + data %d + tag %d)",
-			len(ct), want, pbkdf2SaltSize, gcm.NonceSize(), len(plaintext), gcm.Overhead())
+		t.Errorf("ciphertext len = %d, want %d (header %d + salt %d + nonce %d + data %d + tag %d)",
+			len(ct), want, headerSize, pbkdf2SaltSize, gcm.NonceSize(), len(plaintext), gcm.Overhead())
 	}
 }
 
 func TestRandomKey_UsesCryptoRand(t *testing.T) {
 	// With math/rand the key space is bounded by the PRNG state; crypto/rand
-	// gives full 48-bit entropy for a 12-char hex key.
+	// gives full 256-bit entropy for a 64-char hex key.
 	// We can't inspect the source, but we can verify that 1000 consecutive
 	// calls never collide — math/rand would occasionally repeat under load.
 	seen := make(map[string]struct{}, 1000)
@@ -45,25 +47,25 @@ func TestRandomKey_UsesCryptoRand(t *testing.T) {
 }
 
 func TestRandomKey(t *testing.T) {
-	// Verifica che RandomKey restituisca sempre una stringa di 12 caratteri esadecimali
+	// Verifica che RandomKey restituisca sempre una stringa di 64 caratteri esadecimali
 	key1 := RandomKey()
-	if len(key1) != 12 {
-		t.Errorf("Expected key length 12, got %d", len(key1))
+	if len(key1) != 64 {
+		t.Errorf("Expected key length 64, got %d", len(key1))
 	}
 
 	// Verifica che sia composta solo da caratteri esadecimali
-	hexPattern := regexp.MustCompile(`^[0-9a-f]{12}$`)
+	hexPattern := regexp.MustCompile(`^[0-9a-f]{64}$`)
 	if !hexPattern.MatchString(key1) {
-		t.Errorf("Expected key to be 12 hex characters, got '%s'", key1)
+		t.Errorf("Expected key to be 64 hex characters, got '%s'", key1)
 	}
 
 	// Verifica che generi chiavi diverse (molto probabile, ma non garantito)
 	key2 := RandomKey()
-	if len(key2) != 12 {
-		t.Errorf("Expected key2 length 12, got %d", len(key2))
+	if len(key2) != 64 {
+		t.Errorf("Expected key2 length 64, got %d", len(key2))
 	}
 	if !hexPattern.MatchString(key2) {
-		t.Errorf("Expected key2 to be 12 hex characters, got '%s'", key2)
+		t.Errorf("Expected key2 to be 64 hex characters, got '%s'", key2)
 	}
 
 	// Genera diverse chiavi per assicurarsi che funzioni
@@ -71,11 +73,11 @@ func TestRandomKey(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		key := RandomKey()
 		keys[key] = true
-		if len(key) != 12 {
-			t.Errorf("Expected key length 12 at iteration %d, got %d", i, len(key))
+		if len(key) != 64 {
+			t.Errorf("Expected key length 64 at iteration %d, got %d", i, len(key))
 		}
 		if !hexPattern.MatchString(key) {
-			t.Errorf("Expected key to be 12 hex characters at iteration %d, got '%s'", i, key)
+			t.Errorf("Expected key to be 64 hex characters at iteration %d, got '%s'", i, key)
 		}
 	}
 
@@ -163,3 +165,65 @@ func TestDecryptionError(t *testing.T) {
 	}
 
 }
+
+// encryptLegacy encrypts in the format without header: salt | nonce | ciphertext+tag.
+func encryptLegacy(t *testing.T, data []byte, passphrase string) []byte {
+	return encryptLegacyWithSalt(t, data, passphrase, []byte("0123456789abcdef"))
+}
+
+func encryptLegacyWithSalt(t *testing.T, data []byte, passphrase string, salt []byte) []byte {
+	gcm, err := newGCM(passphrase, salt, kdfIter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	return gcm.Seal(append(salt, nonce...), nonce, data, nil)
+}
+
+func TestDecryptLegacyFormat(t *testing.T) {
+	dec, err := Decrypt(encryptLegacy(t, []byte("legacy"), "secret"), "secret")
+	if err != nil || string(dec) != "legacy" {
+		t.Errorf("Expected legacy ciphertext decrypted, got <%s> %v", dec, err)
+	}
+}
+
+func TestDecryptLegacySaltStartingWithMagic(t *testing.T) {
+	salts := [][]byte{
+		// valid header, not authenticated
+		[]byte("runp\x01\x01\x00\x00\x00\x01\x10abcde"),
+		[]byte("runp\x09abcdefghijk"),
+		[]byte("runp\x01\x01\xff\xff\xff\xffabcdef"),
+	}
+	for _, salt := range salts {
+		ct := encryptLegacyWithSalt(t, []byte("legacy"), "secret", salt)
+		dec, err := Decrypt(ct, "secret")
+		if err != nil || string(dec) != "legacy" {
+			t.Errorf("Salt %q, expected legacy ciphertext decrypted, got <%s> %v", salt, dec, err)
+		}
+		if _, err := Decrypt(ct, "wrong"); err == nil {
+			t.Errorf("Salt %q, expected error using the wrong key", salt)
+		}
+	}
+}
+
+func TestDecryptUnsupportedHeader(t *testing.T) {
+	ct, err := Encrypt([]byte("message"), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string]func([]byte){
+		"unsupported ciphertext version 9":      func(b []byte) { b[4] = 9 },
+		"unsupported key derivation function 7": func(b []byte) { b[5] = 7 },
+		"invalid key derivation iterations 0":   func(b []byte) { copy(b[6:10], []byte{0, 0, 0, 0}) },
+	}
+	for expected, change := range testCases {
+		b := append([]byte{}, ct...)
+		change(b)
+		if _, err := Decrypt(b, "secret"); err == nil || err.Error() != expected {
+			t.Errorf("Expected error <%s>, got <%v>", expected, err)
+		}
+	}
+	if _, err := Decrypt([]byte(ciphertextMagic), "secret"); err == nil {
+		t.Errorf("Expected error for header only ciphertext")
+	}
+}
@@ -13,11 +13,13 @@ import (
 // EnvironmentSettings represents settings for the current box.
 type EnvironmentSettings struct {
 	ContainerRunnerExe string `yaml:"container_runner"`
+	KubectlExe         string `yaml:"kubectl"`
 }
 
 func loadEnvironmentSettings() *EnvironmentSettings {
 	var es = &EnvironmentSettings{
 		ContainerRunnerExe: "docker",
+		KubectlExe:         "kubectl",
 	}
 	esPath, err := environmentSettingsPath()
 	if err != nil {
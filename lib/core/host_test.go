@@ -291,7 +291,10 @@ func TestHostProcessResolveEnvironmentEmpty(t *testing.T) {
 		vars: map[string]string{},
 	}
 
-	env := p.resolveEnvironment()
+	env, err := p.resolveEnvironment()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
 	if env == nil {
 		t.Error("Expected environment to be non-nil")
 	}
@@ -312,7 +315,10 @@ func TestHostProcessResolveEnvironmentSimple(t *testing.T) {
 		vars: map[string]string{},
 	}
 
-	env := p.resolveEnvironment()
+	env, err := p.resolveEnvironment()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
 	if len(env) != 2 {
 		t.Errorf("Expected 2 environment variables, got %d", len(env))
 	}
@@ -347,7 +353,10 @@ func TestHostProcessResolveEnvironmentWithVars(t *testing.T) {
 		},
 	}
 
-	env := p.resolveEnvironment()
+	env, err := p.resolveEnvironment()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
 	if len(env) != 2 {
 		t.Errorf("Expected 2 environment variables, got %d", len(env))
 	}
@@ -379,7 +388,10 @@ func TestHostProcessResolveEnvironmentUndefinedVar(t *testing.T) {
 		vars: map[string]string{},
 	}
 
-	env := p.resolveEnvironment()
+	env, err := p.resolveEnvironment()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
 	if len(env) != 1 {
 		t.Errorf("Expected 1 environment variable, got %d", len(env))
 	}
@@ -570,7 +582,10 @@ func TestHostProcessResolveEnvironmentEmptyMap(t *testing.T) {
 		vars: map[string]string{"key": "value"},
 	}
 
-	env := p.resolveEnvironment()
+	env, err := p.resolveEnvironment()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
 	if env == nil {
 		t.Error("Expected environment to be non-nil")
 	}
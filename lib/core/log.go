@@ -3,6 +3,7 @@ package core
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"sync"
 
 	ct "github.com/daviddengcn/go-colortext"
@@ -36,6 +37,52 @@ type clogger struct {
 var ci int
 var mutex = new(sync.Mutex)
 
+const (
+	redactedText = "********"
+	// shorter values are not redacted, to keep the output readable
+	redactedMinLength = 4
+)
+
+// values of secrets replaced by redactedText in the output
+var redacted = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// addRedacted registers a secret value to be redacted from all the log output.
+func addRedacted(value string) {
+	if len(value) < redactedMinLength {
+		return
+	}
+	redacted.Lock()
+	defer redacted.Unlock()
+	for _, v := range redacted.values {
+		if v == value {
+			return
+		}
+	}
+	redacted.values = append(redacted.values, value)
+}
+
+// addSecret registers the value of the secret described by name to be redacted, warning if it is too short
+// to be redacted.
+func addSecret(name string, value string) {
+	if value != "" && len(value) < redactedMinLength {
+		ui.WriteLinef("Warning: %s is shorter than %d characters and it is not redacted from the output", name, redactedMinLength)
+	}
+	addRedacted(value)
+}
+
+// redact replaces the secret values in s.
+func redact(s string) string {
+	redacted.RLock()
+	defer redacted.RUnlock()
+	for _, v := range redacted.values {
+		s = strings.ReplaceAll(s, v, redactedText)
+	}
+	return s
+}
+
 func (l *clogger) Debugf(format string, a ...interface{}) (int, error) {
 	if l.debug {
 		return l.WriteLine(fmt.Sprintf(format, a...))
@@ -58,6 +105,7 @@ func (l *clogger) WriteLine(line string) (int, error) {
 	if len(line) == 0 {
 		return 0, nil
 	}
+	line = redact(line)
 	mutex.Lock()
 	if l.colors {
 		ct.ChangeColor(labelColors[l.idx].foreground, false, labelColors[l.idx].background, false)
@@ -83,7 +131,7 @@ func (l *clogger) Write(p []byte) (int, error) {
 	for {
 		line, err := buf.ReadBytes('\n')
 		if len(line) > 1 {
-			s := string(line)
+			s := redact(string(line))
 
 			mutex.Lock()
 			if l.colors {
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// VarSourcePorts is the source of vars set to the ports allocated for the `ports` section.
+const VarSourcePorts = "ports"
+
+// AllocatePorts returns a free local port for each name. The ports are distinct: all the listeners
+// are kept open until every port is allocated.
+func AllocatePorts(names []string) (map[string]int, error) {
+	ports := map[string]int{}
+	listeners := []net.Listener{}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for _, name := range names {
+		if _, ok := ports[name]; ok {
+			return nil, errors.Errorf("duplicate port %s", name)
+		}
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot allocate port %s", name)
+		}
+		listeners = append(listeners, l)
+		ports[name] = l.Addr().(*net.TCPAddr).Port
+	}
+	return ports, nil
+}
+
+// resolveEndpointPorts resolves the port templates of all the endpoints reachable from v,
+// which must be a pointer.
+func resolveEndpointPorts(v reflect.Value, vars map[string]string, errs *[]error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			resolveEndpointPorts(v.Elem(), vars, errs)
+		}
+	case reflect.Struct:
+		if e, ok := v.Addr().Interface().(*Endpoint); ok {
+			if err := e.resolvePort(vars); err != nil {
+				*errs = append(*errs, err)
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				resolveEndpointPorts(v.Field(i), vars, errs)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			resolveEndpointPorts(v.Index(i), vars, errs)
+		}
+	}
+}
+
+// resolvePorts sets the ports given as templates in the unit settings and returns the errors.
+func (u *RunpUnit) resolvePorts() []error {
+	errs := []error{}
+	found := []error{}
+	resolveEndpointPorts(reflect.ValueOf(u.Process()), u.vars, &found)
+	for _, err := range found {
+		errs = append(errs, fmt.Errorf("unit %s: %v", u.Name, err))
+	}
+	return errs
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+func TestAllocatePorts(t *testing.T) {
+	ports, err := AllocatePorts([]string{"port_db", "port_web"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if ports["port_db"] == 0 || ports["port_web"] == 0 || ports["port_db"] == ports["port_web"] {
+		t.Errorf("Expected two distinct ports, got %v", ports)
+	}
+	if _, err := AllocatePorts([]string{"port_db", "port_db"}); err == nil || !strings.Contains(err.Error(), "duplicate port port_db") {
+		t.Errorf("Expected duplicate port error, got %v", err)
+	}
+}
+
+func TestEndpointPortTemplate(t *testing.T) {
+	testCases := []struct {
+		spec     string
+		vars     map[string]string
+		expected int
+		err      string
+	}{
+		{"port: 5432", nil, 5432, ""},
+		{"host: db", nil, 0, ""},
+		{`port: "{{vars port_db}}"`, map[string]string{"port_db": "40000"}, 40000, ""},
+		{"port: db", nil, 0, `invalid port "db"`},
+		{`port: "{{vars port_db}}"`, map[string]string{"port_db": "x"}, 0, `invalid port "x"`},
+		{`port: "{{vars port_db}}"`, map[string]string{}, 0, `var port_db not defined`},
+	}
+	for _, tc := range testCases {
+		e := Endpoint{}
+		err := unmarshalStrict([]byte(tc.spec), &e)
+		if err == nil {
+			err = e.resolvePort(tc.vars)
+		}
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error containing <%s>, got <%v>", tc.spec, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.spec, err)
+			continue
+		}
+		if e.Port != tc.expected {
+			t.Errorf("%s: expected port %d, got %d", tc.spec, tc.expected, e.Port)
+		}
+	}
+}
+
+func TestUnitResolvePorts(t *testing.T) {
+	rf := &Runpfile{}
+	err := unmarshalStrict([]byte(`
+units:
+  db:
+    ssh_tunnel:
+      local:
+        port: "{{vars port_db}}"
+      forwards:
+        - local:
+            port: "{{vars port_cache}}"
+          target:
+            host: cache
+            port: 6379
+  proxy:
+    proxy:
+      listen:
+        port: "{{vars port_missing}}"
+      target:
+        port: 80
+`), rf)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	vars := map[string]string{"port_db": "40001", "port_cache": "40002"}
+	for _, u := range rf.Units {
+		u.vars = vars
+	}
+	db := rf.Units["db"]
+	db.Name = "db"
+	if errs := db.resolvePorts(); len(errs) > 0 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	if db.SSHTunnel.Local.Port != 40001 || db.SSHTunnel.Forwards[0].Local.Port != 40002 {
+		t.Errorf("Ports not resolved: %+v", db.SSHTunnel)
+	}
+	proxy := rf.Units["proxy"]
+	proxy.Name = "proxy"
+	errs := proxy.resolvePorts()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "unit proxy: var port_missing not defined") {
+		t.Errorf("Expected error for missing var, got %v", errs)
+	}
+}
+
+func TestLoadVarsPorts(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	rf := &Runpfile{Root: t.TempDir(), Ports: []string{"port_db"}}
+	vars, err := LoadVars(rf, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if port, err := strconv.Atoi(vars.Values["port_db"]); err != nil || port == 0 {
+		t.Errorf("Expected port_db to be a port, got <%s>", vars.Values["port_db"])
+	}
+	if vars.Sources["port_db"] != VarSourcePorts {
+		t.Errorf("Expected source %s, got %s", VarSourcePorts, vars.Sources["port_db"])
+	}
+	t.Setenv("RUNP_VAR_PORT_DB", "5432")
+	vars, err = LoadVars(rf, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if vars.Values["port_db"] != "5432" {
+		t.Errorf("Expected port_db overridden by environment, got <%s>", vars.Values["port_db"])
+	}
+	rf.Vars = map[string]string{"port_db": "1"}
+	if _, err := LoadVars(rf, nil); err == nil || !strings.Contains(err.Error(), "port port_db is declared in vars too") {
+		t.Errorf("Expected error for port declared in vars, got %v", err)
+	}
+}
+
+func TestAwaitPortVar(t *testing.T) {
+	setupTestUI(t)
+	rf := &Runpfile{Root: t.TempDir(), Ports: []string{"port_db"}, Units: map[string]*RunpUnit{
+		"api": {Name: "api", Host: &HostProcess{Executable: "echo", Await: AwaitCondition{
+			Resource: "tcp4://localhost:{{vars port_db}}/", Timeout: "{{vars api_timeout}}"}}},
+	}}
+	vars, err := LoadVars(rf, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.Vars = vars.Values
+	rf.Vars["api_timeout"] = "5s"
+	expected := "tcp4://localhost:" + rf.Vars["port_db"] + "/"
+	listener, err := net.Listen("tcp4", "localhost:"+rf.Vars["port_db"])
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer listener.Close()
+
+	config, err := NewResolvedConfig(rf)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if a := config.Units["api"].Await; a == nil || a.Resource != expected || a.Timeout != "5s" {
+		t.Errorf("Expected await %s with timeout 5s in config, got %+v", expected, a)
+	}
+	e := NewExecutor(rf)
+	plan, err := e.Plan()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if plan.Units[0].AwaitResource != expected || plan.Units[0].AwaitTimeout != "5s" {
+		t.Errorf("Expected await %s with timeout 5s in the plan, got %+v", expected, plan.Units[0])
+	}
+	process := rf.Units["api"].Process()
+	if err := e.handleAwaitResources(process, testLogger, GetApplicationContext()); err != nil {
+		t.Errorf("Expected port %s available, got %v", rf.Vars["port_db"], err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("USERPROFILE", t.TempDir())
+	homedir.DisableCache = true
+	defer func() { homedir.DisableCache = false }()
+
+	rf := &Runpfile{Root: "/projects/checkout-1", Ports: []string{"port_db"}}
+	p, err := WriteSession(NewSession(rf, map[string]string{"port_db": "40001", "other": "x"}))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	other, _ := SessionPath("/projects/checkout-2")
+	if p == other {
+		t.Errorf("Expected distinct session files for distinct checkouts, got %s", p)
+	}
+	s, err := ReadSession(p)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if s.Runpfile != rf.Root || len(s.Ports) != 1 || s.Ports["port_db"] != "40001" {
+		t.Errorf("Unexpected session %+v", s)
+	}
+}
@@ -2,25 +2,58 @@ package core
 
 import (
 	"fmt"
+	"os"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
 )
 
 var (
-	varsRegexp = regexp.MustCompile(`{{[[:space:]]{0,}vars[[:space:]]+([a-z_]+)[[:space:]]{0,}?}}`)
+	// bareVarsRegexp matches the unquoted names of the original syntax: {{vars name}}, and of secrets
+	bareVarsRegexp = regexp.MustCompile(`\b(vars|secret)([[:space:]]+)([A-Za-z_][A-Za-z0-9_.]*)`)
+	// actionNameRegexp matches the first identifier of an action
+	actionNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+	// templateKeywords are the actions and the builtin functions of Go templates
+	templateKeywords = map[string]bool{
+		"if": true, "else": true, "end": true, "range": true, "with": true, "break": true, "continue": true,
+		"and": true, "or": true, "not": true, "len": true, "index": true, "slice": true,
+		"print": true, "printf": true, "println": true,
+		"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	}
 )
 
 func newCliPreprocessor(vars map[string]string) *cliPreprocessor {
 	tp := &cliPreprocessor{
-		vars:             vars,
-		notFoundTemplate: "{!notfound key '%s'!}",
+		vars: vars,
 	}
 	return tp
 }
 
+// cliPreprocessor processes strings as Go templates, see funcs for the available functions.
+// Missing vars are errors unless a default is given.
 type cliPreprocessor struct {
-	re               *regexp.Regexp
-	vars             map[string]string
-	notFoundTemplate string
+	vars map[string]string
+}
+
+// missingVar is the value of a var not defined: it is rendered as empty string and it is an error
+// unless replaced by default.
+type missingVar struct {
+	name string
+}
+
+func (m *missingVar) String() string {
+	return ""
+}
+
+// templateState tracks the missing vars not replaced by default during an execution.
+type templateState struct {
+	vars    map[string]string
+	missing map[*missingVar]bool
 }
 
 func (p *cliPreprocessor) processArgs(args []string) []string {
@@ -31,18 +64,202 @@ func (p *cliPreprocessor) processArgs(args []string) []string {
 	return vsf
 }
 
+// process returns the processed string, or the string unchanged on error.
 func (p *cliPreprocessor) process(s string) string {
-	p.re = varsRegexp
-	return p.re.ReplaceAllStringFunc(s, func(m string) string {
-		parts := p.re.FindStringSubmatch(m)
-		return p.sub(parts[1])
-	})
+	out, err := p.execute(s)
+	if err != nil {
+		// errors are reported checking the units before the start
+		ui.Debugf("Error processing %q: %v", s, err)
+		return s
+	}
+	return out
+}
+
+// check processes all the strings in the exported fields of v and returns the errors.
+func (p *cliPreprocessor) check(v interface{}) []error {
+	errs := []error{}
+	p.walk(reflect.ValueOf(v), &errs)
+	return errs
+}
+
+func (p *cliPreprocessor) walk(v reflect.Value, errs *[]error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			p.walk(v.Elem(), errs)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				p.walk(v.Field(i), errs)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			p.walk(v.Index(i), errs)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			p.walk(iter.Value(), errs)
+		}
+	case reflect.String:
+		if _, err := p.execute(v.String()); err != nil {
+			*errs = append(*errs, err)
+		}
+	}
+}
+
+func (p *cliPreprocessor) execute(s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	state := &templateState{vars: p.vars, missing: map[*missingVar]bool{}}
+	funcs := state.funcs()
+	t, err := template.New("").Option("missingkey=error").Funcs(funcs).Parse(prepareTemplate(s, funcs))
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid template %q", s)
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, p.vars); err != nil {
+		return "", errors.Wrapf(err, "error processing %q", s)
+	}
+	if len(state.missing) > 0 {
+		names := []string{}
+		for m := range state.missing {
+			names = append(names, m.name)
+		}
+		sort.Strings(names)
+		return "", errors.Errorf("var %s not defined in %q: declare it in vars or use default", strings.Join(names, ", "), s)
+	}
+	return sb.String(), nil
+}
+
+// prepareTemplate returns s ready to be parsed: in the actions calling functions or builtins {{vars name}}
+// is turned in {{vars "name"}}, and {{secret name}} in {{secret "name"}}. Other actions, ie the
+// {{.State.Status}} of a docker --format string, and unclosed {{ are kept as literal text.
+func prepareTemplate(s string, funcs template.FuncMap) string {
+	var sb strings.Builder
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			sb.WriteString(s)
+			return sb.String()
+		}
+		sb.WriteString(s[:start])
+		end := actionEnd(s, start+2)
+		if end < 0 {
+			if isTemplateAction(s[start+2:], funcs) {
+				// reported as invalid template
+				sb.WriteString(s[start:])
+				return sb.String()
+			}
+			sb.WriteString(`{{"{{"}}`)
+			s = s[start+2:]
+			continue
+		}
+		action := s[start:end]
+		if isTemplateAction(action[2:len(action)-2], funcs) {
+			sb.WriteString(bareVarsRegexp.ReplaceAllString(action, `$1$2"$3"`))
+		} else {
+			sb.WriteString(`{{` + strconv.Quote(action) + `}}`)
+		}
+		s = s[end:]
+	}
+}
+
+// actionEnd returns the index after the }} closing the action starting at i, skipping quoted strings,
+// or -1 if the action is not closed before the next {{.
+func actionEnd(s string, i int) int {
+	for i < len(s) {
+		switch {
+		case s[i] == '"':
+			i++
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+		case s[i] == '`':
+			i++
+			for i < len(s) && s[i] != '`' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "}}"):
+			return i + 2
+		case strings.HasPrefix(s[i:], "{{"):
+			return -1
+		}
+		i++
+	}
+	return -1
 }
 
-func (p *cliPreprocessor) sub(s string) string {
-	if val, ok := p.vars[s]; ok {
+// isTemplateAction returns true if the action calls a function or a builtin, or it is a string, ie {{"{{"}}.
+func isTemplateAction(action string, funcs template.FuncMap) bool {
+	action = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(action, "- "), " -"))
+	if strings.HasPrefix(action, `"`) || strings.HasPrefix(action, "`") || strings.HasPrefix(action, "/*") {
+		return true
+	}
+	name := actionNameRegexp.FindString(action)
+	if _, ok := funcs[name]; ok {
+		return true
+	}
+	return templateKeywords[name]
+}
+
+// funcs returns the functions available in templates.
+// Functions receiving the result of vars accept any value as last argument, to be used in pipelines.
+func (s *templateState) funcs() template.FuncMap {
+	return template.FuncMap{
+		"vars":       s.lookup,
+		"hasVar":     s.hasVar,
+		"secret":     lookupSecret,
+		"default":    s.defaultValue,
+		"env":        os.Getenv,
+		"upper":      func(v interface{}) string { return strings.ToUpper(s.toString(v)) },
+		"lower":      func(v interface{}) string { return strings.ToLower(s.toString(v)) },
+		"trim":       func(v interface{}) string { return strings.TrimSpace(s.toString(v)) },
+		"trimPrefix": func(prefix string, v interface{}) string { return strings.TrimPrefix(s.toString(v), prefix) },
+		"trimSuffix": func(suffix string, v interface{}) string { return strings.TrimSuffix(s.toString(v), suffix) },
+		"replace":    func(old, new string, v interface{}) string { return strings.ReplaceAll(s.toString(v), old, new) },
+		"contains":   func(sub string, v interface{}) bool { return strings.Contains(s.toString(v), sub) },
+		"hasPrefix":  func(prefix string, v interface{}) bool { return strings.HasPrefix(s.toString(v), prefix) },
+		"hasSuffix":  func(suffix string, v interface{}) bool { return strings.HasSuffix(s.toString(v), suffix) },
+		"quote":      func(v interface{}) string { return strconv.Quote(s.toString(v)) },
+	}
+}
+
+func (s *templateState) lookup(name string) interface{} {
+	if val, ok := s.vars[name]; ok {
 		return val
 	}
-	// should be strategy: leave alone, substitute, throw error...
-	return fmt.Sprintf(p.notFoundTemplate, s)
+	m := &missingVar{name: name}
+	s.missing[m] = true
+	return m
+}
+
+func (s *templateState) hasVar(name string) bool {
+	_, ok := s.vars[name]
+	return ok
+}
+
+// defaultValue returns def if v is a missing var or empty.
+func (s *templateState) defaultValue(def interface{}, v interface{}) interface{} {
+	if m, ok := v.(*missingVar); ok {
+		delete(s.missing, m)
+		return def
+	}
+	if v == nil || v == "" {
+		return def
+	}
+	return v
+}
+
+func (s *templateState) toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
 }
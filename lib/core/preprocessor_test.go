@@ -1,6 +1,7 @@
 package core
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -30,3 +31,114 @@ func TestProcessString02(t *testing.T) {
 		t.Errorf("Expected output '%s', got '%s'\n", expected, actual)
 	}
 }
+
+func TestProcessTemplates(t *testing.T) {
+	t.Setenv("RUNP_TEST_ENV", "from-env")
+	vars := map[string]string{
+		"foo":     "bar",
+		"DB_HOST": "db.local",
+		"db.port": "5432",
+		"empty":   "",
+		"env":     "prod",
+	}
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{`{{vars DB_HOST}}:{{vars db.port}}`, `db.local:5432`},
+		{`{{vars "DB_HOST"}}`, `db.local`},
+		{`{{vars "port" | default "8080"}}`, `8080`},
+		{`{{vars "empty" | default "x"}}`, `x`},
+		{`{{vars "foo" | default "x"}}`, `bar`},
+		{`{{env "RUNP_TEST_ENV"}}`, `from-env`},
+		{`{{env "RUNP_TEST_UNDEFINED" | default "none"}}`, `none`},
+		{`{{vars foo | upper}} {{vars "DB_HOST" | replace "." "-"}} {{vars foo | quote}}`, `BAR db-local "bar"`},
+		{`{{if eq (vars "env") "prod"}}--release{{else}}--debug{{end}}`, `--release`},
+		{`{{if hasVar "debug"}}--verbose{{end}}`, ``},
+		{`{{- vars foo -}}`, `bar`},
+		{`{{printf "%s-%s" (vars foo) "x"}}`, `bar-x`},
+		{`docker ps --format '{{"{{"}}.Names{{"}}"}}'`, `docker ps --format '{{.Names}}'`},
+		{`no template`, `no template`},
+	}
+	cliPreprocessor := newCliPreprocessor(vars)
+	for _, tc := range testCases {
+		actual, err := cliPreprocessor.execute(tc.input)
+		if err != nil {
+			t.Errorf("Unexpected error processing '%s': %v", tc.input, err)
+			continue
+		}
+		if actual != tc.expected {
+			t.Errorf("Processing '%s', expected '%s', got '%s'", tc.input, tc.expected, actual)
+		}
+	}
+}
+
+func TestProcessLiteralActions(t *testing.T) {
+	vars := map[string]string{
+		"name": "db",
+	}
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{`docker inspect --format '{{.State.Status}}' {{vars name}}`, `docker inspect --format '{{.State.Status}}' db`},
+		{`echo '{{.State.Status}}' {{vars name}}`, `echo '{{.State.Status}}' db`},
+		{`docker ps --format '{{json .}}'`, `docker ps --format '{{json .}}'`},
+		{`docker ps --format "table {{.ID}}\t{{.Names}}"`, `docker ps --format "table {{.ID}}\t{{.Names}}"`},
+		{`echo {{ .foo }} {{.}}`, `echo {{ .foo }} {{.}}`},
+		{`echo '{{' {{vars name}}`, `echo '{{' db`},
+		{`{{if hasVar "name"}}{{.Names}}{{end}}`, `{{.Names}}`},
+	}
+	cliPreprocessor := newCliPreprocessor(vars)
+	for _, tc := range testCases {
+		actual, err := cliPreprocessor.execute(tc.input)
+		if err != nil {
+			t.Errorf("Unexpected error processing '%s': %v", tc.input, err)
+			continue
+		}
+		if actual != tc.expected {
+			t.Errorf("Processing '%s', expected '%s', got '%s'", tc.input, tc.expected, actual)
+		}
+	}
+}
+
+func TestProcessTemplatesErrors(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{`{{vars missing}}`, `var missing not defined`},
+		{`{{vars "missing" | upper}}`, `var missing not defined`},
+		{`{{vars foo`, `invalid template`},
+		{`{{if hasVar "foo"}}x`, `invalid template`},
+	}
+	cliPreprocessor := newCliPreprocessor(map[string]string{"foo": "bar"})
+	for _, tc := range testCases {
+		_, err := cliPreprocessor.execute(tc.input)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("Processing '%s', expected error containing '%s', got %v", tc.input, tc.expected, err)
+		}
+		if actual := cliPreprocessor.process(tc.input); actual != tc.input {
+			t.Errorf("Processing '%s', expected string unchanged on error, got '%s'", tc.input, actual)
+		}
+	}
+}
+
+func TestRunpUnitTemplateErrors(t *testing.T) {
+	unit := &RunpUnit{
+		Name: "web",
+		Host: &HostProcess{
+			CommandLine: `echo {{vars foo}}`,
+			Env:         map[string]string{"PORT": `{{vars port}}`},
+			Args:        []string{`{{vars "bar" | default "x"}}`},
+		},
+		vars: map[string]string{"foo": "FOO"},
+	}
+	errs := unit.templateErrors()
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "unit web: var port not defined") {
+		t.Errorf("Unexpected error %v", errs[0])
+	}
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// RekeyedFile is a file rewritten by Rekey.
+type RekeyedFile struct {
+	Path string
+	// number of values encrypted again
+	Values int
+}
+
+// rekeyFile is the content of a file with the values encrypted using the new key.
+type rekeyFile struct {
+	path    string
+	mode    os.FileMode
+	content string
+	values  int
+}
+
+// rekeyer collects the files to rewrite: no file is written if a value cannot be decrypted.
+type rekeyer struct {
+	oldKey  string
+	newKey  string
+	visited map[string]bool
+	files   []*rekeyFile
+}
+
+// Rekey encrypts using newKey all the values encrypted using oldKey in the Runpfile and in the included ones:
+// encrypted_secret and encrypted_passphrase, env values with the encrypted: prefix, aes secrets, encrypted
+// sections and vault files.
+// Files are written only if all the values are decrypted.
+func Rekey(runpfilePath string, oldKey string, newKey string) ([]RekeyedFile, error) {
+	if oldKey == "" || newKey == "" {
+		return nil, errors.New("old and new keys are required")
+	}
+	r := &rekeyer{oldKey: oldKey, newKey: newKey, visited: map[string]bool{}}
+	if err := r.runpfile(runpfilePath); err != nil {
+		return nil, err
+	}
+	rekeyed := []RekeyedFile{}
+	for _, f := range r.files {
+		if err := os.WriteFile(f.path, []byte(f.content), f.mode); err != nil {
+			return rekeyed, errors.Wrapf(err, "cannot write %s", f.path)
+		}
+		rekeyed = append(rekeyed, RekeyedFile{Path: f.path, Values: f.values})
+	}
+	return rekeyed, nil
+}
+
+func (r *rekeyer) runpfile(p string) error {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	if r.visited[abs] {
+		return nil
+	}
+	r.visited[abs] = true
+	f, err := r.read(abs)
+	if err != nil {
+		return err
+	}
+	if isComposeData([]byte(f.content)) {
+		return nil
+	}
+	content, values, err := r.document(f.content, abs, true)
+	if err != nil {
+		return err
+	}
+	if values > 0 {
+		f.content = content
+		f.values = values
+		r.files = append(r.files, f)
+	}
+	return nil
+}
+
+// document returns the content with the values encrypted using the new key and the number of values.
+// Vault files and, if root is true, included Runpfiles are rekeyed too.
+func (r *rekeyer) document(content string, p string, root bool) (string, int, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(content), doc); err != nil {
+		return "", 0, errors.Wrapf(err, "invalid Runpfile %s", p)
+	}
+	found := &encryptedNodes{}
+	collectEncrypted(doc, found)
+	count := 0
+	var err error
+	for _, v := range found.values {
+		if content, err = r.replace(content, p, v); err != nil {
+			return "", 0, err
+		}
+		count++
+	}
+	for _, section := range found.sections {
+		var n int
+		if content, n, err = r.section(content, p, section, root && doc.Content[0] == section); err != nil {
+			return "", 0, err
+		}
+		count += n
+	}
+	dir := filepath.Dir(p)
+	for _, v := range found.vaults {
+		vault, err := resolvePath(v, dir)
+		if err != nil {
+			return "", 0, err
+		}
+		if err := r.vault(vault); err != nil {
+			return "", 0, err
+		}
+	}
+	if !root {
+		return content, count, nil
+	}
+	included, err := includes(doc, p)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, inc := range included {
+		if err := r.runpfile(inc); err != nil {
+			return "", 0, err
+		}
+	}
+	return content, count, nil
+}
+
+// section encrypts the encrypted section using the new key, after rekeying the values it contains.
+func (r *rekeyer) section(content string, p string, node *yaml.Node, root bool) (string, int, error) {
+	encrypted := strings.TrimSpace(node.Value)
+	plain, err := DecryptBase64(encrypted, r.oldKey)
+	if err != nil {
+		return "", 0, errors.Wrapf(errors.Wrap(err, "cannot decrypt value using the old key"), "%s line %d", p, node.Line)
+	}
+	plainContent, count, err := r.document(string(plain), p, root)
+	if err != nil {
+		return "", 0, err
+	}
+	reencrypted, err := EncryptToBase64([]byte(plainContent), r.newKey)
+	if err != nil {
+		return "", 0, err
+	}
+	content, err = replaceValue(content, p, node, encrypted, reencrypted)
+	return content, count + 1, err
+}
+
+func (r *rekeyer) read(p string) (*rekeyFile, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return &rekeyFile{path: p, mode: info.Mode().Perm(), content: string(data)}, nil
+}
+
+// replace returns the content with the value of the node encrypted using the new key.
+func (r *rekeyer) replace(content string, p string, node *yaml.Node) (string, error) {
+	encrypted := strings.TrimSpace(strings.TrimPrefix(node.Value, encryptedEnvPrefix))
+	reencrypted, err := r.reencrypt(encrypted)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s line %d", p, node.Line)
+	}
+	return replaceValue(content, p, node, encrypted, reencrypted)
+}
+
+// replaceValue replaces the value in the content, preserving the formatting.
+func replaceValue(content string, p string, node *yaml.Node, old string, new string) (string, error) {
+	if !strings.Contains(content, old) {
+		return "", errors.Errorf("%s line %d: cannot rewrite the value, write it on a single line", p, node.Line)
+	}
+	return strings.Replace(content, old, new, 1), nil
+}
+
+// vault encrypts the whole vault file using the new key.
+func (r *rekeyer) vault(p string) error {
+	if r.visited[p] {
+		return nil
+	}
+	r.visited[p] = true
+	f, err := r.read(p)
+	if err != nil {
+		return errors.Wrap(err, "cannot read vault")
+	}
+	reencrypted, err := r.reencrypt(strings.TrimSpace(f.content))
+	if err != nil {
+		return errors.Wrapf(err, "vault %s", p)
+	}
+	f.content = reencrypted + "\n"
+	f.values = 1
+	r.files = append(r.files, f)
+	return nil
+}
+
+func (r *rekeyer) reencrypt(encrypted string) (string, error) {
+	plain, err := DecryptBase64(encrypted, r.oldKey)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot decrypt value using the old key")
+	}
+	return EncryptToBase64(plain, r.newKey)
+}
+
+// encryptedNodes are the encrypted values, the encrypted sections and the vault files found in a document.
+type encryptedNodes struct {
+	values   []*yaml.Node
+	sections []*yaml.Node
+	vaults   []string
+}
+
+// collectEncrypted adds the nodes with encrypted values, the encrypted sections and the paths of vault files.
+func collectEncrypted(node *yaml.Node, found *encryptedNodes) {
+	if isEncryptedSection(node) {
+		found.sections = append(found.sections, node)
+		return
+	}
+	if node.Kind != yaml.MappingNode {
+		for _, n := range node.Content {
+			collectEncrypted(n, found)
+		}
+		return
+	}
+	provider := mappingValue(node, "provider")
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		if v.Kind != yaml.ScalarNode || isEncryptedSection(v) {
+			collectEncrypted(v, found)
+			continue
+		}
+		switch {
+		case v.Value == "":
+		case k.Value == "encrypted_secret" || k.Value == "encrypted_passphrase":
+			found.values = append(found.values, v)
+		case isEncryptedEnv(v.Value):
+			found.values = append(found.values, v)
+		case provider == "aes" && k.Value == "value":
+			found.values = append(found.values, v)
+		case provider == "vault" && k.Value == "file":
+			found.vaults = append(found.vaults, v.Value)
+		}
+	}
+}
+
+// mappingValue returns the scalar value of key in the mapping node.
+func mappingValue(node *yaml.Node, key string) string {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key && node.Content[i+1].Kind == yaml.ScalarNode {
+			return node.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// includes returns the included Runpfiles and the overrides of the Runpfile at p.
+func includes(doc *yaml.Node, p string) ([]string, error) {
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	specs := struct {
+		Include   []IncludeSpec
+		Overrides []IncludeSpec
+	}{}
+	if err := doc.Content[0].Decode(&specs); err != nil {
+		return nil, errors.Wrapf(err, "invalid Runpfile %s", p)
+	}
+	return resolveIncludes(filepath.Dir(p), p, append(specs.Include, specs.Overrides...))
+}
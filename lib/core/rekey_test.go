@@ -0,0 +1,125 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRekey(t *testing.T) {
+	setupTestUI(t)
+	dir := t.TempDir()
+	encrypt := func(plain string) string {
+		encrypted, err := EncryptToBase64([]byte(plain), "oldkey")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encrypted
+	}
+	write := func(name string, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	runpfile := write("Runpfile", fmt.Sprintf(`include:
+  - included.yml
+secrets:
+  token:
+    provider: aes
+    value: %s
+  db_password:
+    provider: vault
+    file: secrets.vault
+units:
+  db:
+    ssh_tunnel:
+      user: runp
+      auth:
+        encrypted_secret: %s
+      local:
+        port: 5432
+      jump:
+        host: bastion
+        port: 22
+      target:
+        host: db
+        port: 5432
+`, encrypt("token-value"), encrypt("ssh-password")))
+	included := write("included.yml", fmt.Sprintf(`units:
+  app:
+    host:
+      command: echo
+      env:
+        # comments are preserved
+        PASSWORD: "encrypted:%s"
+        USER: app
+`, encrypt("env-password")))
+	vault := write("secrets.vault", encrypt("db_password: vault-password\n")+"\n")
+
+	originals := map[string]string{}
+	for _, p := range []string{runpfile, included, vault} {
+		data, _ := os.ReadFile(p)
+		originals[p] = string(data)
+	}
+
+	if _, err := Rekey(runpfile, "wrongkey", "newkey"); err == nil || !strings.Contains(err.Error(), "cannot decrypt value using the old key") {
+		t.Fatalf("Expected error for wrong old key, got %v", err)
+	}
+	for p, original := range originals {
+		if data, _ := os.ReadFile(p); string(data) != original {
+			t.Errorf("Expected %s unchanged after a failed rekey", p)
+		}
+	}
+
+	rekeyed, err := Rekey(runpfile, "oldkey", "newkey")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	values := map[string]int{}
+	for _, f := range rekeyed {
+		values[filepath.Base(f.Path)] = f.Values
+	}
+	expected := map[string]int{"Runpfile": 2, "included.yml": 1, "secrets.vault": 1}
+	if len(values) != len(expected) {
+		t.Errorf("Expected rekeyed files %v, got %v", expected, values)
+	}
+	for name, n := range expected {
+		if values[name] != n {
+			t.Errorf("%s: expected %d value(s) rekeyed, got %d", name, n, values[name])
+		}
+	}
+
+	data, err := os.ReadFile(included)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "# comments are preserved") {
+		t.Errorf("Expected formatting preserved, got %s", data)
+	}
+
+	rf, err := LoadRunpfileFromPath(runpfile)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.SecretKey = "newkey"
+	store := newSecretStore(rf)
+	for name, value := range map[string]string{"token": "token-value", "db_password": "vault-password"} {
+		if actual, err := store.get(name); err != nil || actual != value {
+			t.Errorf("Secret %s, expected <%s> got <%s> %v", name, value, actual, err)
+		}
+	}
+	env, err := processEnv(rf.Units["app"].Host.Env, map[string]string{}, "newkey")
+	sort.Strings(env)
+	if err != nil || strings.Join(env, " ") != "PASSWORD=env-password USER=app" {
+		t.Errorf("Expected env value encrypted using the new key, got %v %v", env, err)
+	}
+	plain, err := DecryptBase64(rf.Units["db"].SSHTunnel.Auth.EncryptedSecret, "newkey")
+	if err != nil || string(plain) != "ssh-password" {
+		t.Errorf("Expected encrypted_secret encrypted using the new key, got <%s> %v", plain, err)
+	}
+}
@@ -1,18 +1,46 @@
 package core
 
-import "fmt"
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
 
 // Runpfile is the model containing the full configuration.
 type Runpfile struct {
-	Name          string
-	Description   string
-	Version       string
-	Vars          map[string]string
+	Name        string
+	Description string
+	Version     string
+	Vars        map[string]string
+	Env         map[string]string
+	// names of the vars set to free local ports allocated at the start
+	Ports         []string
+	Secrets       map[string]SecretSpec
 	Root          string
 	Units         map[string]*RunpUnit
 	SecretKey     string `yaml:"-"`
-	Include       []string
+	Include       []IncludeSpec
 	Preconditions Preconditions
+	// Runpfiles patching the units declared in this Runpfile and in the included ones
+	Overrides []IncludeSpec
+
+	// commands computing the value of vars, by var name
+	computedVars map[string]string
+	// included Runpfiles declaring vars, by var name
+	varOrigins map[string]string
+	// included Runpfiles declaring secrets and their directories, by secret name
+	secretOrigins map[string]string
+	secretRoots   map[string]string
+	// YAML of the units, by unit id
+	unitNodes map[string]*yaml.Node
+	// unit templates available to the Runpfile and to the included ones
+	templates map[string]*yaml.Node
+	// file the Runpfile is read from
+	path string
+	// YAML of the Runpfile
+	node *yaml.Node
 }
 
 // RunpUnit is...
@@ -21,15 +49,30 @@ type RunpUnit struct {
 	Description   string
 	StopTimeout   string `yaml:"stop_timeout"`
 	Preconditions Preconditions
+	// Extends is the template the unit is merged with
+	Extends string
+	// Params replace {{param name}} in the template and in the unit
+	Params map[string]string
 
-	Host      *HostProcess
-	Container *ContainerProcess
-	SSHTunnel *SSHTunnelProcess `yaml:"ssh_tunnel"`
+	Host        *HostProcess
+	Container   *ContainerProcess
+	SSHTunnel   *SSHTunnelProcess   `yaml:"ssh_tunnel"`
+	KubeForward *KubeForwardProcess `yaml:"kube_forward"`
+	Proxy       *ProxyProcess
 
 	vars                map[string]string
+	globalEnv           map[string]string
 	secretKey           string
 	process             RunpProcess
 	environmentSettings *EnvironmentSettings
+	// directory of the Runpfile declaring the unit
+	root string
+	// Runpfile declaring the unit
+	file string
+	// top level preconditions of the included Runpfiles declaring the unit
+	runpfilePreconditions []*Preconditions
+	// YAML of the unit, patched by overrides
+	node *yaml.Node
 }
 
 // Process returns the sub process
@@ -50,12 +93,25 @@ func (u *RunpUnit) Kind() string {
 		return `Host process`
 	}
 	if u.SSHTunnel != nil {
-		st := u.SSHTunnel
-		return fmt.Sprintf(`SSH tunnel %s -> %s -> %s`, st.Local.String(), st.Jump.String(), st.Target.String())
+		return fmt.Sprintf(`SSH tunnel %s`, u.SSHTunnel.ForwardsDescription())
+	}
+	if u.KubeForward != nil {
+		return fmt.Sprintf(`kube forward %s %s`, u.KubeForward.Resource, u.KubeForward.PortsDescription())
+	}
+	if u.Proxy != nil {
+		return fmt.Sprintf(`Proxy %s`, u.Proxy.RoutesDescription())
 	}
 	return ``
 }
 
+// Details returns the resolved settings shown in `runp ls --verbose`.
+func (u *RunpUnit) Details() []string {
+	if u.SSHTunnel != nil {
+		return u.SSHTunnel.Details()
+	}
+	return []string{}
+}
+
 func (u *RunpUnit) buildProcess() RunpProcess {
 	cliPreprocessor := newCliPreprocessor(u.vars)
 	if u.Container != nil {
@@ -79,9 +135,84 @@ func (u *RunpUnit) buildProcess() RunpProcess {
 		// tunnel.Env = processEnv(tunnel.Env, cliPreprocessor)
 		return tunnel
 	}
+	if u.KubeForward != nil {
+		forward := u.KubeForward
+		forward.WorkingDir = cliPreprocessor.process(u.KubeForward.WorkingDir)
+		return forward
+	}
+	if u.Proxy != nil {
+		proxy := u.Proxy
+		proxy.WorkingDir = cliPreprocessor.process(u.Proxy.WorkingDir)
+		return proxy
+	}
 	return nil
 }
 
+// processKinds returns the process types defined in the unit, a valid unit has exactly one.
+func (u *RunpUnit) processKinds() []string {
+	kinds := []string{}
+	if u.Container != nil {
+		kinds = append(kinds, "container")
+	}
+	if u.Host != nil {
+		kinds = append(kinds, "host")
+	}
+	if u.SSHTunnel != nil {
+		kinds = append(kinds, "ssh_tunnel")
+	}
+	if u.KubeForward != nil {
+		kinds = append(kinds, "kube_forward")
+	}
+	if u.Proxy != nil {
+		kinds = append(kinds, "proxy")
+	}
+	return kinds
+}
+
+// localAddress returns the address where the unit is reachable from localhost, if known:
+// the first published port of a container, the first local forward of a tunnel, the proxy listen address.
+func (u *RunpUnit) localAddress() (string, bool) {
+	// ports and addresses can be set using vars, as in "{{vars port_db}}:5432"
+	p := newCliPreprocessor(u.vars)
+	switch {
+	case u.Container != nil && len(u.Container.Ports) > 0:
+		parts := strings.Split(strings.SplitN(p.process(u.Container.Ports[0]), "/", 2)[0], ":")
+		if len(parts) < 2 {
+			return "", false
+		}
+		host := "localhost"
+		if len(parts) > 2 {
+			host = parts[0]
+		}
+		return net.JoinHostPort(host, parts[len(parts)-2]), true
+	case u.SSHTunnel != nil:
+		for _, f := range u.SSHTunnel.forwards() {
+			if !f.IsRemote() && !f.Dynamic {
+				return f.Local.String(), true
+			}
+		}
+	case u.KubeForward != nil && len(u.KubeForward.Ports) > 0:
+		host := "localhost"
+		if u.KubeForward.Address != "" {
+			host = strings.Split(p.process(u.KubeForward.Address), ",")[0]
+		}
+		return net.JoinHostPort(host, strings.SplitN(p.process(u.KubeForward.Ports[0]), ":", 2)[0]), true
+	case u.Proxy != nil:
+		return u.Proxy.Listen.String(), true
+	}
+	return "", false
+}
+
+// templateErrors returns the errors processing the templates in the unit settings.
+func (u *RunpUnit) templateErrors() []error {
+	errs := []error{}
+	cliPreprocessor := newCliPreprocessor(u.vars)
+	for _, err := range append(cliPreprocessor.check(u.Process()), cliPreprocessor.check(u.globalEnv)...) {
+		errs = append(errs, fmt.Errorf("unit %s: %v", u.Name, err))
+	}
+	return errs
+}
+
 // SkipDirResolution avoid resolve dir for containers
 func (u *RunpUnit) SkipDirResolution() bool {
 	return u.Container != nil
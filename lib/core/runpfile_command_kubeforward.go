@@ -0,0 +1,205 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	kubeForwardMinBackoff = 1 * time.Second
+	kubeForwardMaxBackoff = 30 * time.Second
+)
+
+var errKubectlNotRunning = errors.New("kubectl not running")
+
+// KubeForwardCommandWrapper runs `kubectl port-forward` restarting it, with an exponential backoff,
+// until Stop is called.
+type KubeForwardCommandWrapper struct {
+	id          string
+	exe         string
+	args        []string
+	env         []string
+	dir         string
+	stopTimeout time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stopped bool
+	done    chan struct{}
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Pid returns the PID of the running kubectl.
+func (c *KubeForwardCommandWrapper) Pid() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cmd == nil || c.cmd.Process == nil {
+		return -400
+	}
+	return c.cmd.Process.Pid
+}
+
+// Stdout set the stdout writer
+func (c *KubeForwardCommandWrapper) Stdout(stdout io.Writer) {
+	c.stdout = stdout
+}
+
+// Stderr set the stderr writer
+func (c *KubeForwardCommandWrapper) Stderr(stderr io.Writer) {
+	c.stderr = stderr
+}
+
+// Start starts the first kubectl process.
+func (c *KubeForwardCommandWrapper) Start() error {
+	c.pf("Starting kubectl port-forward %v", c.args)
+	return c.startProcess()
+}
+
+// Run starts and waits.
+func (c *KubeForwardCommandWrapper) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Stop stops the running kubectl and the reconnection loop.
+func (c *KubeForwardCommandWrapper) Stop() error {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil
+	}
+	c.stopped = true
+	close(c.done)
+	cmd := c.cmd
+	c.mu.Unlock()
+	c.pf("Stopping kubectl port-forward")
+	if cmd == nil {
+		return nil
+	}
+	return stopWithGracefulShutdownWithID(cmd, c.stopTimeout, c.id)
+}
+
+// Wait waits for kubectl, restarting it when it exits, and returns when the command is stopped.
+func (c *KubeForwardCommandWrapper) Wait() error {
+	backoff := c.minBackoff
+	for {
+		c.mu.Lock()
+		cmd := c.cmd
+		c.mu.Unlock()
+		started := time.Now()
+		err := errKubectlNotRunning
+		if cmd != nil {
+			err = cmd.Wait()
+		}
+		if c.isStopped() {
+			return nil
+		}
+		if time.Since(started) > c.maxBackoff {
+			backoff = c.minBackoff
+		}
+		c.pf("kubectl port-forward exited (%v), reconnecting in %s", err, backoff)
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = backoff * 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+		if err := c.startProcess(); err != nil {
+			c.pf("Failed to restart kubectl port-forward: %v", err)
+		}
+	}
+}
+
+func (c *KubeForwardCommandWrapper) startProcess() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return nil
+	}
+	cmd := exec.Command(c.exe, c.args...)
+	cmd.Env = c.env
+	cmd.Dir = c.dir
+	cmd.Stdout = c.stdout
+	cmd.Stderr = c.stderr
+	configureProcessAttributes(cmd)
+	if err := cmd.Start(); err != nil {
+		c.cmd = nil
+		return err
+	}
+	c.cmd = cmd
+	return nil
+}
+
+func (c *KubeForwardCommandWrapper) isStopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+func (c *KubeForwardCommandWrapper) pf(format string, a ...interface{}) {
+	if c.stdout == nil {
+		return
+	}
+	fmt.Fprintf(c.stdout, format+"\n", a...)
+}
+
+func (c *KubeForwardCommandWrapper) String() string {
+	return fmt.Sprintf("%T (%d)", c, c.Pid())
+}
+
+// KubeForwardCommandStopper is the component calling the actual command stopping the process.
+type KubeForwardCommandStopper struct {
+	cmd *KubeForwardCommandWrapper
+}
+
+// Pid ...
+func (c *KubeForwardCommandStopper) Pid() int {
+	return -500
+}
+
+// Stdout ...
+func (c *KubeForwardCommandStopper) Stdout(stdout io.Writer) {
+
+}
+
+// Stderr ...
+func (c *KubeForwardCommandStopper) Stderr(stderr io.Writer) {
+
+}
+
+// Start ...
+func (c *KubeForwardCommandStopper) Start() error {
+	return c.cmd.Stop()
+}
+
+// Run ...
+func (c *KubeForwardCommandStopper) Run() error {
+	return c.cmd.Stop()
+}
+
+// Stop ...
+func (c *KubeForwardCommandStopper) Stop() error {
+	return c.cmd.Stop()
+}
+
+// Wait ...
+func (c *KubeForwardCommandStopper) Wait() error {
+	return nil
+}
+
+func (c *KubeForwardCommandStopper) String() string {
+	return fmt.Sprintf("%T (%d)", c, c.Pid())
+}
@@ -0,0 +1,264 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyRoute is a resolved HTTP route.
+type proxyRoute struct {
+	path        string
+	target      *url.URL
+	hostHeader  string
+	stripPrefix bool
+}
+
+// matches returns true if the path is the route path or is under it.
+func (r proxyRoute) matches(path string) bool {
+	prefix := strings.TrimSuffix(r.path, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// ProxyCommandWrapper serves the proxy from start to stop: a TCP passthrough to target,
+// or an HTTP reverse proxy routing requests by path.
+type ProxyCommandWrapper struct {
+	listen string
+	// TCP target, empty for HTTP proxies
+	target  string
+	routes  []proxyRoute
+	latency time.Duration
+
+	mu       sync.Mutex
+	listener net.Listener
+	server   *http.Server
+	stopped  bool
+	// TCP connections, closed on stop
+	conns connTracker
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Pid ...
+func (c *ProxyCommandWrapper) Pid() int {
+	return -600
+}
+
+// Stdout ...
+func (c *ProxyCommandWrapper) Stdout(stdout io.Writer) {
+	c.stdout = stdout
+}
+
+// Stderr ...
+func (c *ProxyCommandWrapper) Stderr(stderr io.Writer) {
+	c.stderr = stderr
+}
+
+// Start opens the listener, so that the port is available as soon as the unit is started.
+func (c *ProxyCommandWrapper) Start() error {
+	listener, err := net.Listen("tcp", c.listen)
+	if err != nil {
+		c.pf("Failed to start proxy on %s: %v", c.listen, err)
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listener = listener
+	if c.target != "" {
+		c.pf("Starting TCP proxy %s -> %s", c.listen, c.target)
+		return nil
+	}
+	c.pf("Starting HTTP proxy on %s", c.listen)
+	for _, r := range c.routes {
+		c.pf("  %s -> %s", r.path, r.target)
+	}
+	c.server = &http.Server{Handler: c.httpHandler()}
+	return nil
+}
+
+// Run ...
+func (c *ProxyCommandWrapper) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Stop closes the listener and the proxied connections.
+func (c *ProxyCommandWrapper) Stop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return nil
+	}
+	c.stopped = true
+	c.pf("Stopping proxy on %s", c.listen)
+	c.conns.closeAll()
+	if c.server != nil {
+		c.server.Close()
+	}
+	if c.listener != nil {
+		// the listener is already closed by the server if serving
+		c.listener.Close()
+	}
+	return nil
+}
+
+// Wait serves the proxy until it is stopped.
+func (c *ProxyCommandWrapper) Wait() error {
+	c.mu.Lock()
+	listener, server := c.listener, c.server
+	c.mu.Unlock()
+	if listener == nil {
+		// not started
+		return nil
+	}
+	var err error
+	if server != nil {
+		err = server.Serve(listener)
+	} else {
+		err = c.serveTCP(listener)
+	}
+	if c.isStopped() {
+		return nil
+	}
+	return err
+}
+
+func (c *ProxyCommandWrapper) String() string {
+	return fmt.Sprintf("%T (%d)", c, c.Pid())
+}
+
+func (c *ProxyCommandWrapper) pf(format string, a ...interface{}) {
+	if c.stdout == nil {
+		return
+	}
+	fmt.Fprintf(c.stdout, format+"\n", a...)
+}
+
+func (c *ProxyCommandWrapper) isStopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+func (c *ProxyCommandWrapper) serveTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go c.forwardTCP(conn)
+	}
+}
+
+func (c *ProxyCommandWrapper) forwardTCP(accepted net.Conn) {
+	c.pf("TCP connection from %s to %s", accepted.RemoteAddr(), c.target)
+	time.Sleep(c.latency)
+	other, err := net.Dial("tcp", c.target)
+	if err != nil {
+		c.pf("Error connecting to %s: %v", c.target, err)
+		accepted.Close()
+		return
+	}
+	c.conns.pipe(accepted, other)
+}
+
+// httpHandler routes requests to the route with the longest matching path.
+func (c *ProxyCommandWrapper) httpHandler() http.Handler {
+	routes := make([]proxyRoute, len(c.routes))
+	copy(routes, c.routes)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].path) > len(routes[j].path)
+	})
+	proxies := make([]*httputil.ReverseProxy, len(routes))
+	for i, r := range routes {
+		proxies[i] = c.reverseProxy(r)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i, r := range routes {
+			if r.matches(req.URL.Path) {
+				c.pf("%s %s -> %s", req.Method, req.URL.RequestURI(), r.target)
+				time.Sleep(c.latency)
+				proxies[i].ServeHTTP(w, req)
+				return
+			}
+		}
+		c.pf("%s %s -> no route", req.Method, req.URL.RequestURI())
+		http.NotFound(w, req)
+	})
+}
+
+func (c *ProxyCommandWrapper) reverseProxy(r proxyRoute) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(r.target)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		if r.stripPrefix {
+			req.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(r.path, "/")), "/")
+			req.URL.RawPath = ""
+		}
+		director(req)
+		// as nginx does, the Host header is the target one if not rewritten
+		req.Host = r.target.Host
+		if r.hostHeader != "" {
+			req.Host = r.hostHeader
+		}
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		c.pf("Error proxying %s to %s: %v", req.URL.RequestURI(), r.target, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return proxy
+}
+
+// ProxyCommandStopper is the component calling the actual command stopping the process.
+type ProxyCommandStopper struct {
+	cmd *ProxyCommandWrapper
+}
+
+// Pid ...
+func (c *ProxyCommandStopper) Pid() int {
+	return -700
+}
+
+// Stdout ...
+func (c *ProxyCommandStopper) Stdout(stdout io.Writer) {
+
+}
+
+// Stderr ...
+func (c *ProxyCommandStopper) Stderr(stderr io.Writer) {
+
+}
+
+// Start ...
+func (c *ProxyCommandStopper) Start() error {
+	return c.cmd.Stop()
+}
+
+// Run ...
+func (c *ProxyCommandStopper) Run() error {
+	return c.cmd.Stop()
+}
+
+// Stop ...
+func (c *ProxyCommandStopper) Stop() error {
+	return c.cmd.Stop()
+}
+
+// Wait ...
+func (c *ProxyCommandStopper) Wait() error {
+	return nil
+}
+
+func (c *ProxyCommandStopper) String() string {
+	return fmt.Sprintf("%T (%d)", c, c.Pid())
+}
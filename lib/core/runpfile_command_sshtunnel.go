@@ -4,29 +4,166 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 )
 
-// SSHTunnelCommandWrapper ...
-type SSHTunnelCommandWrapper struct {
-	config *ssh.ClientConfig
+// sshForward is a resolved port forwarding.
+// Local forwards expose target on local, remote forwards expose local on the jump server at remote,
+// dynamic forwards expose on local a SOCKS5 proxy connecting to the targets requested by clients.
+type sshForward struct {
+	local   string
+	target  string
+	remote  string
+	dynamic bool
+}
+
+func (f sshForward) isRemote() bool {
+	return f.remote != ""
+}
+
+func (f sshForward) String() string {
+	if f.dynamic {
+		return fmt.Sprintf("%s (SOCKS5)", f.local)
+	}
+	if f.isRemote() {
+		return fmt.Sprintf("%s <- %s", f.local, f.remote)
+	}
+	return fmt.Sprintf("%s -> %s", f.local, f.target)
+}
+
+const (
+	sshTunnelKeepaliveInterval = 30 * time.Second
+	sshTunnelMinBackoff        = 1 * time.Second
+	sshTunnelMaxBackoff        = 30 * time.Second
+	// maximum time to connect to a jump server and to complete the SSH handshake
+	sshTunnelDialTimeout = 15 * time.Second
+)
 
-	localAddress  string
-	jumpAddress   string
-	targetAddress string
+// sshHop is a resolved jump server.
+type sshHop struct {
+	// hop description used in errors
+	name    string
+	address string
+	config  *ssh.ClientConfig
+}
 
-	// internal connection on local port
-	localConnection net.Conn
-	// ssh connection between localhost and ssh server
-	localToJumpConnection *ssh.Client
-	// connection between ssh server and target
-	jumpToTargetConnection net.Conn
+// sshChain is the chain of SSH connections through the hops, the last one connects to targets.
+// Clients are not changed after dialing, so a chain can be used while another goroutine closes it.
+type sshChain struct {
+	clients []*ssh.Client
+}
+
+// dialSSHHops connects to the first hop and dials each next hop through the previous connection,
+// as OpenSSH ProxyJump does.
+func dialSSHHops(hops []sshHop) (*sshChain, error) {
+	chain := &sshChain{}
+	for _, hop := range hops {
+		client, err := chain.dial(hop)
+		if err != nil {
+			chain.Close()
+			return nil, errors.Wrap(err, hop.name)
+		}
+		chain.clients = append(chain.clients, client)
+	}
+	return chain, nil
+}
+
+func (s *sshChain) dial(hop sshHop) (*ssh.Client, error) {
+	timeout := hop.config.Timeout
+	if timeout == 0 {
+		timeout = sshTunnelDialTimeout
+	}
+	var conn net.Conn
+	var err error
+	if len(s.clients) == 0 {
+		conn, err = net.DialTimeout("tcp", hop.address, timeout)
+	} else {
+		conn, err = s.client().Dial("tcp", hop.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a server not completing the handshake is dropped closing the connection,
+	// connections through a hop do not support deadlines
+	timer := time.AfterFunc(timeout, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, hop.address, hop.config)
+	if !timer.Stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, errors.Errorf("SSH handshake with %s timed out after %s", hop.address, timeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// client returns the connection to the last hop.
+func (s *sshChain) client() *ssh.Client {
+	return s.clients[len(s.clients)-1]
+}
+
+// Close closes the connections starting from the last hop.
+func (s *sshChain) Close() error {
+	var err error
+	for i := len(s.clients) - 1; i >= 0; i-- {
+		if e := s.clients[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// SSHTunnelCommandWrapper serves all the forwards of a tunnel using a single SSH connection to the jump server.
+// The connection is monitored sending keepalive requests and it is re-established, with an exponential
+// backoff, when lost.
+type SSHTunnelCommandWrapper struct {
+	hops []sshHop
+
+	// jump servers description
+	jumpAddress string
+	forwards    []sshForward
+	// interval between keepalive requests, 0 disables keepalive
+	keepaliveInterval time.Duration
+	minBackoff        time.Duration
+	maxBackoff        time.Duration
+
+	mu sync.Mutex
+	// ssh connections between localhost and the jump servers, shared by all forwards
+	chain *sshChain
+	// connection in progress, dialed without holding the lock
+	dialing *sshDial
+	// listeners for local forwards, open from start to stop
+	listeners []forwardListener
+	// listeners on the jump server for remote forwards, open with the current connection
+	remoteListeners []net.Listener
+	stopped         bool
+	done            chan struct{}
+	// forwarded connections, closed on stop
+	conns connTracker
 
 	stdout io.Writer
 	stderr io.Writer
 }
 
+// sshDial is a connection to the jump servers in progress: callers needing the connection wait for done.
+type sshDial struct {
+	done  chan struct{}
+	chain *sshChain
+	err   error
+}
+
+type forwardListener struct {
+	forward  sshForward
+	listener net.Listener
+}
+
 // Pid ...
 func (c *SSHTunnelCommandWrapper) Pid() int {
 	return -100
@@ -42,9 +179,24 @@ func (c *SSHTunnelCommandWrapper) Stderr(stderr io.Writer) {
 	c.stderr = stderr
 }
 
-// Start ...
+// Start opens the local listeners, so that local ports are available as soon as the unit is started.
+// Remote forwards need the SSH connection and are opened in Wait.
 func (c *SSHTunnelCommandWrapper) Start() error {
-	c.pf("Starting SSH tunnel %s -> %s -> %s", c.localAddress, c.jumpAddress, c.targetAddress)
+	for _, f := range c.forwards {
+		c.pf("Starting SSH tunnel %s via %s", f, c.jumpAddress)
+		if f.isRemote() {
+			continue
+		}
+		listener, err := net.Listen("tcp", f.local)
+		if err == nil {
+			err = c.addListener(f, listener)
+		}
+		if err != nil {
+			c.pf("Failed to start listener for %s: %v", f, err)
+			c.Stop()
+			return err
+		}
+	}
 	return nil
 }
 
@@ -53,62 +205,66 @@ func (c *SSHTunnelCommandWrapper) Run() error {
 	return nil
 }
 
-// Stop ...
+// Stop closes listeners, forwarded connections and the SSH connection.
 func (c *SSHTunnelCommandWrapper) Stop() error {
 	c.pf("Stopping SSH tunnel")
-	var err error
-	var errors multiError
-	c.pf("Closing SSH connection to target: %v", c.jumpToTargetConnection)
-	if c.jumpToTargetConnection != nil {
-		err := c.jumpToTargetConnection.Close()
-		if err != nil {
-			c.pf("Error closing connection to target: %s", err)
-			errors = append(errors, err)
-		}
-
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stopped {
+		c.stopped = true
+		close(c.doneChannel())
 	}
-	c.pf("Closing SSH connection to jump server: %v", c.localToJumpConnection)
-	if c.localToJumpConnection != nil {
-		err = c.localToJumpConnection.Close()
-		if err != nil {
-			c.pf("Error closing connection to jump server: %s", err)
-			errors = append(errors, err)
+	var errs multiError
+	for _, l := range c.listeners {
+		if err := l.listener.Close(); err != nil {
+			c.pf("Error closing listener %s: %s", l.listener.Addr(), err)
+			errs = append(errs, err)
 		}
 	}
-	c.pf("Closing local connection: %v", c.localConnection)
-	if c.localConnection != nil {
-		err = c.localConnection.Close()
-		if err != nil {
-			c.pf("Error closing local connection: %s", err)
-			errors = append(errors, err)
+	c.listeners = nil
+	c.closeRemoteListeners()
+	c.conns.closeAll()
+	if c.chain != nil {
+		c.pf("Closing SSH connection to jump server %s", c.jumpAddress)
+		if err := c.chain.Close(); err != nil {
+			c.pf("Error closing connection to jump server: %s", err)
+			errs = append(errs, err)
 		}
+		c.chain = nil
 	}
-	if len(errors) > 0 {
-		return errors
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
 
-// Wait ...
+// Wait serves the forwards until the tunnel is stopped or a local listener fails.
 func (c *SSHTunnelCommandWrapper) Wait() error {
-	localListener, err := net.Listen("tcp", c.localAddress)
-	if err != nil {
-		c.pf("Failed to start local listener on %s: %v", c.localAddress, err)
-		return err
+	c.mu.Lock()
+	listeners := make([]forwardListener, len(c.listeners))
+	copy(listeners, c.listeners)
+	done := c.doneChannel()
+	c.mu.Unlock()
+	if len(listeners) == 0 && !c.hasRemoteForwards() {
+		// not started
+		return nil
 	}
-
-	for {
-		c.localConnection, err = localListener.Accept()
-		if err != nil {
-			c.pf("Failed to accept connection on local listener: %v", err)
-			return err
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l forwardListener) {
+			errs <- c.serve(l.forward, l.listener)
+		}(l)
+	}
+	go c.reconnect()
+	select {
+	case <-done:
+		return nil
+	case err := <-errs:
+		if c.isStopped() {
+			return nil
 		}
-		go func() {
-			err = c.forward()
-			if err != nil {
-				ui.WriteLinef("Error forwarding SSH tunnel connection: %+v", err)
-			}
-		}()
+		c.Stop()
+		return err
 	}
 }
 
@@ -120,47 +276,363 @@ func (c *SSHTunnelCommandWrapper) pf(format string, a ...interface{}) {
 	if c.stdout == nil {
 		return
 	}
-	fmt.Fprintf(c.stdout, format, a...)
+	fmt.Fprintf(c.stdout, format+"\n", a...)
+}
+
+func (c *SSHTunnelCommandWrapper) isStopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+// doneChannel returns the channel closed on stop. It must be called holding the lock.
+func (c *SSHTunnelCommandWrapper) doneChannel() chan struct{} {
+	if c.done == nil {
+		c.done = make(chan struct{})
+	}
+	return c.done
+}
+
+func (c *SSHTunnelCommandWrapper) hasRemoteForwards() bool {
+	for _, f := range c.forwards {
+		if f.isRemote() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *SSHTunnelCommandWrapper) addListener(f sshForward, listener net.Listener) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		listener.Close()
+		return fmt.Errorf("SSH tunnel stopped")
+	}
+	c.listeners = append(c.listeners, forwardListener{forward: f, listener: listener})
+	return nil
+}
+
+// closeRemoteListeners closes the listeners of the current connection. It must be called holding the lock.
+func (c *SSHTunnelCommandWrapper) closeRemoteListeners() {
+	for _, l := range c.remoteListeners {
+		l.Close()
+	}
+	c.remoteListeners = nil
+}
+
+func (c *SSHTunnelCommandWrapper) serve(f sshForward, listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !c.isStopped() && !f.isRemote() {
+				c.pf("Failed to accept connection for %s: %v", f, err)
+			}
+			return err
+		}
+		handle := c.forward
+		if f.dynamic {
+			handle = c.forwardDynamic
+		}
+		go func() {
+			if err := handle(f, conn); err != nil {
+				c.pf("Error forwarding SSH tunnel connection %s: %v", f, err)
+			}
+		}()
+	}
 }
 
-func (c *SSHTunnelCommandWrapper) forward() error {
+// forward connects an accepted connection to the other side of the forward.
+func (c *SSHTunnelCommandWrapper) forward(f sshForward, accepted net.Conn) error {
+	var other net.Conn
 	var err error
-	c.localToJumpConnection, err = ssh.Dial("tcp", c.jumpAddress, c.config)
+	if f.isRemote() {
+		other, err = net.Dial("tcp", f.local)
+	} else {
+		var client *ssh.Client
+		client, err = c.sshClient()
+		if err == nil {
+			other, err = client.Dial("tcp", f.target)
+		}
+	}
 	if err != nil {
-		c.pf("Failed to connect to jump server %s: %v", c.jumpAddress, err)
+		accepted.Close()
 		return err
 	}
+	c.conns.pipe(accepted, other)
+	return nil
+}
 
-	c.jumpToTargetConnection, err = c.localToJumpConnection.Dial("tcp", c.targetAddress)
+// forwardDynamic reads the target requested by a SOCKS5 client and connects to it through the jump server.
+func (c *SSHTunnelCommandWrapper) forwardDynamic(f sshForward, accepted net.Conn) error {
+	target, err := socks5Handshake(accepted)
 	if err != nil {
-		c.pf("Failed to connect to target from jump server: %v", err)
+		accepted.Close()
 		return err
 	}
+	c.pf("SOCKS5 connection %s from %s to %s", f.local, accepted.RemoteAddr(), target)
+	client, err := c.sshClient()
+	if err != nil {
+		socks5Reply(accepted, socks5GeneralFailure)
+		accepted.Close()
+		return err
+	}
+	other, err := client.Dial("tcp", target)
+	if err != nil {
+		socks5Reply(accepted, socks5HostUnreachable)
+		accepted.Close()
+		return errors.Wrapf(err, "SOCKS5 connection to %s", target)
+	}
+	if err := socks5Reply(accepted, socks5Succeeded); err != nil {
+		accepted.Close()
+		other.Close()
+		return err
+	}
+	c.conns.pipe(accepted, other)
+	return nil
+}
 
-	// Copy localConnection.Reader to jumpToTargetConnection.Writer
-	go func() {
-		if c.localConnection == nil || c.jumpToTargetConnection == nil {
-			c.pf("Missing connection: local=%v jump=%v", c.localConnection, c.jumpToTargetConnection)
-			return
+// sshClient returns the SSH connection to the jump server, dialing it if needed.
+// Only one connection is dialed at a time, outside the lock so that the tunnel can be stopped meanwhile.
+func (c *SSHTunnelCommandWrapper) sshClient() (*ssh.Client, error) {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("SSH tunnel stopped")
+	}
+	if c.chain != nil {
+		client := c.chain.client()
+		c.mu.Unlock()
+		return client, nil
+	}
+	d := c.dialing
+	if d == nil {
+		d = &sshDial{done: make(chan struct{})}
+		c.dialing = d
+		c.mu.Unlock()
+		c.dial(d)
+	} else {
+		done := c.doneChannel()
+		c.mu.Unlock()
+		select {
+		case <-d.done:
+		case <-done:
+			return nil, fmt.Errorf("SSH tunnel stopped")
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.chain.client(), nil
+}
+
+// dial connects to the jump servers and sets the result in d. A new connection is monitored and
+// opens the remote forwards.
+func (c *SSHTunnelCommandWrapper) dial(d *sshDial) {
+	chain, err := dialSSHHops(c.hops)
+	c.mu.Lock()
+	c.dialing = nil
+	stopped := c.stopped
+	if err == nil && stopped {
+		chain.Close()
+		err = fmt.Errorf("SSH tunnel stopped")
+	}
+	if err == nil {
+		c.chain = chain
+	}
+	d.chain, d.err = chain, err
+	close(d.done)
+	c.mu.Unlock()
+	if err != nil {
+		if !stopped {
+			c.pf("Failed to connect to jump server %s: %v", c.jumpAddress, err)
+		}
+		return
+	}
+	c.pf("Connected to jump server %s", c.jumpAddress)
+	go c.monitor(chain)
+	c.openRemoteForwards(chain)
+}
+
+func (c *SSHTunnelCommandWrapper) openRemoteForwards(chain *sshChain) {
+	for _, f := range c.forwards {
+		if !f.isRemote() {
+			continue
 		}
-		_, err = io.Copy(c.jumpToTargetConnection, c.localConnection)
+		listener, err := chain.client().Listen("tcp", f.remote)
 		if err != nil {
-			c.pf("Error copying data from local to target: %v", err)
+			c.pf("Failed to start remote listener for %s: %v", f, err)
+			continue
 		}
-	}()
+		c.mu.Lock()
+		if c.stopped || c.chain != chain {
+			c.mu.Unlock()
+			listener.Close()
+			return
+		}
+		c.remoteListeners = append(c.remoteListeners, listener)
+		c.mu.Unlock()
+		go c.serve(f, listener)
+	}
+}
 
-	// Copy jumpToTargetConnection.Reader to localConnection.Writer
+// monitor sends keepalive requests on the connection and handles its loss.
+func (c *SSHTunnelCommandWrapper) monitor(chain *sshChain) {
+	client := chain.client()
+	c.mu.Lock()
+	done := c.doneChannel()
+	c.mu.Unlock()
+	closed := make(chan error, 1)
 	go func() {
-		if c.localConnection == nil || c.jumpToTargetConnection == nil {
-			c.pf("Missing connection: local=%v target=%v", c.localConnection, c.jumpToTargetConnection)
+		closed <- client.Wait()
+	}()
+	var keepalive <-chan time.Time
+	if c.keepaliveInterval > 0 {
+		ticker := time.NewTicker(c.keepaliveInterval)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+	for {
+		select {
+		case <-done:
 			return
+		case err := <-closed:
+			c.connectionLost(chain, err)
+			return
+		case <-keepalive:
+			if err := sendKeepalive(client, c.keepaliveInterval); err != nil {
+				c.connectionLost(chain, err)
+				return
+			}
 		}
-		_, err = io.Copy(c.localConnection, c.jumpToTargetConnection)
-		if err != nil {
-			c.pf("Error copying data from target to local: %v", err)
+	}
+}
+
+func (c *SSHTunnelCommandWrapper) connectionLost(chain *sshChain, cause error) {
+	c.mu.Lock()
+	if c.stopped || c.chain != chain {
+		c.mu.Unlock()
+		return
+	}
+	c.chain = nil
+	c.closeRemoteListeners()
+	c.mu.Unlock()
+	if cause == nil {
+		cause = io.EOF
+	}
+	c.pf("SSH connection to jump server %s lost: %v", c.jumpAddress, cause)
+	chain.Close()
+	go c.reconnect()
+}
+
+// reconnect connects to the jump server retrying with an exponential backoff until connected or stopped.
+func (c *SSHTunnelCommandWrapper) reconnect() {
+	backoff, maxBackoff := c.minBackoff, c.maxBackoff
+	if backoff == 0 {
+		backoff = sshTunnelMinBackoff
+	}
+	if maxBackoff == 0 {
+		maxBackoff = sshTunnelMaxBackoff
+	}
+	c.mu.Lock()
+	done := c.doneChannel()
+	c.mu.Unlock()
+	for {
+		if _, err := c.sshClient(); err == nil || c.isStopped() {
+			return
+		}
+		c.pf("Reconnecting to jump server %s in %s", c.jumpAddress, backoff)
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = backoff * 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
 		}
+	}
+}
+
+// sendKeepalive sends an OpenSSH keepalive request: any reply, even a failure, means the server is alive.
+func sendKeepalive(client *ssh.Client, timeout time.Duration) error {
+	res := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		res <- err
 	}()
-	return nil
+	select {
+	case err := <-res:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no reply to keepalive in %s", timeout)
+	}
+}
+
+// connTracker tracks the connections piped by a command, so that they are closed on stop.
+type connTracker struct {
+	mu          sync.Mutex
+	connections map[net.Conn]struct{}
+	closed      bool
+}
+
+// pipe pipes two connections until one side is done or the tracker is closed.
+func (t *connTracker) pipe(a, b net.Conn) {
+	if !t.track(a, b) {
+		a.Close()
+		b.Close()
+		return
+	}
+	defer t.untrack(a, b)
+	pipe(a, b)
+}
+
+func (t *connTracker) track(conns ...net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	if t.connections == nil {
+		t.connections = map[net.Conn]struct{}{}
+	}
+	for _, conn := range conns {
+		t.connections[conn] = struct{}{}
+	}
+	return true
+}
+
+func (t *connTracker) untrack(conns ...net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, conn := range conns {
+		delete(t.connections, conn)
+	}
+}
+
+// closeAll closes the tracked connections, the ones piped later are closed immediately.
+func (t *connTracker) closeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for conn := range t.connections {
+		conn.Close()
+	}
+	t.connections = nil
+}
+
+// pipe copies data in both directions and closes both connections when one side is done.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copyConn(a, b)
+	go copyConn(b, a)
+	<-done
+	a.Close()
+	b.Close()
 }
 
 // SSHTunnelCommandStopper is the component calling the actual command stopping the process.
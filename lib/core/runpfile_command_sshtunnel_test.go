@@ -14,9 +14,8 @@ func TestSSHTunnelCommandWrapper(t *testing.T) {
 
 	t.Run("Pid", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 
 		pid := wrapper.Pid()
@@ -27,9 +26,8 @@ func TestSSHTunnelCommandWrapper(t *testing.T) {
 
 	t.Run("Stdout", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 
 		var buf bytes.Buffer
@@ -42,9 +40,8 @@ func TestSSHTunnelCommandWrapper(t *testing.T) {
 
 	t.Run("Stderr", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 
 		var buf bytes.Buffer
@@ -57,9 +54,8 @@ func TestSSHTunnelCommandWrapper(t *testing.T) {
 
 	t.Run("Start", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 
 		var buf bytes.Buffer
@@ -69,13 +65,13 @@ func TestSSHTunnelCommandWrapper(t *testing.T) {
 		if err != nil {
 			t.Errorf("Start() should succeed, got error: %v", err)
 		}
+		wrapper.Stop()
 	})
 
 	t.Run("Run", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 
 		err := wrapper.Run()
@@ -86,9 +82,8 @@ func TestSSHTunnelCommandWrapper(t *testing.T) {
 
 	t.Run("Stop", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 
 		var buf bytes.Buffer
@@ -103,9 +98,8 @@ func TestSSHTunnelCommandWrapper(t *testing.T) {
 
 	t.Run("String", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 
 		str := wrapper.String()
@@ -126,9 +120,8 @@ func TestSSHTunnelCommandStopper(t *testing.T) {
 
 	t.Run("Pid", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 		stopper := &SSHTunnelCommandStopper{
 			id:  "test-id",
@@ -143,9 +136,8 @@ func TestSSHTunnelCommandStopper(t *testing.T) {
 
 	t.Run("Stdout", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 		stopper := &SSHTunnelCommandStopper{
 			id:  "test-id",
@@ -163,9 +155,8 @@ func TestSSHTunnelCommandStopper(t *testing.T) {
 
 	t.Run("Stderr", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 		stopper := &SSHTunnelCommandStopper{
 			id:  "test-id",
@@ -183,9 +174,8 @@ func TestSSHTunnelCommandStopper(t *testing.T) {
 
 	t.Run("Start", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 		stopper := &SSHTunnelCommandStopper{
 			id:  "test-id",
@@ -200,9 +190,8 @@ func TestSSHTunnelCommandStopper(t *testing.T) {
 
 	t.Run("Run", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 		stopper := &SSHTunnelCommandStopper{
 			id:  "test-id",
@@ -217,9 +206,8 @@ func TestSSHTunnelCommandStopper(t *testing.T) {
 
 	t.Run("Stop", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 		stopper := &SSHTunnelCommandStopper{
 			id:  "test-id",
@@ -234,9 +222,8 @@ func TestSSHTunnelCommandStopper(t *testing.T) {
 
 	t.Run("Wait", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 		stopper := &SSHTunnelCommandStopper{
 			id:  "test-id",
@@ -251,9 +238,8 @@ func TestSSHTunnelCommandStopper(t *testing.T) {
 
 	t.Run("String", func(t *testing.T) {
 		wrapper := &SSHTunnelCommandWrapper{
-			localAddress:  "localhost:8080",
-			jumpAddress:   "jump:22",
-			targetAddress: "target:80",
+			jumpAddress: "jump:22",
+			forwards:    []sshForward{{local: "localhost:8080", target: "target:80"}},
 		}
 		stopper := &SSHTunnelCommandStopper{
 			id:  "test-id",
@@ -0,0 +1,320 @@
+package core
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// encryptedTag marks a YAML node holding a whole encrypted section: the value is the output of
+// `runp encrypt` applied to the YAML of the section.
+const encryptedTag = "!encrypted"
+
+// isEncryptedSection returns true if node is an encrypted section.
+func isEncryptedSection(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == encryptedTag
+}
+
+// parseRunpfileData returns the YAML document of the Runpfile data with the encrypted sections replaced
+// by their plain YAML. The nodes keep their positions in data, the ones of encrypted sections have the
+// position of the section.
+func parseRunpfileData(data []byte, key string) (*yaml.Node, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	if _, err := decryptSections(doc, key); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// decryptSections replaces the encrypted sections found in node and returns true if at least one is found.
+// Encrypted sections can contain other encrypted sections.
+func decryptSections(node *yaml.Node, key string) (bool, error) {
+	found := false
+	if isEncryptedSection(node) {
+		section, err := decryptSection(node, key)
+		if err != nil {
+			return false, err
+		}
+		*node = *section
+		found = true
+	}
+	for _, n := range node.Content {
+		f, err := decryptSections(n, key)
+		if err != nil {
+			return false, err
+		}
+		found = found || f
+	}
+	return found, nil
+}
+
+func decryptSection(node *yaml.Node, key string) (*yaml.Node, error) {
+	if key == "" {
+		return nil, errors.Errorf("line %d: encrypted section requires the encryption key, use --key, --key-env or --key-file", node.Line)
+	}
+	plain, err := DecryptBase64(strings.TrimSpace(node.Value), key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "line %d: cannot decrypt section", node.Line)
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(plain, doc); err != nil {
+		return nil, errors.Wrapf(err, "line %d: invalid encrypted section", node.Line)
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}, nil
+	}
+	redactSection(doc.Content[0])
+	setPosition(doc.Content[0], node.Line, node.Column)
+	return doc.Content[0], nil
+}
+
+// setPosition sets the position of node and of its children, as the decrypted YAML is not in the file.
+func setPosition(node *yaml.Node, line int, column int) {
+	node.Line, node.Column = line, column
+	for _, n := range node.Content {
+		setPosition(n, line, column)
+	}
+}
+
+// redactSection registers the string values of a decrypted section to be redacted from the output.
+// Mapping keys, numbers and booleans are structure rather than secrets and are kept.
+func redactSection(node *yaml.Node) {
+	walkScalars(node, func(n *yaml.Node) {
+		if n.ShortTag() == "!!str" {
+			addRedacted(n.Value)
+		}
+	})
+}
+
+// EncryptRunpfileData encrypts the section of the Runpfile at path, a list of keys separated by dots
+// as in `units.db.container.env`. If path is empty the whole document is encrypted.
+func EncryptRunpfileData(data []byte, path string, key string) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("encryption key required")
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, errors.New("empty document")
+	}
+	node := doc.Content[0]
+	if path != "" {
+		var err error
+		if node, err = lookupNode(node, path); err != nil {
+			return nil, err
+		}
+	}
+	if isEncryptedSection(node) {
+		return nil, errors.Errorf("section %q is already encrypted", path)
+	}
+	plain, err := marshalNode(node)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := EncryptToBase64(plain, key)
+	if err != nil {
+		return nil, err
+	}
+	out, err := replaceSection(data, doc, node, encryptedTag+" "+encrypted)
+	if err != nil {
+		return nil, errors.Wrapf(err, "section %q", path)
+	}
+	// the rewritten document must have the encrypted section in place of the plain one
+	check := &yaml.Node{}
+	if err := yaml.Unmarshal(out, check); err != nil || len(check.Content) == 0 {
+		return nil, errors.Errorf("section %q: cannot rewrite the Runpfile", path)
+	}
+	section := check.Content[0]
+	if path != "" {
+		section, err = lookupNode(section, path)
+	}
+	if err != nil || !isEncryptedSection(section) || section.Value != encrypted {
+		return nil, errors.Errorf("section %q: cannot rewrite the Runpfile", path)
+	}
+	return out, nil
+}
+
+// replaceSection returns data with the text of node replaced by value, keeping the rest of the file as it is.
+// The text of a block collection starts after the colon of its key, so that value is written on the key line.
+// Trailing blank lines and comments are kept.
+func replaceSection(data []byte, doc *yaml.Node, node *yaml.Node, value string) ([]byte, error) {
+	ancestors := nodeAncestors(doc, node)
+	for _, a := range ancestors {
+		if a.Style&yaml.FlowStyle != 0 {
+			return nil, errors.New("sections inside flow collections cannot be encrypted, write the parent in block style")
+		}
+	}
+	text := string(data)
+	lineStarts := []int{0}
+	for i, c := range text {
+		if c == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	offset := func(line int, column int) int {
+		o := lineStarts[line-1]
+		for column > 1 && o < len(text) {
+			_, size := utf8.DecodeRuneInString(text[o:])
+			o += size
+			column--
+		}
+		return o
+	}
+
+	start := offset(node.Line, node.Column)
+	end := len(text)
+	if next := nextNode(doc, node); next != nil {
+		end = offset(next.Line, next.Column)
+	}
+	// trailing blank lines and comments are not part of the section
+	region := strings.SplitAfter(text[start:end], "\n")
+	for len(region) > 1 {
+		last := strings.TrimSpace(region[len(region)-1])
+		if last != "" && !strings.HasPrefix(last, "#") {
+			break
+		}
+		region = region[:len(region)-1]
+	}
+	end = start + len(strings.TrimRight(strings.Join(region, ""), " \t\r\n"))
+	if comment := node.LineComment; comment != "" && strings.HasSuffix(text[start:end], comment) {
+		end = start + len(strings.TrimRight(text[start:end-len(comment)], " \t"))
+	}
+
+	replacement := value
+	if len(ancestors) > 0 {
+		parent := ancestors[len(ancestors)-1]
+		if key := mappingKey(parent, node); key != nil && node.Line > key.Line {
+			colon, err := keyEnd(text, offset(key.Line, key.Column), key)
+			if err != nil {
+				return nil, err
+			}
+			// a comment after the key is kept after the value
+			rest := text[colon:lineStarts[key.Line]]
+			if lineEnd := strings.TrimSpace(rest); strings.HasPrefix(lineEnd, "#") {
+				replacement += " " + lineEnd
+			}
+			start = colon
+			replacement = " " + replacement
+		}
+	}
+	return []byte(text[:start] + replacement + text[end:]), nil
+}
+
+// keyEnd returns the offset after the colon following the key starting at offset o.
+func keyEnd(text string, o int, key *yaml.Node) (int, error) {
+	switch key.Style {
+	case yaml.DoubleQuotedStyle, yaml.SingleQuotedStyle:
+		quote := text[o]
+		o++
+		for o < len(text) && text[o] != quote {
+			if text[o] == '\\' && quote == '"' || text[o] == '\'' && o+1 < len(text) && text[o+1] == '\'' {
+				o++
+			}
+			o++
+		}
+		o++
+	default:
+		o += len(key.Value)
+	}
+	for o < len(text) && (text[o] == ' ' || text[o] == '\t') {
+		o++
+	}
+	if o >= len(text) || text[o] != ':' {
+		return 0, errors.Errorf("line %d: cannot find the end of key %s", key.Line, key.Value)
+	}
+	return o + 1, nil
+}
+
+// nodeAncestors returns the collections containing node, from the root.
+func nodeAncestors(root *yaml.Node, node *yaml.Node) []*yaml.Node {
+	for _, n := range root.Content {
+		if n == node {
+			return []*yaml.Node{root}
+		}
+		if a := nodeAncestors(n, node); a != nil {
+			return append([]*yaml.Node{root}, a...)
+		}
+	}
+	return nil
+}
+
+// mappingKey returns the key of value in the mapping, nil if parent is not a mapping.
+func mappingKey(parent *yaml.Node, value *yaml.Node) *yaml.Node {
+	if parent.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		if parent.Content[i+1] == value {
+			return parent.Content[i]
+		}
+	}
+	return nil
+}
+
+// nextNode returns the first node after node in the document, not contained in it.
+func nextNode(doc *yaml.Node, node *yaml.Node) *yaml.Node {
+	var next *yaml.Node
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n == node {
+			return
+		}
+		if n.Kind != yaml.DocumentNode && (n.Line > node.Line || n.Line == node.Line && n.Column > node.Column) {
+			if next == nil || n.Line < next.Line || n.Line == next.Line && n.Column < next.Column {
+				next = n
+			}
+		}
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return next
+}
+
+// lookupNode returns the node at path: mapping keys and sequence indexes separated by dots.
+func lookupNode(node *yaml.Node, path string) (*yaml.Node, error) {
+	for _, segment := range strings.Split(path, ".") {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == segment {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(segment); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+			}
+		}
+		if next == nil {
+			return nil, errors.Errorf("section %q not found", path)
+		}
+		node = next
+	}
+	return node, nil
+}
+
+func marshalNode(node *yaml.Node) ([]byte, error) {
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,246 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const encryptedSectionsRunpfile = `include:
+  - included.yml
+units:
+  db:
+    container:
+      image: postgres
+      env:
+        POSTGRES_USER: app
+        POSTGRES_PASSWORD: pa$$word
+`
+
+const encryptedSectionsIncluded = `units:
+  api:
+    host:
+      command: ./api
+      env:
+        API_TOKEN: token
+`
+
+// writeEncryptedRunpfiles writes a Runpfile with an encrypted section including a whole encrypted Runpfile.
+func writeEncryptedRunpfiles(t *testing.T, key string) string {
+	t.Helper()
+	dir := t.TempDir()
+	main, err := EncryptRunpfileData([]byte(encryptedSectionsRunpfile), "units.db.container.env", key)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	included, err := EncryptRunpfileData([]byte(encryptedSectionsIncluded), "", key)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for name, data := range map[string][]byte{"Runpfile": main, "included.yml": included} {
+		if strings.Contains(string(data), "pa$$word") || strings.Contains(string(data), "API_TOKEN") {
+			t.Fatalf("%s: expected section encrypted, got %s", name, data)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "Runpfile")
+}
+
+func TestLoadEncryptedSections(t *testing.T) {
+	setupTestUI(t)
+	restoreRedacted(t)
+	runpfile := writeEncryptedRunpfiles(t, "thekey")
+	rf, err := LoadRunpfileFromPathWithKey(runpfile, "thekey")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	assertMapEquals(rf.Units["db"].Container.Env, map[string]string{"POSTGRES_USER": "app", "POSTGRES_PASSWORD": "pa$$word"}, "db env", t)
+	assertMapEquals(rf.Units["api"].Host.Env, map[string]string{"API_TOKEN": "token"}, "api env", t)
+	if rf.Units["db"].Container.Image != "postgres" {
+		t.Errorf("Expected plain settings loaded, got image <%s>", rf.Units["db"].Container.Image)
+	}
+
+	testCases := map[string]string{
+		"":      "encrypted section requires the encryption key",
+		"other": "cannot decrypt section",
+	}
+	for key, expected := range testCases {
+		_, err := LoadRunpfileFromPathWithKey(runpfile, key)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Key <%s>, expected error containing <%s>, got <%v>", key, expected, err)
+		}
+	}
+}
+
+func TestEncryptedSectionsRedacted(t *testing.T) {
+	setupTestUI(t)
+	restoreRedacted(t)
+	data, err := EncryptRunpfileData([]byte(`units:
+  api:
+    host:
+      executable: echo
+      args:
+        - $DB_PASSWORD
+      env:
+        DB_PASSWORD: supersecretpw
+`), "units.api.host.env", "thekey")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	runpfile := filepath.Join(t.TempDir(), "Runpfile")
+	if err := os.WriteFile(runpfile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := LoadRunpfileFromPathWithKey(runpfile, "thekey")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.SecretKey = "thekey"
+	rf.Vars = map[string]string{}
+
+	config, err := NewResolvedConfig(rf)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	assertMapEquals(config.Units["api"].Env, map[string]string{"DB_PASSWORD": redactedText}, "config env", t)
+
+	plan, err := NewExecutor(rf).Plan()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for _, kv := range plan.Units[0].Env {
+		if strings.Contains(kv, "supersecretpw") {
+			t.Errorf("Expected decrypted value redacted in the plan, got %s", kv)
+		}
+	}
+	if !sliceContains(plan.Units[0].Env, "DB_PASSWORD="+redactedText) {
+		t.Errorf("Expected redacted DB_PASSWORD in the plan, got %v", plan.Units[0].Env)
+	}
+	if actual := redact("Container command: -e DB_PASSWORD=supersecretpw"); strings.Contains(actual, "supersecretpw") {
+		t.Errorf("Expected decrypted value redacted in the log output, got %s", actual)
+	}
+}
+
+func TestEncryptRunpfileDataKeepsFormatting(t *testing.T) {
+	setupTestUI(t)
+	restoreRedacted(t)
+	runpfile := `# Runpfile of the app
+name: app
+
+vars:
+    db_user: app  # user
+
+units:
+    # the database
+    db:
+        container:
+            image: postgres
+            env: # connection
+                # credentials
+                POSTGRES_USER: app
+
+                POSTGRES_PASSWORD: "pa$$word"   # quoted
+
+            # exposed port
+            ports:
+                - "5432:5432"
+    api:
+        host:
+            command: ./api
+            args:
+                - --token
+                - s3cr3t
+    "quoted key":
+        host:
+            command: echo
+`
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"units.db.container.env", strings.Replace(runpfile, `env: # connection
+                # credentials
+                POSTGRES_USER: app
+
+                POSTGRES_PASSWORD: "pa$$word"   # quoted
+`, "env: !encrypted CIPHERTEXT # connection\n", 1)},
+		{"vars.db_user", strings.Replace(runpfile, "db_user: app  # user", "db_user: !encrypted CIPHERTEXT  # user", 1)},
+		{"units.api.host.args.1", strings.Replace(runpfile, "- s3cr3t", "- !encrypted CIPHERTEXT", 1)},
+		{"units.quoted key", strings.Replace(runpfile, `"quoted key":
+        host:
+            command: echo`, `"quoted key": !encrypted CIPHERTEXT`, 1)},
+		{"", "# Runpfile of the app\n!encrypted CIPHERTEXT\n"},
+	}
+	for _, tc := range testCases {
+		data, err := EncryptRunpfileData([]byte(runpfile), tc.path, "thekey")
+		if err != nil {
+			t.Errorf("Path <%s>, unexpected error %v", tc.path, err)
+			continue
+		}
+		ciphertext := regexp.MustCompile(`!encrypted (\S+)`).FindStringSubmatch(string(data))
+		if ciphertext == nil {
+			t.Errorf("Path <%s>, expected encrypted section, got\n%s", tc.path, data)
+			continue
+		}
+		if expected := strings.Replace(tc.expected, "CIPHERTEXT", ciphertext[1], 1); string(data) != expected {
+			t.Errorf("Path <%s>, expected\n%s\ngot\n%s", tc.path, expected, data)
+		}
+		rf, err := loadRunpfileFromData(data, runpfileSource{key: "thekey"})
+		if err != nil {
+			t.Errorf("Path <%s>, unexpected error loading %v", tc.path, err)
+			continue
+		}
+		if rf.Units["db"].Container.Env["POSTGRES_PASSWORD"] != "pa$$word" || rf.Units["api"].Host.Args[1] != "s3cr3t" || rf.Vars["db_user"] != "app" {
+			t.Errorf("Path <%s>, expected the same settings, got %+v", tc.path, rf)
+		}
+	}
+}
+
+func TestEncryptRunpfileDataErrors(t *testing.T) {
+	data := []byte(encryptedSectionsRunpfile)
+	encrypted, err := EncryptRunpfileData(data, "units.db.container.env", "thekey")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	testCases := []struct {
+		data []byte
+		path string
+		key  string
+		err  string
+	}{
+		{data, "units.db.container.env", "", "encryption key required"},
+		{data, "units.web", "thekey", `section "units.web" not found`},
+		{data, "include.1", "thekey", `section "include.1" not found`},
+		{encrypted, "units.db.container.env", "thekey", `section "units.db.container.env" is already encrypted`},
+		{[]byte("units: {db: {host: {command: ./db}}}\n"), "units.db", "thekey", "write the parent in block style"},
+	}
+	for _, tc := range testCases {
+		_, err := EncryptRunpfileData(tc.data, tc.path, tc.key)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Path <%s>, expected error containing <%s>, got <%v>", tc.path, tc.err, err)
+		}
+	}
+}
+
+func TestRekeyEncryptedSections(t *testing.T) {
+	setupTestUI(t)
+	restoreRedacted(t)
+	runpfile := writeEncryptedRunpfiles(t, "oldkey")
+	rekeyed, err := Rekey(runpfile, "oldkey", "newkey")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(rekeyed) != 2 {
+		t.Errorf("Expected Runpfile and included file rekeyed, got %+v", rekeyed)
+	}
+	rf, err := LoadRunpfileFromPathWithKey(runpfile, "newkey")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	assertMapEquals(rf.Units["api"].Host.Env, map[string]string{"API_TOKEN": "token"}, "api env", t)
+	assertMapEquals(rf.Units["db"].Container.Env, map[string]string{"POSTGRES_USER": "app", "POSTGRES_PASSWORD": "pa$$word"}, "db env", t)
+}
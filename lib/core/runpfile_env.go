@@ -0,0 +1,156 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/subosito/gotenv"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// encryptedEnvPrefix marks env values encrypted using `runp encrypt`.
+const encryptedEnvPrefix = "encrypted:"
+
+// StringList is a list of strings accepting a single string too.
+type StringList []string
+
+// UnmarshalYAML accepts a scalar or a sequence.
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = StringList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// InheritEnv is the policy passing the runp environment to a process: `all`, `none` (the default)
+// or a list of names, supporting wildcards as in `LC_*`.
+type InheritEnv struct {
+	All   bool
+	Names []string
+}
+
+// UnmarshalYAML accepts `all`, `none` or a list of names.
+func (i *InheritEnv) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		switch value.Value {
+		case "all":
+			*i = InheritEnv{All: true}
+		case "none", "":
+			*i = InheritEnv{}
+		default:
+			return errors.Errorf("line %d: invalid inherit_env %q: expected all, none or a list of names", value.Line, value.Value)
+		}
+		return nil
+	}
+	var names []string
+	if err := value.Decode(&names); err != nil {
+		return err
+	}
+	*i = InheritEnv{Names: names}
+	return nil
+}
+
+// environ returns the variables of environ, in the form "key=value", passed by the policy.
+func (i InheritEnv) environ(environ []string) []string {
+	inherited := []string{}
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if i.All || i.matches(name) {
+			inherited = append(inherited, kv)
+		}
+	}
+	return inherited
+}
+
+func (i InheritEnv) matches(name string) bool {
+	for _, pattern := range i.Names {
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadEnvFiles reads the dotenv files, relative paths are resolved from dir.
+// The values of the later files override the ones of the former.
+func loadEnvFiles(envFiles []string, dir string, vars map[string]string) (map[string]string, error) {
+	env := map[string]string{}
+	cliPreprocessor := newCliPreprocessor(vars)
+	for _, f := range envFiles {
+		resolved, err := resolvePath(cliPreprocessor.process(f), dir)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(resolved)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read env_file %s", resolved)
+		}
+		values, err := gotenv.StrictParse(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid env_file %s", resolved)
+		}
+		ui.Debugf("Loaded %d environment variables from %s", len(values), resolved)
+		for k, v := range values {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+// mergeEnv returns the variables of all the maps, the values of later maps override the ones of the former.
+func mergeEnv(envs ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, env := range envs {
+		for k, v := range env {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// processEnv processes the values with vars and returns the variables in the form "key=value".
+// Values with the encrypted: prefix are decrypted using secretKey and are not processed.
+func processEnv(env map[string]string, vars map[string]string, secretKey string) ([]string, error) {
+	cliPreprocessor := newCliPreprocessor(vars)
+	processedEnv := map[string]string{}
+	decrypted := []string{}
+	for k, v := range env {
+		if isEncryptedEnv(v) {
+			plain, err := decryptEnv(k, v, secretKey)
+			if err != nil {
+				return nil, err
+			}
+			decrypted = append(decrypted, k+"="+plain)
+			continue
+		}
+		processedEnv[k] = cliPreprocessor.process(v)
+	}
+	return append(envAsArray(processedEnv), decrypted...), nil
+}
+
+// isEncryptedEnv returns true for values encrypted using `runp encrypt`, in the form "encrypted:<base64>".
+func isEncryptedEnv(value string) bool {
+	return strings.HasPrefix(value, encryptedEnvPrefix)
+}
+
+// decryptEnv returns the decrypted value of the variable, registered to be redacted from the output.
+func decryptEnv(name string, value string, secretKey string) (string, error) {
+	if secretKey == "" {
+		return "", errors.Errorf("env %s: encryption key required but not provided, use --key, --key-env or --key-file", name)
+	}
+	plain, err := DecryptBase64(strings.TrimSpace(strings.TrimPrefix(value, encryptedEnvPrefix)), secretKey)
+	if err != nil {
+		return "", errors.Wrapf(err, "env %s: cannot decrypt value", name)
+	}
+	addSecret("env "+name, string(plain))
+	return string(plain), nil
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInheritEnvUnmarshal(t *testing.T) {
+	testCases := []struct {
+		spec     string
+		expected InheritEnv
+		err      string
+	}{
+		{`inherit_env: all`, InheritEnv{All: true}, ""},
+		{`inherit_env: none`, InheritEnv{}, ""},
+		{`inherit_env: [PATH, "LC_*"]`, InheritEnv{Names: []string{"PATH", "LC_*"}}, ""},
+		{`inherit_env: some`, InheritEnv{}, `invalid inherit_env "some"`},
+	}
+	for _, tc := range testCases {
+		p, err := createHostProcessFromSpec(tc.spec)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error containing <%s>, got <%v>", tc.spec, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc.spec, err)
+		}
+		if p.InheritEnv.All != tc.expected.All || strings.Join(p.InheritEnv.Names, ",") != strings.Join(tc.expected.Names, ",") {
+			t.Errorf("%s: expected %+v, got %+v", tc.spec, tc.expected, p.InheritEnv)
+		}
+	}
+}
+
+func TestInheritEnvEnviron(t *testing.T) {
+	environ := []string{"PATH=/bin", "HOME=/home/runp", "LC_ALL=C", "LC_TIME=C", "LANG=en"}
+	testCases := []struct {
+		policy   InheritEnv
+		expected []string
+	}{
+		{InheritEnv{}, []string{}},
+		{InheritEnv{All: true}, environ},
+		{InheritEnv{Names: []string{"PATH", "LC_*"}}, []string{"PATH=/bin", "LC_ALL=C", "LC_TIME=C"}},
+	}
+	for _, tc := range testCases {
+		actual := tc.policy.environ(environ)
+		if strings.Join(actual, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("Policy %+v, expected %v, got %v", tc.policy, tc.expected, actual)
+		}
+	}
+}
+
+func TestEnvFileAsString(t *testing.T) {
+	p, err := createHostProcessFromSpec(`env_file: .env`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(p.EnvFile) != 1 || p.EnvFile[0] != ".env" {
+		t.Errorf("Expected env_file [.env], got %v", p.EnvFile)
+	}
+}
+
+func TestLoadEnvFilesErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "invalid.env"), []byte("NOT A VALID LINE\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string]string{
+		"missing.env": "cannot read env_file",
+		"invalid.env": "invalid env_file",
+	}
+	for file, expected := range testCases {
+		_, err := loadEnvFiles([]string{file}, dir, map[string]string{})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing <%s>, got <%v>", file, expected, err)
+		}
+	}
+}
+
+func TestUnitEnvironment(t *testing.T) {
+	setupTestUI(t)
+	t.Setenv("LC_RUNP_TEST", "it")
+	rf, err := LoadRunpfileFromPath("../../testdata/runpfiles/env-file/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	executor := NewExecutor(rf)
+	executor.initializeUnits()
+	testCases := []struct {
+		unit     string
+		expected map[string]string
+	}{
+		{"api", map[string]string{"LOG_LEVEL": "debug", "REGION": "ap", "DB_HOST": "localhost", "LC_RUNP_TEST": "it", "PATH": os.Getenv("PATH")}},
+		{"worker", map[string]string{"LOG_LEVEL": "info", "REGION": "ap", "DB_HOST": "localhost", "LC_RUNP_TEST": "it", "HOME": os.Getenv("HOME")}},
+		{"included", map[string]string{"LOG_LEVEL": "info", "REGION": "us"}},
+	}
+	for _, tc := range testCases {
+		environment, err := rf.Units[tc.unit].Host.buildEnvironment()
+		if err != nil {
+			t.Fatalf("Unit %s, unexpected error %v", tc.unit, err)
+		}
+		// the last value of duplicated keys is used
+		actual := map[string]string{}
+		for _, kv := range environment {
+			parts := strings.SplitN(kv, "=", 2)
+			actual[parts[0]] = parts[1]
+		}
+		for k, v := range tc.expected {
+			if actual[k] != v {
+				t.Errorf("Unit %s, %s expected <%s> got <%s>", tc.unit, k, v, actual[k])
+			}
+		}
+		if tc.unit == "included" && len(actual) != len(tc.expected) {
+			t.Errorf("Unit %s, expected no inherited variables, got %v", tc.unit, environment)
+		}
+	}
+}
+
+func TestEncryptedEnv(t *testing.T) {
+	setupTestUI(t)
+	encrypted, err := EncryptToBase64([]byte("pa$$word"), "thekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"DB_PASSWORD": encryptedEnvPrefix + encrypted,
+		"DB_USER":     "{{vars user}}",
+	}
+	vars := map[string]string{"user": "app"}
+	actual, err := processEnv(env, vars, "thekey")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := map[string]string{"DB_PASSWORD": "pa$$word", "DB_USER": "app"}
+	actualMap := map[string]string{}
+	for _, kv := range actual {
+		parts := strings.SplitN(kv, "=", 2)
+		actualMap[parts[0]] = parts[1]
+	}
+	assertMapEquals(actualMap, expected, "Env", t)
+	if redacted := redact("password pa$$word"); redacted != "password "+redactedText {
+		t.Errorf("Expected decrypted value redacted, got <%s>", redacted)
+	}
+
+	testCases := map[string]string{
+		"":      "env DB_PASSWORD: encryption key required but not provided",
+		"other": "env DB_PASSWORD: cannot decrypt value",
+	}
+	for key, e := range testCases {
+		_, err := processEnv(env, vars, key)
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("Key <%s>, expected error containing <%s>, got <%v>", key, e, err)
+		}
+	}
+}
+
+func TestContainerEncryptedEnv(t *testing.T) {
+	setupTestUI(t)
+	encrypted, err := EncryptToBase64([]byte("container-secret"), "thekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ContainerProcess{
+		Image:               "postgres",
+		Env:                 map[string]string{"POSTGRES_PASSWORD": encryptedEnvPrefix + encrypted},
+		secretKey:           "thekey",
+		environmentSettings: &EnvironmentSettings{ContainerRunnerExe: runner},
+	}
+	cl, secretEnv, err := p.buildCmdLine()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if strings.Contains(cl, "container-secret") || strings.Contains(cl, encrypted) {
+		t.Errorf("Expected secret not in command line, got %s", cl)
+	}
+	if !strings.Contains(cl, `-e "POSTGRES_PASSWORD" `) {
+		t.Errorf("Expected variable name passed to the container, got %s", cl)
+	}
+	if len(secretEnv) != 1 || secretEnv[0] != "POSTGRES_PASSWORD=container-secret" {
+		t.Errorf("Expected secret in the environment of the container runner, got %v", secretEnv)
+	}
+}
@@ -32,6 +32,9 @@ type RunpfileExecutor struct {
 	longest             int
 	environmentSettings *EnvironmentSettings
 	newPipe             func() (*os.File, *os.File, error)
+	// dryRun resolves the units without side effects: secret providers are not called and
+	// runp-network is not created
+	dryRun bool
 }
 
 func (e *RunpfileExecutor) longestName() int {
@@ -59,6 +62,9 @@ func (e *RunpfileExecutor) Start() error {
 		}
 		ui.WriteLinef("Units skipped due to unsatisfied preconditions: %v", names)
 	}
+	if err := e.checkTemplates(skipped); err != nil {
+		return err
+	}
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
@@ -89,8 +95,11 @@ func (e *RunpfileExecutor) Start() error {
 }
 
 func (e *RunpfileExecutor) initializeUnits() {
+	store := newSecretStore(e.rf)
+	store.redactOnly = e.dryRun
+	setActiveSecrets(store)
 	for _, unit := range e.rf.Units {
-		unit.vars = e.rf.Vars
+		unit.vars = unit.unitVars(e.rf.Vars)
 		unit.secretKey = e.rf.SecretKey
 		unit.environmentSettings = e.environmentSettings
 		unit.process = nil
@@ -99,12 +108,14 @@ func (e *RunpfileExecutor) initializeUnits() {
 			unit.Host.secretKey = unit.secretKey
 			unit.Host.stopTimeout = unit.StopTimeout
 			unit.Host.environmentSettings = e.environmentSettings
+			unit.Host.globalEnv = unit.globalEnv
 		}
 		if unit.Container != nil {
 			unit.Container.vars = unit.vars
 			unit.Container.secretKey = unit.secretKey
 			unit.Container.stopTimeout = unit.StopTimeout
 			unit.Container.environmentSettings = e.environmentSettings
+			unit.Container.globalEnv = unit.globalEnv
 		}
 		if unit.SSHTunnel != nil {
 			unit.SSHTunnel.vars = unit.vars
@@ -112,33 +123,96 @@ func (e *RunpfileExecutor) initializeUnits() {
 			unit.SSHTunnel.stopTimeout = unit.StopTimeout
 			unit.SSHTunnel.environmentSettings = e.environmentSettings
 		}
+		if unit.KubeForward != nil {
+			unit.KubeForward.vars = unit.vars
+			unit.KubeForward.secretKey = unit.secretKey
+			unit.KubeForward.stopTimeout = unit.StopTimeout
+			unit.KubeForward.environmentSettings = e.environmentSettings
+			unit.KubeForward.globalEnv = unit.globalEnv
+		}
+		if unit.Proxy != nil {
+			unit.Proxy.vars = unit.vars
+			unit.Proxy.secretKey = unit.secretKey
+			unit.Proxy.stopTimeout = unit.StopTimeout
+			unit.Proxy.environmentSettings = e.environmentSettings
+			unit.Proxy.units = e.rf.Units
+		}
 	}
 }
 
+// checkTemplates verifies the templates of the units to start, so that no unit is started
+// with missing vars.
+func (e *RunpfileExecutor) checkTemplates(skipped map[string]bool) error {
+	count := 0
+	for _, unit := range e.rf.Units {
+		if skipped[unit.Name] {
+			continue
+		}
+		for _, err := range append(unit.templateErrors(), unit.resolvePorts()...) {
+			ui.WriteLinef("%v", err)
+			count++
+		}
+	}
+	if count > 0 {
+		return fmt.Errorf("%d setting(s) with invalid templates", count)
+	}
+	return nil
+}
+
 func (e *RunpfileExecutor) skippedUnits() map[string]bool {
 	skipped := make(map[string]bool)
+	for name := range e.failedPreconditions() {
+		skipped[name] = true
+	}
+	return skipped
+}
+
+// failedPreconditions returns the result of the units with unsatisfied preconditions.
+func (e *RunpfileExecutor) failedPreconditions() map[string]*PreconditionVerifyResult {
+	failed := make(map[string]*PreconditionVerifyResult)
 	for _, unit := range e.rf.Units {
 		if pr := e.unitPreconditions(unit); pr != nil && pr.Vote != Proceed {
-			skipped[unit.Name] = true
+			failed[unit.Name] = pr
 			ui.WriteLinef("Preconditions not satisfied for unit %s (%s): %v", unit.Name, pr.Vote, pr.Reasons)
 		}
 	}
-	return skipped
+	return failed
 }
 
 func (e *RunpfileExecutor) unitPreconditions(unit *RunpUnit) *PreconditionVerifyResult {
+	if pr := unit.verifyRunpfilePreconditions(); pr.Vote != Proceed {
+		return &pr
+	}
 	if unit.Host != nil {
 		pr := unit.Host.VerifyPreconditions()
 		return &pr
 	}
+	if unit.Container != nil && e.dryRun {
+		// runp-network is not created
+		pr := unit.Container.verifyRunner()
+		return &pr
+	}
 	if unit.Container != nil {
 		pr := unit.Container.VerifyPreconditions()
 		return &pr
 	}
+	if unit.SSHTunnel != nil && e.dryRun {
+		// the test command is not run on the remote host
+		pr := unit.SSHTunnel.preconditions.Verify()
+		return &pr
+	}
 	if unit.SSHTunnel != nil {
 		pr := unit.SSHTunnel.VerifyPreconditions()
 		return &pr
 	}
+	if unit.KubeForward != nil {
+		pr := unit.KubeForward.VerifyPreconditions()
+		return &pr
+	}
+	if unit.Proxy != nil {
+		pr := unit.Proxy.VerifyPreconditions()
+		return &pr
+	}
 	return nil
 }
 
@@ -59,6 +59,8 @@ func TestVarsSubstitution(t *testing.T) {
 	if err != nil {
 		t.Errorf("Runppfile %s, load error %v", runpfilePath, err)
 	}
+	// implicit var set by `runp up`, missing vars are errors
+	rp.Vars["runp_root"] = rp.Root
 	sut := &RunpfileExecutor{
 		rf:            rp,
 		LoggerFactory: createStubLogger,
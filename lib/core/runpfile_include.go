@@ -0,0 +1,303 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/enr/go-files/files"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// IncludeSpec is an included Runpfile or override: a path relative to the Runpfile directory or a glob
+// pattern as in `units/*.yml`.
+type IncludeSpec struct {
+	Path string
+	// Optional includes can be missing
+	Optional bool
+}
+
+// UnmarshalYAML accepts the path only or the mapping `{path: Runpfile.override.yml, optional: true}`.
+func (i *IncludeSpec) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*i = IncludeSpec{Path: value.Value}
+		return nil
+	}
+	type plain IncludeSpec
+	if err := decodeNodeStrict(value, (*plain)(i)); err != nil {
+		return err
+	}
+	if i.Path == "" {
+		return errors.Errorf("line %d: include path not specified", value.Line)
+	}
+	return nil
+}
+
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// resolveIncludes returns the paths of the included Runpfiles, expanding glob patterns.
+// Glob patterns never match the including Runpfile, at self.
+func resolveIncludes(root string, self string, specs []IncludeSpec) ([]string, error) {
+	selfPath, err := filepath.Abs(self)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, spec := range specs {
+		p := filepath.Join(root, spec.Path)
+		if !isGlob(spec.Path) {
+			if !files.Exists(p) {
+				if spec.Optional {
+					ui.Debugf("Optional Runpfile not found: %s", filepath.ToSlash(p))
+					continue
+				}
+				return nil, fmt.Errorf("included Runpfile not found: %s", filepath.ToSlash(p))
+			}
+			paths = append(paths, filepath.ToSlash(p))
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid include %s", spec.Path)
+		}
+		found := 0
+		for _, m := range matches {
+			if m == selfPath || !files.IsRegular(m) || sliceContains(paths, filepath.ToSlash(m)) {
+				continue
+			}
+			paths = append(paths, filepath.ToSlash(m))
+			found++
+		}
+		if found == 0 && !spec.Optional {
+			return nil, fmt.Errorf("no Runpfile matching %s", filepath.ToSlash(p))
+		}
+	}
+	return paths, nil
+}
+
+// includedLabel describes an included Runpfile in var sources and errors.
+func includedLabel(path string, name string) string {
+	if name == "" {
+		return path
+	}
+	return fmt.Sprintf("%s (%s)", path, name)
+}
+
+// mergeIncluded adds to rf the vars, the secrets, the ports and the units of the included Runpfile.
+// Units keep the root of their own Runpfile and are skipped if its preconditions are not satisfied.
+func mergeIncluded(rf *Runpfile, rfPath string, included *Runpfile, includedPath string) error {
+	label := includedLabel(includedPath, included.Name)
+	if err := mergeVars(rf, rfPath, included, label); err != nil {
+		return err
+	}
+	if err := mergeSecrets(rf, rfPath, included, label); err != nil {
+		return err
+	}
+	for _, name := range included.Ports {
+		if !sliceContains(rf.Ports, name) {
+			rf.Ports = append(rf.Ports, name)
+		}
+	}
+	for k, v := range included.Units {
+		if _, ok := rf.Units[k]; ok {
+			return fmt.Errorf("duplicate unit identifier: %s", k)
+		}
+		preconditions := included.Preconditions
+		v.runpfilePreconditions = append([]*Preconditions{&preconditions}, v.runpfilePreconditions...)
+		rf.Units[k] = v
+	}
+	return nil
+}
+
+// mergeVars adds the vars of the included Runpfile: a var declared in more Runpfiles must have the same value.
+func mergeVars(rf *Runpfile, rfPath string, included *Runpfile, label string) error {
+	if len(included.Vars) == 0 {
+		return nil
+	}
+	if rf.Vars == nil {
+		rf.Vars = map[string]string{}
+	}
+	if rf.varOrigins == nil {
+		rf.varOrigins = map[string]string{}
+	}
+	for name, value := range included.Vars {
+		origin := label
+		if o, ok := included.varOrigins[name]; ok {
+			origin = o
+		}
+		if existing, ok := rf.Vars[name]; ok {
+			if existing != value {
+				declaredIn := rfPath
+				if o, ok := rf.varOrigins[name]; ok {
+					declaredIn = o
+				}
+				return errors.Errorf("var %s is declared with different values in %s and %s", name, declaredIn, origin)
+			}
+			continue
+		}
+		rf.Vars[name] = value
+		rf.varOrigins[name] = origin
+		if command, ok := included.computedVars[name]; ok {
+			if rf.computedVars == nil {
+				rf.computedVars = map[string]string{}
+			}
+			rf.computedVars[name] = command
+		}
+	}
+	return nil
+}
+
+// mergeSecrets adds the secrets of the included Runpfile, their files are resolved from the directory of
+// the Runpfile declaring them. A secret declared in more Runpfiles must have the same settings.
+func mergeSecrets(rf *Runpfile, rfPath string, included *Runpfile, label string) error {
+	if len(included.Secrets) == 0 {
+		return nil
+	}
+	if rf.Secrets == nil {
+		rf.Secrets = map[string]SecretSpec{}
+	}
+	if rf.secretOrigins == nil {
+		rf.secretOrigins = map[string]string{}
+		rf.secretRoots = map[string]string{}
+	}
+	for name, spec := range included.Secrets {
+		origin := label
+		if o, ok := included.secretOrigins[name]; ok {
+			origin = o
+		}
+		root := included.secretRoot(name)
+		if existing, ok := rf.Secrets[name]; ok {
+			if existing != spec || (spec.File != "" && rf.secretRoot(name) != root) {
+				declaredIn := rfPath
+				if o, ok := rf.secretOrigins[name]; ok {
+					declaredIn = o
+				}
+				return errors.Errorf("secret %s is declared with different settings in %s and %s", name, declaredIn, origin)
+			}
+			continue
+		}
+		rf.Secrets[name] = spec
+		rf.secretOrigins[name] = origin
+		rf.secretRoots[name] = root
+	}
+	return nil
+}
+
+// secretRoot returns the directory of the Runpfile declaring the secret.
+func (rf *Runpfile) secretRoot(name string) string {
+	if root, ok := rf.secretRoots[name]; ok {
+		return root
+	}
+	return rf.Root
+}
+
+// verifyRunpfilePreconditions verifies the preconditions of the Runpfiles including the unit.
+func (u *RunpUnit) verifyRunpfilePreconditions() PreconditionVerifyResult {
+	res := PreconditionVerifyResult{Vote: Proceed, Reasons: []string{}}
+	for _, p := range u.runpfilePreconditions {
+		vr := p.Verify()
+		if vr.Vote != Proceed {
+			res.Vote = vr.Vote
+			res.Reasons = append(res.Reasons, vr.Reasons...)
+		}
+	}
+	return res
+}
+
+// unitVars returns the vars of the unit: runp_root is the directory of the Runpfile declaring the unit.
+func (u *RunpUnit) unitVars(vars map[string]string) map[string]string {
+	if _, ok := vars[`runp_root`]; !ok || u.root == "" || vars[`runp_root`] == u.root {
+		return vars
+	}
+	uv := make(map[string]string, len(vars))
+	for k, v := range vars {
+		uv[k] = v
+	}
+	uv[`runp_root`] = u.root
+	return uv
+}
+
+// runpfileOverride is the content of an override file.
+type runpfileOverride struct {
+	Units map[string]yaml.Node
+}
+
+// applyOverrides patches the units of rf using the override file at path: mappings are merged,
+// other values and lists are replaced.
+func applyOverrides(rf *Runpfile, path string, key string) error {
+	ui.Debugf("Applying overrides from %s", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	doc, err := parseRunpfileData(data, key)
+	if err != nil {
+		return errors.Wrapf(err, "invalid override %s", path)
+	}
+	override := runpfileOverride{}
+	if len(doc.Content) > 0 {
+		if err := decodeNodeStrict(doc.Content[0], &override); err != nil {
+			return errors.Wrapf(err, "invalid override %s", path)
+		}
+	}
+	ids := make([]string, 0, len(override.Units))
+	for id := range override.Units {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		unit, ok := rf.Units[id]
+		if !ok {
+			return errors.Errorf("override %s: unit %s not found", path, id)
+		}
+		if unit.node == nil {
+			return errors.Errorf("override %s: unit %s cannot be overridden", path, id)
+		}
+		patch := override.Units[id]
+		node := mergeNodes(unit.node, &patch)
+		overridden := &RunpUnit{}
+		if err := decodeNodeStrict(node, overridden); err != nil {
+			return errors.Wrapf(err, "override %s: unit %s", path, id)
+		}
+		overridden.node = node
+		overridden.vars = unit.vars
+		overridden.root = unit.root
+		overridden.file = unit.file
+		overridden.globalEnv = unit.globalEnv
+		overridden.runpfilePreconditions = unit.runpfilePreconditions
+		if err := setupUnit(id, overridden); err != nil {
+			return errors.Wrapf(err, "override %s", path)
+		}
+		rf.Units[id] = overridden
+	}
+	return nil
+}
+
+// mergeNodes returns base with the keys of override: mappings are merged recursively, other nodes are replaced.
+func mergeNodes(base *yaml.Node, override *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		return override
+	}
+	merged := &yaml.Node{Kind: base.Kind, Tag: base.Tag, Line: base.Line, Column: base.Column}
+	merged.Content = append(merged.Content, base.Content...)
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		k, v := override.Content[i], override.Content[i+1]
+		found := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value == k.Value {
+				merged.Content[j+1] = mergeNodes(merged.Content[j+1], v)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Content = append(merged.Content, k, v)
+		}
+	}
+	return merged
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -96,3 +98,159 @@ func TestIncludeKo(t *testing.T) {
 	}
 
 }
+
+func TestIncludeVars(t *testing.T) {
+	setupTestUI(t)
+	rp, err := LoadRunpfileFromPath("../../testdata/runpfiles/include-vars/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	assertMapEquals(rp.Vars, map[string]string{"app_port": "8080", "db_host": "localhost", "db_port": "5432"}, "vars", t)
+	vars := NewVars(rp)
+	if vars.Sources["app_port"] != VarSourceRunpfile {
+		t.Errorf("Expected source of app_port <%s>, got <%s>", VarSourceRunpfile, vars.Sources["app_port"])
+	}
+	if !strings.HasPrefix(vars.Sources["db_port"], VarSourceRunpfile+" ") || !strings.HasSuffix(vars.Sources["db_port"], "db/Runpfile.yml (Database)") {
+		t.Errorf("Expected source of db_port the included Runpfile, got <%s>", vars.Sources["db_port"])
+	}
+
+	_, err = LoadRunpfileFromPath("../../testdata/runpfiles/include-vars/conflict.yml")
+	if err == nil || !strings.Contains(err.Error(), "var db_host is declared with different values") {
+		t.Errorf("Expected error for conflicting var, got %v", err)
+	}
+}
+
+func TestIncludeSecrets(t *testing.T) {
+	setupTestUI(t)
+	restoreRedacted(t)
+	t.Setenv("RUNP_INCLUDE_TEST_TOKEN", "include-token")
+	dir := t.TempDir()
+	vault, err := EncryptToBase64([]byte("db_password: vault-secret\n"), "thekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"Runpfile.yml": `secrets:
+  api_token: {provider: env, name: RUNP_INCLUDE_TEST_TOKEN}
+include:
+  - db/Runpfile.yml
+units:
+  api:
+    host:
+      command: ./api
+      env:
+        API_TOKEN: "{{secret api_token}}"
+`,
+		"db/Runpfile.yml": `secrets:
+  api_token: {provider: env, name: RUNP_INCLUDE_TEST_TOKEN}
+  db_password: {provider: vault, file: secrets.vault}
+units:
+  db:
+    host:
+      command: ./db
+      env:
+        DB_PASSWORD: "{{secret db_password}}"
+`,
+		"db/secrets.vault": vault,
+		"conflict.yml": `secrets:
+  api_token: {provider: env, name: OTHER_TOKEN}
+include:
+  - db/Runpfile.yml
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf, err := LoadRunpfileFromPath(filepath.Join(dir, "Runpfile.yml"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.SecretKey = "thekey"
+	rf.Vars = map[string]string{}
+	if errs := Validate(rf); len(errs) > 0 {
+		t.Errorf("Expected secrets of the included Runpfile declared, got %v", errs)
+	}
+	store := newSecretStore(rf)
+	for name, expected := range map[string]string{"api_token": "include-token", "db_password": "vault-secret"} {
+		if actual, err := store.get(name); err != nil || actual != expected {
+			t.Errorf("Secret %s, expected <%s> got <%s> %v", name, expected, actual, err)
+		}
+	}
+
+	_, err = LoadRunpfileFromPath(filepath.Join(dir, "conflict.yml"))
+	if err == nil || !strings.Contains(err.Error(), "secret api_token is declared with different settings") {
+		t.Errorf("Expected error for conflicting secret, got %v", err)
+	}
+}
+
+func TestIncludeUnitsRootAndPreconditions(t *testing.T) {
+	setupTestUI(t)
+	rp, err := LoadRunpfileFromPath("../../testdata/runpfiles/include-vars/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rp.Vars["runp_root"] = rp.Root
+	executor := NewExecutor(rp)
+	executor.initializeUnits()
+	expectedRoots := map[string]string{
+		"app":        rp.Root,
+		"db":         filepath.Join(rp.Root, "db"),
+		"plan9-only": filepath.Join(rp.Root, "plan9"),
+	}
+	for name, root := range expectedRoots {
+		if actual := rp.Units[name].vars["runp_root"]; actual != root {
+			t.Errorf("Unit %s, expected runp_root <%s>, got <%s>", name, root, actual)
+		}
+	}
+	if rp.Vars["runp_root"] != rp.Root {
+		t.Errorf("Expected Runpfile vars unchanged, got runp_root <%s>", rp.Vars["runp_root"])
+	}
+	skipped := executor.skippedUnits()
+	if len(skipped) != 1 || !skipped["plan9-only"] {
+		t.Errorf("Expected only plan9-only skipped by the preconditions of its Runpfile, got %v", skipped)
+	}
+}
+
+func TestIncludeGlobAndOverrides(t *testing.T) {
+	setupTestUI(t)
+	rp, err := LoadRunpfileFromPath("../../testdata/runpfiles/include-glob/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(rp.Units) != 3 || rp.Units["app"] == nil || rp.Units["api"] == nil || rp.Units["worker"] == nil {
+		t.Fatalf("Expected units app, api and worker, got %v", rp.Units)
+	}
+	api := rp.Units["api"]
+	if api.Host.CommandLine != "./api --port 9090" {
+		t.Errorf("Expected command overridden, got <%s>", api.Host.CommandLine)
+	}
+	assertMapEquals(api.Host.Env, map[string]string{"LOG_LEVEL": "debug", "REGION": "eu"}, "api env", t)
+	if expected := filepath.Join(rp.Root, "units", "api"); api.Process().Dir() != expected {
+		t.Errorf("Expected workdir resolved from the included Runpfile <%s>, got <%s>", expected, api.Process().Dir())
+	}
+	assertSliceEquals(rp.Units["worker"].Container.Ports, []string{"9001:9000"}, "worker ports", t)
+	if rp.Units["worker"].Container.Image != "example/worker" {
+		t.Errorf("Expected image kept, got <%s>", rp.Units["worker"].Container.Image)
+	}
+}
+
+func TestIncludeGlobAndOverridesErrors(t *testing.T) {
+	setupTestUI(t)
+	testCases := map[string]string{
+		"unknown-unit.yml":  "unit db not found",
+		"unknown-field.yml": "field commandline not found",
+		"missing.yml":       "no Runpfile matching",
+	}
+	for file, expected := range testCases {
+		_, err := LoadRunpfileFromPath("../../testdata/runpfiles/include-glob/" + file)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing <%s>, got <%v>", file, expected, err)
+		}
+	}
+}
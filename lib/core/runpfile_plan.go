@@ -0,0 +1,151 @@
+package core
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExecutionPlan is what `runp up` would do: the units started, in order, and the units skipped.
+type ExecutionPlan struct {
+	Units []PlannedUnit
+}
+
+// PlannedUnit is a unit of the start plan.
+type PlannedUnit struct {
+	Name string
+	Kind string
+	// Skipped is true if the preconditions of the unit are not satisfied, the reasons are in Reasons
+	Skipped bool
+	Reasons []string
+	// resource awaited before starting the unit
+	AwaitResource string
+	AwaitTimeout  string
+	Dir           string
+	// executable and arguments of host and kube forward units
+	Command []string
+	// command line of container units
+	CommandLine string
+	// environment variables set by runp, the other ones are inherited
+	Env []string
+	// tunnel endpoints and proxy routes
+	Details []string
+}
+
+// Plan returns the start plan without starting any unit: preconditions are verified, but runp-network
+// is not created, the test commands of SSH tunnels are not run and secret providers are not called.
+// Units are started in parallel, so the plan lists first the units started immediately, then the ones
+// awaiting a resource and at last the skipped ones.
+func (e *RunpfileExecutor) Plan() (*ExecutionPlan, error) {
+	e.dryRun = true
+	e.initializeUnits()
+	defer setActiveSecrets(nil)
+	failed := e.failedPreconditions()
+	skipped := map[string]bool{}
+	for name := range failed {
+		skipped[name] = true
+	}
+	if err := e.checkTemplates(skipped); err != nil {
+		return nil, err
+	}
+	plan := &ExecutionPlan{}
+	for _, unit := range e.rf.Units {
+		if pr, ok := failed[unit.Name]; ok {
+			plan.Units = append(plan.Units, PlannedUnit{Name: unit.Name, Kind: strings.Join(unit.processKinds(), ","), Skipped: true, Reasons: pr.Reasons})
+			continue
+		}
+		pu, err := planUnit(unit)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unit %s", unit.Name)
+		}
+		plan.Units = append(plan.Units, pu)
+	}
+	sort.Slice(plan.Units, func(i, j int) bool {
+		a, b := plan.Units[i], plan.Units[j]
+		if a.step() != b.step() {
+			return a.step() < b.step()
+		}
+		return a.Name < b.Name
+	})
+	return plan, nil
+}
+
+// step returns the position of the unit in the plan: started immediately, awaiting a resource, skipped.
+func (u PlannedUnit) step() int {
+	switch {
+	case u.Skipped:
+		return 2
+	case u.AwaitTimeout != "":
+		return 1
+	}
+	return 0
+}
+
+func planUnit(unit *RunpUnit) (PlannedUnit, error) {
+	process := unit.Process()
+	pu := PlannedUnit{Name: unit.Name, Kind: strings.Join(unit.processKinds(), ","), Dir: process.Dir()}
+	if process.ShouldWait() {
+		if _, err := time.ParseDuration(process.AwaitTimeout()); err != nil {
+			return pu, errors.Wrapf(err, "invalid await timeout %s", process.AwaitTimeout())
+		}
+		pu.AwaitResource = process.AwaitResource()
+		pu.AwaitTimeout = process.AwaitTimeout()
+	}
+	switch {
+	case unit.Host != nil:
+		cmd, err := unit.Host.StartCommand()
+		if err != nil {
+			return pu, err
+		}
+		c := cmd.(*ExecCommandWrapper).cmd
+		pu.Command = append([]string{c.Path}, c.Args[1:]...)
+		pu.Env = runpEnv(c.Env)
+	case unit.Container != nil:
+		cl, _, err := unit.Container.buildCmdLine()
+		if err != nil {
+			return pu, err
+		}
+		pu.CommandLine = cl
+	case unit.SSHTunnel != nil:
+		pu.Details = []string{unit.SSHTunnel.ForwardsDescription()}
+		if unit.SSHTunnel.TestCommand != "" {
+			pu.Details = append(pu.Details, "test command not run: "+unit.SSHTunnel.TestCommand)
+		}
+	case unit.KubeForward != nil:
+		if _, err := unit.KubeForward.StartCommand(); err != nil {
+			return pu, err
+		}
+		c := unit.KubeForward.cmd
+		pu.Command = append([]string{c.exe}, c.args...)
+		pu.Env = runpEnv(c.env)
+	case unit.Proxy != nil:
+		if _, err := unit.Proxy.StartCommand(); err != nil {
+			return pu, err
+		}
+		pu.Details = []string{unit.Proxy.RoutesDescription()}
+	}
+	pu.CommandLine = redact(pu.CommandLine)
+	for i, arg := range pu.Command {
+		pu.Command[i] = redact(arg)
+	}
+	return pu, nil
+}
+
+// runpEnv returns the variables of env not inherited unchanged from the environment of runp, redacted.
+func runpEnv(env []string) []string {
+	inherited := map[string]bool{}
+	for _, kv := range os.Environ() {
+		inherited[kv] = true
+	}
+	set := []string{}
+	for _, kv := range env {
+		if !inherited[kv] {
+			set = append(set, redact(kv))
+		}
+	}
+	sort.Strings(set)
+	return set
+}
@@ -0,0 +1,145 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+// +build darwin freebsd linux netbsd openbsd
+
+package core
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestExecutorPlan(t *testing.T) {
+	setupTestUI(t)
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	runner := filepath.Join(dir, "docker")
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\n"
+	if err := os.WriteFile(runner, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RUNP_CONFIG_TEST_TOKEN", "plain-token")
+	rf, err := LoadRunpfileFromPath("../../testdata/runpfiles/config/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.Vars = map[string]string{"api_port": "9090", "runp_root": rf.Root}
+	rf.SecretKey = "thekey"
+	executor := NewExecutor(rf)
+	executor.environmentSettings = &EnvironmentSettings{ContainerRunnerExe: runner}
+	plan, err := executor.Plan()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	names := []string{}
+	for _, u := range plan.Units {
+		names = append(names, u.Name)
+	}
+	// units awaiting a resource are listed after the ones started immediately
+	if strings.Join(names, " ") != "db tool api" {
+		t.Fatalf("Expected units db tool api, got %v", names)
+	}
+
+	db, tool, api := plan.Units[0], plan.Units[1], plan.Units[2]
+	if db.Skipped || !strings.HasPrefix(db.CommandLine, runner+" run ") || !strings.Contains(db.CommandLine, "docker.io/postgres:16") ||
+		strings.Contains(db.CommandLine, "pg-secret") {
+		t.Errorf("Unit db, unexpected plan %+v", db)
+	}
+	if !strings.HasSuffix(tool.Command[0], "echo") || strings.Join(tool.Command[1:], " ") != rf.Root {
+		t.Errorf("Unit tool, unexpected command %q", tool.Command)
+	}
+	if api.AwaitResource != "tcp4://localhost:5432/" || api.AwaitTimeout != "0h0m10s" {
+		t.Errorf("Unit api, unexpected await %s %s", api.AwaitResource, api.AwaitTimeout)
+	}
+	if api.Command[len(api.Command)-1] != "./api --port 9090" || api.Dir != filepath.Join(rf.Root, "api") {
+		t.Errorf("Unit api, unexpected command %q in %s", api.Command, api.Dir)
+	}
+	if strings.Join(api.Env, " ") != "API_TOKEN="+redactedText+" LOG_LEVEL=info" {
+		t.Errorf("Unit api, expected env set by runp with the secret redacted, got %v", api.Env)
+	}
+	if _, err := os.Stat(calls); !os.IsNotExist(err) {
+		data, _ := os.ReadFile(calls)
+		t.Errorf("Expected container runner not called, got calls:\n%s", data)
+	}
+}
+
+func TestExecutorPlanSkippedUnits(t *testing.T) {
+	setupTestUI(t)
+	rf, err := LoadRunpfileFromPath("../../testdata/runpfiles/config/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.Vars = map[string]string{"api_port": "9090", "runp_root": rf.Root}
+	executor := NewExecutor(rf)
+	executor.environmentSettings = &EnvironmentSettings{ContainerRunnerExe: filepath.Join(t.TempDir(), "missing")}
+	plan, err := executor.Plan()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	last := plan.Units[len(plan.Units)-1]
+	if last.Name != "db" || !last.Skipped || len(last.Reasons) == 0 || !strings.Contains(last.Reasons[0], "Container runner executable not found") {
+		t.Errorf("Expected db skipped as last unit, got %+v", last)
+	}
+}
+
+func TestExecutorPlanSSHTestCommand(t *testing.T) {
+	setupTestUI(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var connections int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connections, 1)
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	runpfile := filepath.Join(t.TempDir(), "Runpfile")
+	data := fmt.Sprintf(`units:
+  tunnel:
+    ssh_tunnel:
+      user: runp
+      auth:
+        secret: runp
+      insecure_ignore_host_key: true
+      test_command: touch /tmp/runp-test
+      local:
+        port: 15432
+      jump:
+        host: 127.0.0.1
+        port: %d
+      target:
+        host: db
+        port: 5432
+`, port)
+	if err := os.WriteFile(runpfile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := LoadRunpfileFromPath(runpfile)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.Vars = map[string]string{}
+	plan, err := NewExecutor(rf).Plan()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	tunnel := plan.Units[0]
+	if tunnel.Skipped || !sliceContains(tunnel.Details, "test command not run: touch /tmp/runp-test") {
+		t.Errorf("Expected tunnel planned with the test command not run, got %+v", tunnel)
+	}
+	if n := atomic.LoadInt32(&connections); n != 0 {
+		t.Errorf("Expected no connection to the jump server, got %d", n)
+	}
+}
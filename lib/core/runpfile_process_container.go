@@ -30,7 +30,9 @@ type ContainerProcess struct {
 	// generics
 	WorkingDir string `yaml:"workdir"`
 	Env        map[string]string
-	Await      AwaitCondition
+	// dotenv files with variables added to the environment, overridden by env
+	EnvFile StringList `yaml:"env_file"`
+	Await   AwaitCondition
 
 	id                  string
 	vars                map[string]string
@@ -38,6 +40,9 @@ type ContainerProcess struct {
 	secretKey           string
 	stopTimeout         string
 	environmentSettings *EnvironmentSettings
+	globalEnv           map[string]string
+	// directory of the Runpfile, env files are resolved from it
+	root string
 }
 
 // ID for the sub process
@@ -103,20 +108,27 @@ func (p *ContainerProcess) SetDir(wd string) {
 
 func (p *ContainerProcess) buildContainerName() string {
 	if p.Name != "" {
-		return p.Name
+		return newCliPreprocessor(p.vars).process(p.Name)
 	}
 	return fmt.Sprintf("%s%s", containerNamePrefix, p.ID())
 }
 
-func (p *ContainerProcess) buildCmdLine() (string, error) {
-	img := p.Image
-	ui.Debugf("Run image '%s'\n", img)
+// buildCmdLine returns the command line running the container and the variables to add
+// to the environment of the container runner.
+func (p *ContainerProcess) buildCmdLine() (string, []string, error) {
+	ui.Debugf("Run image '%s'\n", p.Image)
 
 	containerRunner, err := exec.LookPath(p.environmentSettings.ContainerRunnerExe)
 	if err != nil {
 		ui.WriteLinef("Container runner executable not found: %s (%v)", p.environmentSettings.ContainerRunnerExe, err)
-		return "", fmt.Errorf("container runner executable not found: %s (%w)", p.environmentSettings.ContainerRunnerExe, err)
+		return "", nil, fmt.Errorf("container runner executable not found: %s (%w)", p.environmentSettings.ContainerRunnerExe, err)
 	}
+	return p.commandLine(containerRunner)
+}
+
+// commandLine returns the command line running the container using containerRunner and the variables
+// to add to the environment of the container runner. Vars are applied to each setting once.
+func (p *ContainerProcess) commandLine(containerRunner string) (string, []string, error) {
 	cliPreprocessor := newCliPreprocessor(p.vars)
 	var sb strings.Builder
 	// rm Automatically remove the container when it exits
@@ -136,7 +148,7 @@ func (p *ContainerProcess) buildCmdLine() (string, error) {
 	// --shm-size
 	if p.ShmSize != "" {
 		sb.WriteString("--shm-size ")
-		sb.WriteString(p.ShmSize)
+		sb.WriteString(cliPreprocessor.process(p.ShmSize))
 		sb.WriteString(" ")
 	}
 	// --user
@@ -162,43 +174,63 @@ func (p *ContainerProcess) buildCmdLine() (string, error) {
 	// --workdir
 	if p.WorkingDir != "" {
 		sb.WriteString("--workdir ")
-		sb.WriteString(p.WorkingDir)
+		sb.WriteString(cliPreprocessor.process(p.WorkingDir))
 		sb.WriteString(" ")
 	}
 
 	for _, ports := range p.Ports {
 		sb.WriteString("-p ")
-		sb.WriteString(ports)
+		sb.WriteString(cliPreprocessor.process(ports))
 		sb.WriteString(" ")
 	}
+	fileEnv, err := loadEnvFiles(p.EnvFile, p.root, p.vars)
+	if err != nil {
+		return "", nil, err
+	}
+	// encrypted values are passed in the environment of the container runner, not in the command line
+	secretEnv := []string{}
 	// Process env with current vars
-	for name, val := range p.Env {
+	for name, val := range mergeEnv(p.globalEnv, fileEnv, p.Env) {
 		sb.WriteString(`-e "`)
 		sb.WriteString(name)
+		if isEncryptedEnv(val) {
+			plain, err := decryptEnv(name, val, p.secretKey)
+			if err != nil {
+				return "", nil, err
+			}
+			secretEnv = append(secretEnv, name+"="+plain)
+			sb.WriteString(`" `)
+			continue
+		}
 		sb.WriteString("=")
 		processedVal := cliPreprocessor.process(val)
 		sb.WriteString(os.ExpandEnv(processedVal))
 		sb.WriteString(`" `)
 	}
-	sb.WriteString(img)
+	sb.WriteString(cliPreprocessor.process(p.Image))
 	// command
 	if p.Command != "" {
 		sb.WriteString(` `)
-		sb.WriteString(p.Command)
+		sb.WriteString(cliPreprocessor.process(p.Command))
 		sb.WriteString(` `)
 	}
-	return sb.String(), nil
+	return sb.String(), secretEnv, nil
 }
 
 func (p *ContainerProcess) buildCmdImage() (*exec.Cmd, error) {
-	cl, err := p.buildCmdLine()
+	cl, secretEnv, err := p.buildCmdLine()
 	if err != nil {
 		return nil, err
 	}
-	cliPreprocessor := newCliPreprocessor(p.vars)
-	cl = cliPreprocessor.process(cl)
 	ui.Debugf("Container command:\n%s", cl)
-	return cmd(cl)
+	c, err := cmd(cl)
+	if err != nil {
+		return nil, err
+	}
+	if len(secretEnv) > 0 {
+		c.Env = append(os.Environ(), secretEnv...)
+	}
+	return c, nil
 }
 
 // ShouldWait returns if the process has await set.
@@ -206,14 +238,14 @@ func (p *ContainerProcess) ShouldWait() bool {
 	return (p.Await.Timeout != "")
 }
 
-// AwaitResource returns the await resource.
+// AwaitResource returns the await resource, with vars applied.
 func (p *ContainerProcess) AwaitResource() string {
-	return p.Await.Resource
+	return newCliPreprocessor(p.vars).process(p.Await.Resource)
 }
 
-// AwaitTimeout returns the await timeout.
+// AwaitTimeout returns the await timeout, with vars applied.
 func (p *ContainerProcess) AwaitTimeout() string {
-	return p.Await.Timeout
+	return newCliPreprocessor(p.vars).process(p.Await.Timeout)
 }
 
 // String representation of process
@@ -255,12 +287,30 @@ func (p *ContainerProcess) SetPreconditions(preconditions Preconditions) {
 
 // VerifyPreconditions check if process can be started
 func (p *ContainerProcess) VerifyPreconditions() PreconditionVerifyResult {
+	res := p.verifyRunner()
+	if res.Vote != Proceed {
+		return res
+	}
+	return p.ensureNetwork()
+}
 
+// verifyRunner verifies the preconditions of the unit and the container runner executable.
+func (p *ContainerProcess) verifyRunner() PreconditionVerifyResult {
 	res := p.preconditions.Verify()
 	if res.Vote != Proceed {
 		return res
 	}
-	var err error
+	if _, err := exec.LookPath(p.environmentSettings.ContainerRunnerExe); err != nil {
+		return PreconditionVerifyResult{
+			Vote:    Stop,
+			Reasons: []string{fmt.Sprintf("Container runner executable not found: %s (%v)", p.environmentSettings.ContainerRunnerExe, err)},
+		}
+	}
+	return res
+}
+
+// ensureNetwork creates runp-network if it does not exist.
+func (p *ContainerProcess) ensureNetwork() PreconditionVerifyResult {
 	containerRunner, err := exec.LookPath(p.environmentSettings.ContainerRunnerExe)
 	if err != nil {
 		return PreconditionVerifyResult{
@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
@@ -25,15 +26,22 @@ type HostProcess struct {
 	// generic
 	WorkingDir string `yaml:"workdir"`
 	Env        map[string]string
+	// dotenv files with variables added to the environment, overridden by env
+	EnvFile StringList `yaml:"env_file"`
+	// variables of the runp environment passed to the process
+	InheritEnv InheritEnv `yaml:"inherit_env"`
 	Await      AwaitCondition
 
 	id                  string
 	cmd                 *exec.Cmd
 	vars                map[string]string
+	globalEnv           map[string]string
 	preconditions       Preconditions
 	secretKey           string
 	stopTimeout         string
 	environmentSettings *EnvironmentSettings
+	// directory of the Runpfile, env files are resolved from it
+	root string
 }
 
 // ID for the sub process
@@ -133,7 +141,10 @@ func (p *HostProcess) buildCmdExecutable() (*exec.Cmd, error) {
 	ui.Debugf("Resolved executable path: %s", exe)
 	cliPreprocessor := newCliPreprocessor(p.vars)
 	cmd := exec.Command(exe, cliPreprocessor.processArgs(p.Args)...)
-	cmd.Env = p.resolveEnvironment()
+	cmd.Env, err = p.buildEnvironment()
+	if err != nil {
+		return nil, err
+	}
 	return cmd, nil
 }
 
@@ -147,7 +158,10 @@ func (p *HostProcess) buildCmdCommandline() (*exec.Cmd, error) {
 	ui.Debugf("Process %s will be started using shell: %s %q", p.ID(), exe, args)
 	cliPreprocessor := newCliPreprocessor(p.vars)
 	cmd := exec.Command(exe, cliPreprocessor.processArgs(args)...)
-	cmd.Env = p.resolveEnvironment()
+	cmd.Env, err = p.buildEnvironment()
+	if err != nil {
+		return nil, err
+	}
 	return cmd, nil
 }
 
@@ -160,16 +174,23 @@ func (p *HostProcess) resolveShell() (string, []string, error) {
 	return shell.Path, append(shell.Args, shellCommand), nil
 }
 
-func (p *HostProcess) resolveEnvironment() []string {
-	environment := []string{}
-	// Process env with current vars
-	cliPreprocessor := newCliPreprocessor(p.vars)
-	processedEnv := map[string]string{}
-	for k, v := range p.Env {
-		processedEnv[k] = cliPreprocessor.process(v)
+// buildEnvironment returns the process environment: the inherited variables followed by the resolved ones.
+func (p *HostProcess) buildEnvironment() ([]string, error) {
+	resolved, err := p.resolveEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	// duplicated keys are allowed, the last value is used
+	return append(p.InheritEnv.environ(os.Environ()), resolved...), nil
+}
+
+// resolveEnvironment returns the Runpfile env overridden by the env files and by the unit env.
+func (p *HostProcess) resolveEnvironment() ([]string, error) {
+	fileEnv, err := loadEnvFiles(p.EnvFile, p.root, p.vars)
+	if err != nil {
+		return nil, err
 	}
-	environment = append(environment, envAsArray(processedEnv)...)
-	return environment
+	return processEnv(mergeEnv(p.globalEnv, fileEnv, p.Env), p.vars, p.secretKey)
 }
 
 func (p *HostProcess) resolveWorkingDir() string {
@@ -185,14 +206,14 @@ func (p *HostProcess) ShouldWait() bool {
 	return (p.Await.Timeout != "")
 }
 
-// AwaitResource returns the await resource.
+// AwaitResource returns the await resource, with vars applied.
 func (p *HostProcess) AwaitResource() string {
-	return p.Await.Resource
+	return newCliPreprocessor(p.vars).process(p.Await.Resource)
 }
 
-// AwaitTimeout returns the await timeout.
+// AwaitTimeout returns the await timeout, with vars applied.
 func (p *HostProcess) AwaitTimeout() string {
-	return p.Await.Timeout
+	return newCliPreprocessor(p.vars).process(p.Await.Timeout)
 }
 
 // IsStartable always true.
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KubeForwardProcess implements RunpProcess forwarding local ports to a Kubernetes resource
+// using `kubectl port-forward`. The forward is restarted every time kubectl exits.
+type KubeForwardProcess struct {
+	// kubeconfig context, the current one if empty
+	Context   string
+	Namespace string
+	// resource in kubectl format: svc/name, pod/name, deployment/name
+	Resource string
+	// ports in kubectl format: "8080:80"
+	Ports []string
+	// local address to bind, kubectl defaults to localhost
+	Address string
+
+	// generics
+	WorkingDir string `yaml:"workdir"`
+	Env        map[string]string
+	Await      AwaitCondition
+
+	id                  string
+	vars                map[string]string
+	preconditions       Preconditions
+	secretKey           string
+	stopTimeout         string
+	environmentSettings *EnvironmentSettings
+	globalEnv           map[string]string
+	cmd                 *KubeForwardCommandWrapper
+}
+
+// ID for the sub process
+func (p *KubeForwardProcess) ID() string {
+	return p.id
+}
+
+// SetID for the sub process
+func (p *KubeForwardProcess) SetID(id string) {
+	p.id = id
+}
+
+// SetPreconditions set preconditions.
+func (p *KubeForwardProcess) SetPreconditions(preconditions Preconditions) {
+	p.preconditions = preconditions
+}
+
+// VerifyPreconditions check if process can be started
+func (p *KubeForwardProcess) VerifyPreconditions() PreconditionVerifyResult {
+	res := p.preconditions.Verify()
+	if res.Vote != Proceed {
+		return res
+	}
+	if _, err := exec.LookPath(p.kubectl()); err != nil {
+		return PreconditionVerifyResult{
+			Vote:    Stop,
+			Reasons: []string{fmt.Sprintf("kubectl executable not found: %s (%v)", p.kubectl(), err)},
+		}
+	}
+	return PreconditionVerifyResult{
+		Vote:    Proceed,
+		Reasons: []string{},
+	}
+}
+
+// StopTimeout duration to wait to force kill process
+func (p *KubeForwardProcess) StopTimeout() time.Duration {
+	if p.stopTimeout != "" {
+		d, err := time.ParseDuration(p.stopTimeout)
+		if err != nil {
+			return time.Duration(5) * time.Second
+		}
+		return d
+	}
+	return time.Duration(5) * time.Second
+}
+
+// StartCommand returns the command starting the process.
+func (p *KubeForwardProcess) StartCommand() (RunpCommand, error) {
+	if p.Resource == "" {
+		return nil, errors.New("Kubernetes forward misconfiguration: resource not specified")
+	}
+	if len(p.Ports) == 0 {
+		return nil, errors.Errorf("Kubernetes forward misconfiguration: no ports specified for %s", p.Resource)
+	}
+	exe, err := exec.LookPath(p.kubectl())
+	if err != nil {
+		ui.WriteLinef("kubectl executable not found: %s (%v)", p.kubectl(), err)
+		return nil, err
+	}
+	env, err := p.resolveEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	args := p.buildArgs()
+	ui.Debugf("Kubernetes forward command: %s %s", exe, strings.Join(args, " "))
+	p.cmd = &KubeForwardCommandWrapper{
+		id:          p.id,
+		exe:         exe,
+		args:        args,
+		env:         env,
+		dir:         p.WorkingDir,
+		stopTimeout: p.StopTimeout(),
+		minBackoff:  kubeForwardMinBackoff,
+		maxBackoff:  kubeForwardMaxBackoff,
+		done:        make(chan struct{}),
+	}
+	return p.cmd, nil
+}
+
+// StopCommand returns the command stopping the process.
+func (p *KubeForwardProcess) StopCommand() (RunpCommand, error) {
+	if p.cmd == nil {
+		return nil, errors.New("Kubernetes forward command not initialized")
+	}
+	return &KubeForwardCommandStopper{
+		cmd: p.cmd,
+	}, nil
+}
+
+// Dir for the sub process
+func (p *KubeForwardProcess) Dir() string {
+	return p.WorkingDir
+}
+
+// SetDir for the sub process
+func (p *KubeForwardProcess) SetDir(wd string) {
+	p.WorkingDir = wd
+}
+
+// String representation of process
+func (p *KubeForwardProcess) String() string {
+	return fmt.Sprintf("%T{id=%s resource=%s}", p, p.ID(), p.Resource)
+}
+
+// ShouldWait returns if the process has await set.
+func (p *KubeForwardProcess) ShouldWait() bool {
+	return (p.Await.Timeout != "")
+}
+
+// AwaitResource returns the await resource, with vars applied.
+func (p *KubeForwardProcess) AwaitResource() string {
+	return newCliPreprocessor(p.vars).process(p.Await.Resource)
+}
+
+// AwaitTimeout returns the await timeout, with vars applied.
+func (p *KubeForwardProcess) AwaitTimeout() string {
+	return newCliPreprocessor(p.vars).process(p.Await.Timeout)
+}
+
+// IsStartable always true.
+func (p *KubeForwardProcess) IsStartable() (bool, error) {
+	return true, nil
+}
+
+// PortsDescription returns the ports in the form "8080->80".
+func (p *KubeForwardProcess) PortsDescription() string {
+	ports := make([]string, 0, len(p.Ports))
+	for _, port := range p.Ports {
+		ports = append(ports, strings.Replace(port, ":", "->", 1))
+	}
+	return strings.Join(ports, " ")
+}
+
+func (p *KubeForwardProcess) kubectl() string {
+	if p.environmentSettings != nil && p.environmentSettings.KubectlExe != "" {
+		return p.environmentSettings.KubectlExe
+	}
+	return "kubectl"
+}
+
+func (p *KubeForwardProcess) buildArgs() []string {
+	cliPreprocessor := newCliPreprocessor(p.vars)
+	args := []string{}
+	if p.Context != "" {
+		args = append(args, "--context", cliPreprocessor.process(p.Context))
+	}
+	if p.Namespace != "" {
+		args = append(args, "--namespace", cliPreprocessor.process(p.Namespace))
+	}
+	args = append(args, "port-forward")
+	if p.Address != "" {
+		args = append(args, "--address", cliPreprocessor.process(p.Address))
+	}
+	args = append(args, cliPreprocessor.process(p.Resource))
+	return append(args, cliPreprocessor.processArgs(p.Ports)...)
+}
+
+// resolveEnvironment returns the environment for kubectl: the runp environment (kubectl needs
+// HOME and KUBECONFIG) plus the Runpfile env and the unit env.
+func (p *KubeForwardProcess) resolveEnvironment() ([]string, error) {
+	env, err := processEnv(mergeEnv(p.globalEnv, p.Env), p.vars, p.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(os.Environ(), env...), nil
+}
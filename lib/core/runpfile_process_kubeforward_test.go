@@ -0,0 +1,118 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeKubectl writes a kubectl script appending its arguments to a log file and exiting,
+// so every run simulates a dropped port-forward.
+func fakeKubectl(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "kubectl.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + logFile + "\necho forwarding\n"
+	if err := os.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return logFile
+}
+
+func TestKubeForwardUnitKind(t *testing.T) {
+	unit := &RunpUnit{
+		KubeForward: &KubeForwardProcess{
+			Resource: "svc/x",
+			Ports:    []string{"8080:80", "9090:90"},
+		},
+	}
+	expected := "kube forward svc/x 8080->80 9090->90"
+	if unit.Kind() != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, unit.Kind())
+	}
+}
+
+func TestKubeForwardStartCommandMisconfiguration(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	for _, p := range []*KubeForwardProcess{
+		{Ports: []string{"8080:80"}},
+		{Resource: "svc/x"},
+	} {
+		if _, err := p.StartCommand(); err == nil {
+			t.Errorf("Expected misconfiguration error for %+v", p)
+		}
+	}
+}
+
+func TestKubeForwardReconnect(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	logFile := fakeKubectl(t)
+
+	p := &KubeForwardProcess{
+		Context:   "dev",
+		Namespace: "{{vars ns}}",
+		Resource:  "svc/x",
+		Ports:     []string{"8080:80"},
+		vars:      map[string]string{"ns": "shop"},
+	}
+	p.SetID("kube")
+	cmd, err := p.StartCommand()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	p.cmd.minBackoff = 10 * time.Millisecond
+	p.cmd.maxBackoff = 20 * time.Millisecond
+
+	out := &stubLogger{}
+	cmd.Stdout(out)
+	cmd.Stderr(out)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(logFile)
+		if strings.Count(string(data), "\n") >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("kubectl not restarted, runs:\n%s", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopper, err := p.StopCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stopper.Start(); err != nil {
+		t.Errorf("Unexpected stop error %v", err)
+	}
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Expected nil from Wait after stop, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after stop")
+	}
+
+	data, _ := os.ReadFile(logFile)
+	first := strings.Split(string(data), "\n")[0]
+	if first != "--context dev --namespace shop port-forward svc/x 8080:80" {
+		t.Errorf("Unexpected kubectl arguments '%s'", first)
+	}
+	if !strings.Contains(strings.Join(out.outputLines(), ""), "reconnecting in") {
+		t.Errorf("Expected reconnect events in unit log, got %v", out.outputLines())
+	}
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ProxyRoute routes the HTTP requests having the path prefix to a target URL or to another unit.
+type ProxyRoute struct {
+	Path string
+	// target URL, ie http://localhost:8081
+	Target string
+	// unit reached at its local address, used if target is empty
+	Unit string
+	// HostHeader rewrites the Host header sent to the target
+	HostHeader string `yaml:"host_header"`
+	// StripPrefix removes the path prefix from the forwarded request
+	StripPrefix bool `yaml:"strip_prefix"`
+}
+
+// ProxyProcess implements RunpProcess running a local proxy inside runp.
+// Without routes it is a TCP passthrough from listen to target, with routes it is an HTTP reverse proxy.
+type ProxyProcess struct {
+	Listen Endpoint
+	// TCP target
+	Target Endpoint
+	// HTTP routes
+	Routes []ProxyRoute
+	// delay added to every connection or request, ie "200ms"
+	Latency string
+
+	// generics
+	WorkingDir string `yaml:"workdir"`
+	Env        map[string]string
+	Await      AwaitCondition
+
+	id                  string
+	vars                map[string]string
+	preconditions       Preconditions
+	secretKey           string
+	stopTimeout         string
+	environmentSettings *EnvironmentSettings
+	// units of the Runpfile, used to resolve route units
+	units map[string]*RunpUnit
+	cmd   *ProxyCommandWrapper
+}
+
+// ID for the sub process
+func (p *ProxyProcess) ID() string {
+	return p.id
+}
+
+// SetID for the sub process
+func (p *ProxyProcess) SetID(id string) {
+	p.id = id
+}
+
+// SetPreconditions set preconditions.
+func (p *ProxyProcess) SetPreconditions(preconditions Preconditions) {
+	p.preconditions = preconditions
+}
+
+// VerifyPreconditions check if process can be started
+func (p *ProxyProcess) VerifyPreconditions() PreconditionVerifyResult {
+	return p.preconditions.Verify()
+}
+
+// StopTimeout duration to wait to force kill process
+func (p *ProxyProcess) StopTimeout() time.Duration {
+	if p.stopTimeout != "" {
+		d, err := time.ParseDuration(p.stopTimeout)
+		if err != nil {
+			return time.Duration(5) * time.Second
+		}
+		return d
+	}
+	return time.Duration(5) * time.Second
+}
+
+// StartCommand returns the command starting the process.
+func (p *ProxyProcess) StartCommand() (RunpCommand, error) {
+	if p.Listen.Port == 0 {
+		return nil, errors.Errorf("Proxy misconfiguration: listen port not specified (%s)", p.Listen.String())
+	}
+	latency, err := p.latency()
+	if err != nil {
+		return nil, err
+	}
+	cmd := &ProxyCommandWrapper{
+		listen:  p.Listen.String(),
+		latency: latency,
+	}
+	if len(p.Routes) == 0 {
+		if p.Target.Port == 0 {
+			return nil, errors.Errorf("Proxy misconfiguration: target port not specified (%s)", p.Target.String())
+		}
+		cmd.target = p.Target.String()
+	} else {
+		if p.Target.Port != 0 {
+			return nil, errors.New("Proxy misconfiguration: both target and routes specified")
+		}
+		cmd.routes, err = p.resolveRoutes()
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.cmd = cmd
+	return p.cmd, nil
+}
+
+func (p *ProxyProcess) latency() (time.Duration, error) {
+	if p.Latency == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(newCliPreprocessor(p.vars).process(p.Latency))
+	if err != nil {
+		return 0, errors.Wrapf(err, "Invalid latency %s", p.Latency)
+	}
+	return d, nil
+}
+
+func (p *ProxyProcess) resolveRoutes() ([]proxyRoute, error) {
+	cliPreprocessor := newCliPreprocessor(p.vars)
+	routes := []proxyRoute{}
+	for i, r := range p.Routes {
+		target := cliPreprocessor.process(r.Target)
+		if target == "" && r.Unit != "" {
+			address, err := p.unitAddress(r.Unit)
+			if err != nil {
+				return nil, errors.Wrapf(err, "route %d", i+1)
+			}
+			target = "http://" + address
+		}
+		if target == "" {
+			return nil, errors.Errorf("Proxy misconfiguration: target or unit not specified in route %d", i+1)
+		}
+		u, err := url.Parse(target)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, errors.Errorf("Proxy misconfiguration: invalid target %s in route %d", target, i+1)
+		}
+		path := cliPreprocessor.process(r.Path)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		routes = append(routes, proxyRoute{
+			path:        path,
+			target:      u,
+			hostHeader:  cliPreprocessor.process(r.HostHeader),
+			stripPrefix: r.StripPrefix,
+		})
+	}
+	return routes, nil
+}
+
+func (p *ProxyProcess) unitAddress(name string) (string, error) {
+	unit, found := p.units[name]
+	if !found {
+		return "", errors.Errorf("unit %s not found", name)
+	}
+	address, found := unit.localAddress()
+	if !found {
+		return "", errors.Errorf("local address of unit %s unknown, set target", name)
+	}
+	return address, nil
+}
+
+// StopCommand returns the command stopping the process.
+func (p *ProxyProcess) StopCommand() (RunpCommand, error) {
+	if p.cmd == nil {
+		return nil, errors.New("Proxy command not initialized")
+	}
+	return &ProxyCommandStopper{
+		cmd: p.cmd,
+	}, nil
+}
+
+// Dir for the sub process
+func (p *ProxyProcess) Dir() string {
+	return p.WorkingDir
+}
+
+// SetDir for the sub process
+func (p *ProxyProcess) SetDir(wd string) {
+	p.WorkingDir = wd
+}
+
+// String representation of process
+func (p *ProxyProcess) String() string {
+	return fmt.Sprintf("%T{id=%s listen=%s}", p, p.ID(), p.Listen.String())
+}
+
+// ShouldWait returns if the process has await set.
+func (p *ProxyProcess) ShouldWait() bool {
+	return (p.Await.Timeout != "")
+}
+
+// AwaitResource returns the await resource, with vars applied.
+func (p *ProxyProcess) AwaitResource() string {
+	return newCliPreprocessor(p.vars).process(p.Await.Resource)
+}
+
+// AwaitTimeout returns the await timeout, with vars applied.
+func (p *ProxyProcess) AwaitTimeout() string {
+	return newCliPreprocessor(p.vars).process(p.Await.Timeout)
+}
+
+// IsStartable always true.
+func (p *ProxyProcess) IsStartable() (bool, error) {
+	return true, nil
+}
+
+// RoutesDescription describes the proxy in `runp ls`: "tcp localhost:8080 -> localhost:5432"
+// or "http localhost:8080 /api -> unit api, / -> http://localhost:3000".
+func (p *ProxyProcess) RoutesDescription() string {
+	if len(p.Routes) == 0 {
+		return fmt.Sprintf("tcp %s -> %s", p.Listen.String(), p.Target.String())
+	}
+	routes := []string{}
+	for _, r := range p.Routes {
+		target := r.Target
+		if target == "" {
+			target = "unit " + r.Unit
+		}
+		routes = append(routes, fmt.Sprintf("%s -> %s", r.Path, target))
+	}
+	return fmt.Sprintf("http %s %s", p.Listen.String(), strings.Join(routes, ", "))
+}
@@ -0,0 +1,222 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyUnitKind(t *testing.T) {
+	testCases := []struct {
+		proxy    *ProxyProcess
+		expected string
+	}{
+		{
+			&ProxyProcess{Listen: Endpoint{Port: 8080}, Target: Endpoint{Port: 5432}},
+			"Proxy tcp localhost:8080 -> localhost:5432",
+		},
+		{
+			&ProxyProcess{Listen: Endpoint{Port: 8080}, Routes: []ProxyRoute{
+				{Path: "/api", Unit: "api"},
+				{Path: "/", Target: "http://localhost:3000"},
+			}},
+			"Proxy http localhost:8080 /api -> unit api, / -> http://localhost:3000",
+		},
+	}
+	for _, tc := range testCases {
+		unit := &RunpUnit{Proxy: tc.proxy}
+		if unit.Kind() != tc.expected {
+			t.Errorf("Expected '%s', got '%s'", tc.expected, unit.Kind())
+		}
+	}
+}
+
+func TestProxyStartCommandMisconfiguration(t *testing.T) {
+	testCases := []struct {
+		name     string
+		proxy    *ProxyProcess
+		expected string
+	}{
+		{"no listen port", &ProxyProcess{Target: Endpoint{Port: 80}}, `listen port not specified`},
+		{"no target", &ProxyProcess{Listen: Endpoint{Port: 8080}}, `target port not specified`},
+		{"target and routes", &ProxyProcess{Listen: Endpoint{Port: 8080}, Target: Endpoint{Port: 80}, Routes: []ProxyRoute{{Path: "/", Target: "http://x"}}}, `both target and routes`},
+		{"invalid latency", &ProxyProcess{Listen: Endpoint{Port: 8080}, Target: Endpoint{Port: 80}, Latency: "slow"}, `Invalid latency`},
+		{"route without target", &ProxyProcess{Listen: Endpoint{Port: 8080}, Routes: []ProxyRoute{{Path: "/"}}}, `target or unit not specified`},
+		{"unknown unit", &ProxyProcess{Listen: Endpoint{Port: 8080}, Routes: []ProxyRoute{{Path: "/", Unit: "x"}}}, `unit x not found`},
+		{"unit without address", &ProxyProcess{Listen: Endpoint{Port: 8080}, Routes: []ProxyRoute{{Path: "/", Unit: "web"}},
+			units: map[string]*RunpUnit{"web": {Host: &HostProcess{}}}}, `local address of unit web unknown`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.proxy.StartCommand()
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing <%s>, got <%v>", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestRunpUnitLocalAddress(t *testing.T) {
+	testCases := []struct {
+		unit     *RunpUnit
+		expected string
+	}{
+		{&RunpUnit{Container: &ContainerProcess{Ports: []string{"8025:8025"}}}, "localhost:8025"},
+		{&RunpUnit{Container: &ContainerProcess{Ports: []string{"127.0.0.1:8080:80/tcp"}}}, "127.0.0.1:8080"},
+		{&RunpUnit{SSHTunnel: &SSHTunnelProcess{Local: Endpoint{Port: 5432}}}, "localhost:5432"},
+		{&RunpUnit{KubeForward: &KubeForwardProcess{Ports: []string{"9090:90"}}}, "localhost:9090"},
+		{&RunpUnit{Proxy: &ProxyProcess{Listen: Endpoint{Host: "0.0.0.0", Port: 8080}}}, "0.0.0.0:8080"},
+		{&RunpUnit{Host: &HostProcess{}}, ""},
+		{&RunpUnit{vars: map[string]string{"port_db": "15432"}, Container: &ContainerProcess{Ports: []string{"{{vars port_db}}:5432"}}}, "localhost:15432"},
+		{&RunpUnit{vars: map[string]string{"port_api": "19090", "api_host": "127.0.0.1"},
+			KubeForward: &KubeForwardProcess{Address: "{{vars api_host}}", Ports: []string{"{{vars port_api}}:90"}}}, "127.0.0.1:19090"},
+	}
+	for _, tc := range testCases {
+		actual, _ := tc.unit.localAddress()
+		if actual != tc.expected {
+			t.Errorf("Local address for %s, expected <%s> got <%s>", tc.unit.Kind(), tc.expected, actual)
+		}
+	}
+}
+
+func TestProxyRouteToUnitWithPortVar(t *testing.T) {
+	setupTestUI(t)
+	p := &ProxyProcess{Listen: Endpoint{Port: 8080}, Routes: []ProxyRoute{{Path: "/db", Unit: "db"}},
+		units: map[string]*RunpUnit{"db": {vars: map[string]string{"port_db": "15432"},
+			Container: &ContainerProcess{Ports: []string{"{{vars port_db}}:5432"}}}}}
+	routes, err := p.resolveRoutes()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(routes) != 1 || routes[0].target.String() != "http://localhost:15432" {
+		t.Errorf("Expected route to http://localhost:15432, got %+v", routes)
+	}
+}
+
+func startProxy(t *testing.T, p *ProxyProcess) *syncBuffer {
+	t.Helper()
+	cmd, err := p.StartCommand()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	stdout := &syncBuffer{}
+	cmd.Stdout(stdout)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+	t.Cleanup(func() {
+		cmd.Stop()
+		select {
+		case err := <-waited:
+			if err != nil {
+				t.Errorf("Unexpected error from Wait: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Proxy not stopped")
+		}
+	})
+	return stdout
+}
+
+func TestProxyTCP(t *testing.T) {
+	target, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				fmt.Fprintf(conn, "echo %s", line)
+			}()
+		}
+	}()
+	port := target.Addr().(*net.TCPAddr).Port
+
+	stdout := startProxy(t, &ProxyProcess{
+		Listen:  Endpoint{Host: "localhost", Port: 3201},
+		Target:  Endpoint{Host: "localhost", Port: port},
+		Latency: "50ms",
+	})
+
+	started := time.Now()
+	conn, err := net.Dial("tcp", "localhost:3201")
+	if err != nil {
+		t.Fatalf("Error connecting to proxy: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "hello\n")
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Error reading from proxy: %v", err)
+	}
+	if response != "echo hello\n" {
+		t.Errorf("Unexpected response <%s>", response)
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected latency of 50ms, response in %s", elapsed)
+	}
+	if !strings.Contains(stdout.String(), fmt.Sprintf("to localhost:%d", port)) {
+		t.Errorf("Expected connection logged, got:\n%s", stdout.String())
+	}
+}
+
+func TestProxyHTTP(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s %s", name, r.Host, r.URL.Path)
+		}))
+	}
+	api := backend("api")
+	defer api.Close()
+	web := backend("web")
+	defer web.Close()
+	apiPort := api.Listener.Addr().(*net.TCPAddr).Port
+
+	startProxy(t, &ProxyProcess{
+		Listen: Endpoint{Host: "localhost", Port: 3202},
+		Routes: []ProxyRoute{
+			{Path: "/", Target: web.URL, HostHeader: "web.local"},
+			{Path: "/api", Unit: "api", StripPrefix: true},
+		},
+		units: map[string]*RunpUnit{
+			"api": {SSHTunnel: &SSHTunnelProcess{Local: Endpoint{Host: "127.0.0.1", Port: apiPort}}},
+		},
+	})
+
+	expectations := map[string]string{
+		`/api/users`: fmt.Sprintf("api 127.0.0.1:%d /users", apiPort),
+		`/apidocs`:   "web web.local /apidocs",
+		`/index`:     "web web.local /index",
+	}
+	for path, expected := range expectations {
+		resp, err := http.Get("http://localhost:3202" + path)
+		if err != nil {
+			t.Errorf("Error calling %s: %v", path, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != expected {
+			t.Errorf("Response for %s, expected <%s> got <%s>", path, expected, string(body))
+		}
+	}
+}
@@ -3,13 +3,14 @@ package core
 import (
 	"bytes"
 	"fmt"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/enr/go-files/files"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
+	yaml "gopkg.in/yaml.v3"
 )
 
 // Auth is auth
@@ -18,12 +19,67 @@ type Auth struct {
 	// encrypted secret in base 64
 	EncryptedSecret string `yaml:"encrypted_secret"`
 	IdentityFile    string `yaml:"identity_file"`
+	// passphrase of the identity file
+	Passphrase string
+	// encrypted passphrase in base 64
+	EncryptedPassphrase string `yaml:"encrypted_passphrase"`
+	// OpenSSH certificate, default is the identity file with "-cert.pub" suffix if it exists
+	CertificateFile string `yaml:"certificate_file"`
+	// Agent uses the keys of the ssh-agent listening at SSH_AUTH_SOCK
+	Agent bool
+	// KeyboardInteractive answers keyboard-interactive challenges with the secret
+	KeyboardInteractive bool `yaml:"keyboard_interactive"`
 }
 
 // Endpoint is server
 type Endpoint struct {
 	Host string
 	Port int
+
+	// port set using a template, as in `{{vars port_db}}`, resolved before the start
+	portTemplate string
+}
+
+// UnmarshalYAML accepts the port as a number or as a template.
+func (e *Endpoint) UnmarshalYAML(value *yaml.Node) error {
+	raw := struct {
+		Host string
+		Port string
+	}{}
+	if err := decodeNodeStrict(value, &raw); err != nil {
+		return err
+	}
+	*e = Endpoint{Host: raw.Host}
+	if raw.Port == "" {
+		return nil
+	}
+	if strings.Contains(raw.Port, "{{") {
+		e.portTemplate = raw.Port
+		return nil
+	}
+	port, err := strconv.Atoi(raw.Port)
+	if err != nil {
+		return errors.Errorf("line %d: invalid port %q", value.Line, raw.Port)
+	}
+	e.Port = port
+	return nil
+}
+
+// resolvePort sets the port processing the template, if any.
+func (e *Endpoint) resolvePort(vars map[string]string) error {
+	if e.portTemplate == "" {
+		return nil
+	}
+	processed, err := newCliPreprocessor(vars).execute(e.portTemplate)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(processed))
+	if err != nil {
+		return errors.Errorf("invalid port %q from %q", processed, e.portTemplate)
+	}
+	e.Port = port
+	return nil
 }
 
 func (e *Endpoint) String() string {
@@ -31,20 +87,77 @@ func (e *Endpoint) String() string {
 	if e.Host != "" {
 		host = e.Host
 	}
+	if e.Port == 0 && e.portTemplate != "" {
+		return fmt.Sprintf("%s:%s", host, e.portTemplate)
+	}
 	return fmt.Sprintf("%s:%d", host, e.Port)
 }
 
+// Forward is a port forwarding through the jump server.
+// A local forward (local and target) listens on local and connects to target from the jump server,
+// a remote forward (local and remote) listens on remote on the jump server and connects to local,
+// a dynamic forward (local only) is a SOCKS5 proxy on local connecting to the requested targets, as `ssh -D`.
+type Forward struct {
+	Local   Endpoint
+	Target  Endpoint
+	Remote  Endpoint
+	Dynamic bool
+}
+
+// IsRemote returns true for forwards exposing a local port on the jump server.
+func (f *Forward) IsRemote() bool {
+	return f.Remote.Port != 0
+}
+
+func (f *Forward) String() string {
+	if f.Dynamic {
+		return fmt.Sprintf("%s (SOCKS5)", f.Local.String())
+	}
+	if f.IsRemote() {
+		return fmt.Sprintf("%s <- %s", f.Local.String(), f.Remote.String())
+	}
+	return fmt.Sprintf("%s -> %s", f.Local.String(), f.Target.String())
+}
+
+// SSHHop is a jump server in a chain of jumps.
+// User, auth and host key settings default to the ones of the tunnel.
+type SSHHop struct {
+	Host           string
+	Port           int
+	User           string
+	Auth           Auth
+	KnownHostsFile string `yaml:"known_hosts_file"`
+	// InsecureIgnoreHostKey disables SSH host key verification for this hop.
+	InsecureIgnoreHostKey bool `yaml:"insecure_ignore_host_key"`
+}
+
+// Endpoint returns the hop address.
+func (h *SSHHop) Endpoint() Endpoint {
+	return Endpoint{Host: h.Host, Port: h.Port}
+}
+
 // SSHTunnelProcess implements RunpProcess.
 type SSHTunnelProcess struct {
 	WorkingDir string `yaml:"workdir"`
 	Env        map[string]string
 	Await      AwaitCondition
 
-	User   string
-	Auth   Auth
-	Local  Endpoint
-	Jump   Endpoint
+	User  string
+	Auth  Auth
+	Local Endpoint
+	Jump  Endpoint
+	// Hops is the chain of jump servers, set when `jump` is a list. The last hop connects to targets.
+	Hops   []SSHHop `yaml:"-"`
 	Target Endpoint
+	// Forwards served by the same SSH connection, if empty Local and Target are used
+	Forwards []Forward
+	// SSHConfigHost is a host alias in the OpenSSH client configuration used to fill
+	// user, jump, identity file and hops not specified in the unit.
+	SSHConfigHost string `yaml:"ssh_config_host"`
+	// SSHConfigFile overrides the default ~/.ssh/config path.
+	SSHConfigFile string `yaml:"ssh_config_file"`
+	// interval between keepalive requests to the jump server, default 30s, "0s" disables keepalive
+	KeepaliveInterval string `yaml:"keepalive_interval"`
 	// command executed to test connection to jump server
 	TestCommand string `yaml:"test_command"`
 	// KnownHostsFile overrides the default ~/.ssh/known_hosts path.
@@ -60,6 +173,33 @@ type SSHTunnelProcess struct {
 	cmd                 *SSHTunnelCommandWrapper
 	stopTimeout         string
 	environmentSettings *EnvironmentSettings
+	sshConfigApplied    bool
+	sshConfigErr        error
+}
+
+// UnmarshalYAML accepts `jump` as a single endpoint or as a list of hops.
+func (p *SSHTunnelProcess) UnmarshalYAML(value *yaml.Node) error {
+	type plain SSHTunnelProcess
+	node := value
+	var hops []SSHHop
+	if value.Kind == yaml.MappingNode {
+		node = &yaml.Node{Kind: value.Kind, Tag: value.Tag, Line: value.Line, Column: value.Column}
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			k, v := value.Content[i], value.Content[i+1]
+			if k.Value == "jump" && v.Kind == yaml.SequenceNode {
+				if err := decodeNodeStrict(v, &hops); err != nil {
+					return err
+				}
+				continue
+			}
+			node.Content = append(node.Content, k, v)
+		}
+	}
+	if err := decodeNodeStrict(node, (*plain)(p)); err != nil {
+		return err
+	}
+	p.Hops = hops
+	return nil
 }
 
 // ID for the sub process
@@ -97,18 +237,17 @@ func (p *SSHTunnelProcess) VerifyPreconditions() PreconditionVerifyResult {
 }
 
 func (p *SSHTunnelProcess) executeCmd(command string) (*bytes.Buffer, error) {
-	config, err := p.resolveSSHCommandConfiguration()
+	hops, err := p.resolveHops()
 	if err != nil {
 		return nil, err
 	}
-	hostname := p.Jump.Host
-	port := p.Jump.Port
-	ui.Debugf("Test command %s on %s:%d", p.TestCommand, hostname, port)
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", hostname, port), config)
+	ui.Debugf("Test command %s on %s", p.TestCommand, p.JumpDescription())
+	chain, err := dialSSHHops(hops)
 	if err != nil {
 		return nil, err
 	}
-	session, err := conn.NewSession()
+	defer chain.Close()
+	session, err := chain.client().NewSession()
 	if err != nil {
 		return nil, err
 	}
@@ -140,72 +279,185 @@ func (p *SSHTunnelProcess) SetID(id string) {
 
 // StartCommand returns the command starting the process.
 func (p *SSHTunnelProcess) StartCommand() (RunpCommand, error) {
-	config, err := p.resolveSSHCommandConfiguration()
+	hops, err := p.resolveHops()
 	if err != nil {
 		return nil, err
 	}
-	if p.Jump.Port == 0 {
-		return nil, errors.Errorf("Jump endpoint misconfiguration: port not specified (%s)", p.Jump.String())
+	forwards, err := p.resolveForwards()
+	if err != nil {
+		return nil, err
 	}
-	if p.Target.Port == 0 {
-		return nil, errors.Errorf("Target endpoint misconfiguration: port not specified (%s)", p.Target.String())
+	keepaliveInterval, err := p.keepaliveInterval()
+	if err != nil {
+		return nil, err
 	}
 	p.cmd = &SSHTunnelCommandWrapper{
-		config:        config,
-		localAddress:  p.Local.String(),
-		jumpAddress:   p.Jump.String(),
-		targetAddress: p.Target.String(),
+		hops:              hops,
+		jumpAddress:       p.JumpDescription(),
+		forwards:          forwards,
+		keepaliveInterval: keepaliveInterval,
+		minBackoff:        sshTunnelMinBackoff,
+		maxBackoff:        sshTunnelMaxBackoff,
 	}
 	return p.cmd, nil
 }
 
-func (p *SSHTunnelProcess) resolveSSHCommandConfiguration() (*ssh.ClientConfig, error) {
-	cliPreprocessor := newCliPreprocessor(p.vars)
-	authMethods := []ssh.AuthMethod{}
-	if p.Auth.IdentityFile != "" {
-		aif := cliPreprocessor.process(p.Auth.IdentityFile)
-		identityFile, err := resolvePath(aif, "")
+func (p *SSHTunnelProcess) keepaliveInterval() (time.Duration, error) {
+	if p.KeepaliveInterval == "" {
+		return sshTunnelKeepaliveInterval, nil
+	}
+	d, err := time.ParseDuration(p.KeepaliveInterval)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Invalid keepalive_interval %s", p.KeepaliveInterval)
+	}
+	return d, nil
+}
+
+// hops returns the configured hops, or the single Jump hop using the tunnel settings.
+func (p *SSHTunnelProcess) hops() []SSHHop {
+	if len(p.Hops) > 0 {
+		return p.Hops
+	}
+	return []SSHHop{{Host: p.Jump.Host, Port: p.Jump.Port}}
+}
+
+// JumpDescription returns the jump servers in the form "bastion:22 -> internal:22".
+func (p *SSHTunnelProcess) JumpDescription() string {
+	// errors are reported starting the tunnel
+	p.applySSHConfig()
+	descriptions := []string{}
+	for _, h := range p.hops() {
+		e := h.Endpoint()
+		descriptions = append(descriptions, e.String())
+	}
+	return strings.Join(descriptions, " -> ")
+}
+
+func (p *SSHTunnelProcess) resolveHops() ([]sshHop, error) {
+	if err := p.applySSHConfig(); err != nil {
+		return nil, err
+	}
+	hops := p.hops()
+	resolved := []sshHop{}
+	for i, h := range hops {
+		e := h.Endpoint()
+		if h.Port == 0 {
+			if len(hops) == 1 {
+				return nil, errors.Errorf("Jump endpoint misconfiguration: port not specified (%s)", e.String())
+			}
+			return nil, errors.Errorf("Jump endpoint misconfiguration: port not specified in hop %d/%d (%s)", i+1, len(hops), e.String())
+		}
+		config, err := p.resolveClientConfig(p.hopSettings(h))
 		if err != nil {
-			return nil, err
+			if len(hops) == 1 {
+				return nil, err
+			}
+			return nil, errors.Wrapf(err, "hop %d/%d (%s)", i+1, len(hops), e.String())
+		}
+		resolved = append(resolved, sshHop{
+			name:    fmt.Sprintf("hop %d/%d (%s)", i+1, len(hops), e.String()),
+			address: e.String(),
+			config:  config,
+		})
+	}
+	if len(resolved) == 1 {
+		resolved[0].name = fmt.Sprintf("jump server %s", resolved[0].address)
+	}
+	return resolved, nil
+}
+
+// hopSettings fills the hop settings not specified using the tunnel ones.
+func (p *SSHTunnelProcess) hopSettings(h SSHHop) SSHHop {
+	if h.User == "" {
+		h.User = p.User
+	}
+	if h.Auth == (Auth{}) {
+		h.Auth = p.Auth
+	}
+	// host key settings are used as a whole: a hop with its own known_hosts_file is verified
+	if h.KnownHostsFile == "" && !h.InsecureIgnoreHostKey {
+		h.KnownHostsFile = p.KnownHostsFile
+		h.InsecureIgnoreHostKey = p.InsecureIgnoreHostKey
+	}
+	return h
+}
+
+// forwards returns the configured forwards, or the single Local -> Target forward.
+func (p *SSHTunnelProcess) forwards() []Forward {
+	if len(p.Forwards) > 0 {
+		return p.Forwards
+	}
+	return []Forward{{Local: p.Local, Target: p.Target}}
+}
+
+func (p *SSHTunnelProcess) resolveForwards() ([]sshForward, error) {
+	forwards := []sshForward{}
+	for i, f := range p.forwards() {
+		if f.Local.Port == 0 {
+			return nil, errors.Errorf("Local endpoint misconfiguration: port not specified in forward %d (%s)", i+1, f.Local.String())
 		}
-		if !files.IsRegular(identityFile) {
-			return nil, errors.New("Invalid identity file: " + identityFile)
+		if f.Dynamic {
+			if f.Target.Port != 0 || f.IsRemote() {
+				return nil, errors.Errorf("Forward %d misconfiguration: dynamic forward with target or remote specified", i+1)
+			}
+			forwards = append(forwards, sshForward{local: f.Local.String(), dynamic: true})
+			continue
 		}
-		ui.Debugf("Connecting using SSH identity file: %s", identityFile)
-		am, err := publicKeyFile(identityFile)
-		if err != nil {
-			return nil, err
+		if f.IsRemote() {
+			if f.Target.Port != 0 {
+				return nil, errors.Errorf("Forward %d misconfiguration: both target and remote specified", i+1)
+			}
+			forwards = append(forwards, sshForward{local: f.Local.String(), remote: f.Remote.String()})
+			continue
 		}
-		authMethods = append(authMethods, am)
-	}
-	if p.Auth.Secret != "" {
-		as := cliPreprocessor.process(p.Auth.Secret)
-		authMethods = append(authMethods, ssh.Password(as))
-		ui.Debugf("Connecting using secret")
-	}
-	if p.Auth.EncryptedSecret != "" {
-		key := p.secretKey
-		ui.Debugf("Using encryption key for encrypted secret")
-		if key == "" {
-			return nil, errors.New("Encryption key required for encrypted_secret but not provided")
+		if f.Target.Port == 0 {
+			return nil, errors.Errorf("Target endpoint misconfiguration: port not specified (%s)", f.Target.String())
 		}
-		secretB64 := p.Auth.EncryptedSecret
-		secret, err := DecryptBase64(secretB64, key)
-		if err != nil {
-			return nil, err
+		forwards = append(forwards, sshForward{local: f.Local.String(), target: f.Target.String()})
+	}
+	return forwards, nil
+}
+
+// ForwardsDescription describes the forwards in `runp ls`.
+func (p *SSHTunnelProcess) ForwardsDescription() string {
+	if len(p.Forwards) == 0 {
+		return fmt.Sprintf(`%s -> %s -> %s`, p.Local.String(), p.JumpDescription(), p.Target.String())
+	}
+	descriptions := []string{}
+	for _, f := range p.Forwards {
+		switch {
+		case f.Dynamic:
+			descriptions = append(descriptions, fmt.Sprintf(`%s -> %s (SOCKS5)`, f.Local.String(), p.JumpDescription()))
+		case f.IsRemote():
+			descriptions = append(descriptions, fmt.Sprintf(`%s <- %s <- %s`, f.Local.String(), p.JumpDescription(), f.Remote.String()))
+		default:
+			descriptions = append(descriptions, fmt.Sprintf(`%s -> %s -> %s`, f.Local.String(), p.JumpDescription(), f.Target.String()))
 		}
-		ui.Debugf("Connecting using encrypted secret")
-		authMethods = append(authMethods, ssh.Password(string(secret)))
 	}
-	if len(authMethods) == 0 {
-		return nil, errors.New("No authentication method configured")
+	return strings.Join(descriptions, ", ")
+}
+
+// resolveSSHCommandConfiguration returns the client configuration for the first jump server.
+func (p *SSHTunnelProcess) resolveSSHCommandConfiguration() (*ssh.ClientConfig, error) {
+	if err := p.applySSHConfig(); err != nil {
+		return nil, err
+	}
+	return p.resolveClientConfig(p.hopSettings(p.hops()[0]))
+}
+
+func (p *SSHTunnelProcess) resolveClientConfig(h SSHHop) (*ssh.ClientConfig, error) {
+	endpoint := h.Endpoint()
+	cliPreprocessor := newCliPreprocessor(p.vars)
+	authMethods, err := p.resolveAuthMethods(h.Auth)
+	if err != nil {
+		return nil, err
 	}
 	var hostKeyCallback ssh.HostKeyCallback
-	if p.InsecureIgnoreHostKey {
-		ui.WriteLinef("WARNING: SSH host key verification disabled for %s — vulnerable to MITM", p.Jump.String())
+	if h.InsecureIgnoreHostKey {
+		ui.WriteLinef("WARNING: SSH host key verification disabled for %s — vulnerable to MITM", endpoint.String())
 		hostKeyCallback = ssh.InsecureIgnoreHostKey()
 	} else {
-		knownHostsPath := p.KnownHostsFile
+		knownHostsPath := h.KnownHostsFile
 		if knownHostsPath == "" {
 			knownHostsPath = "~/.ssh/known_hosts"
 		}
@@ -221,11 +473,12 @@ func (p *SSHTunnelProcess) resolveSSHCommandConfiguration() (*ssh.ClientConfig,
 		hostKeyCallback = cb
 	}
 
-	sshUser := cliPreprocessor.process(p.User)
+	sshUser := cliPreprocessor.process(h.User)
 	config := &ssh.ClientConfig{
 		User:            sshUser,
 		Auth:            authMethods,
 		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshTunnelDialTimeout,
 	}
 	return config, nil
 }
@@ -260,14 +513,14 @@ func (p *SSHTunnelProcess) ShouldWait() bool {
 	return (p.Await.Timeout != "")
 }
 
-// AwaitResource returns the await resource.
+// AwaitResource returns the await resource, with vars applied.
 func (p *SSHTunnelProcess) AwaitResource() string {
-	return p.Await.Resource
+	return newCliPreprocessor(p.vars).process(p.Await.Resource)
 }
 
-// AwaitTimeout returns the await timeout.
+// AwaitTimeout returns the await timeout, with vars applied.
 func (p *SSHTunnelProcess) AwaitTimeout() string {
-	return p.Await.Timeout
+	return newCliPreprocessor(p.vars).process(p.Await.Timeout)
 }
 
 // IsStartable always true.
@@ -291,13 +544,9 @@ func (p *SSHTunnelProcess) resolveEnvironment() []string {
 }
 
 func publicKeyFile(file string) (ssh.AuthMethod, error) {
-	buffer, err := os.ReadFile(file)
+	key, err := privateKeySigner(file, "")
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to read SSH private key file: %s", file)
-	}
-	key, err := ssh.ParsePrivateKey(buffer)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to parse SSH private key file: %s", file)
+		return nil, err
 	}
 	return ssh.PublicKeys(key), nil
 }
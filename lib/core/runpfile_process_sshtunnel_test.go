@@ -365,3 +365,82 @@ func TestResolveSSHCommandConfiguration_InvalidIdentityFile(t *testing.T) {
 		t.Error("expected error for invalid identity file, got nil — nil ssh.AuthMethod is silently included and will panic at connection time")
 	}
 }
+
+func TestSSHTunnelProcess_UnmarshalJumpHops(t *testing.T) {
+	data := []byte(`
+user: runp
+auth:
+  secret: test
+jump:
+  - host: bastion
+    port: 22
+  - host: internal
+    port: 2222
+    user: internal
+    auth:
+      identity_file: ~/.ssh/internal
+target:
+  host: db
+  port: 5432
+`)
+	p := &SSHTunnelProcess{}
+	if err := unmarshalStrict(data, p); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(p.Hops) != 2 {
+		t.Fatalf("Expected 2 hops, got %d", len(p.Hops))
+	}
+	if p.Hops[1].User != "internal" || p.Hops[1].Auth.IdentityFile != "~/.ssh/internal" {
+		t.Errorf("Unexpected hop %+v", p.Hops[1])
+	}
+	if p.Target.Port != 5432 {
+		t.Errorf("Expected target port 5432, got %d", p.Target.Port)
+	}
+	expected := "bastion:22 -> internal:2222"
+	if p.JumpDescription() != expected {
+		t.Errorf("Expected jump description '%s', got '%s'", expected, p.JumpDescription())
+	}
+	settings := p.hopSettings(p.Hops[0])
+	if settings.User != "runp" || settings.Auth.Secret != "test" {
+		t.Errorf("Expected hop settings defaulting to tunnel settings, got %+v", settings)
+	}
+}
+
+func TestSSHTunnelHopHostKeySettings(t *testing.T) {
+	testCases := []struct {
+		tunnel   SSHTunnelProcess
+		hop      SSHHop
+		expected SSHHop
+	}{
+		{SSHTunnelProcess{InsecureIgnoreHostKey: true}, SSHHop{}, SSHHop{InsecureIgnoreHostKey: true}},
+		{SSHTunnelProcess{KnownHostsFile: "tunnel_hosts"}, SSHHop{}, SSHHop{KnownHostsFile: "tunnel_hosts"}},
+		{SSHTunnelProcess{InsecureIgnoreHostKey: true}, SSHHop{KnownHostsFile: "hop_hosts"}, SSHHop{KnownHostsFile: "hop_hosts"}},
+		{SSHTunnelProcess{KnownHostsFile: "tunnel_hosts"}, SSHHop{InsecureIgnoreHostKey: true}, SSHHop{InsecureIgnoreHostKey: true}},
+		{SSHTunnelProcess{KnownHostsFile: "tunnel_hosts", InsecureIgnoreHostKey: true}, SSHHop{KnownHostsFile: "hop_hosts"}, SSHHop{KnownHostsFile: "hop_hosts"}},
+	}
+	for i, tc := range testCases {
+		settings := tc.tunnel.hopSettings(tc.hop)
+		if settings.KnownHostsFile != tc.expected.KnownHostsFile || settings.InsecureIgnoreHostKey != tc.expected.InsecureIgnoreHostKey {
+			t.Errorf("Case %d, expected known hosts <%s> insecure %t, got <%s> %t", i, tc.expected.KnownHostsFile,
+				tc.expected.InsecureIgnoreHostKey, settings.KnownHostsFile, settings.InsecureIgnoreHostKey)
+		}
+	}
+}
+
+func TestSSHTunnelProcess_UnmarshalJumpEndpoint(t *testing.T) {
+	p := &SSHTunnelProcess{}
+	if err := unmarshalStrict([]byte("jump:\n  host: bastion\n  port: 22\n"), p); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(p.Hops) != 0 || p.Jump.Host != "bastion" || p.Jump.Port != 22 {
+		t.Errorf("Unexpected jump %+v hops %+v", p.Jump, p.Hops)
+	}
+	err := unmarshalStrict([]byte("jump:\n  - host: bastion\n    prt: 22\n"), p)
+	if err == nil {
+		t.Errorf("Expected error for unknown field in hop")
+	}
+	err = unmarshalStrict([]byte("jmp:\n  host: bastion\n"), p)
+	if err == nil {
+		t.Errorf("Expected error for unknown field in tunnel")
+	}
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// paramRegexp matches the params of unit templates: {{param port}} or {{param "port"}}
+var paramRegexp = regexp.MustCompile(`{{\s*param\s+"?([A-Za-z_][A-Za-z0-9_.-]*)"?\s*}}`)
+
+// expandDocumentTemplates expands the units of the Runpfile document extending a template and removes the
+// templates section. It returns the templates available to the included Runpfiles too: the inherited ones
+// and the ones declared in the Runpfile.
+func expandDocumentTemplates(doc *yaml.Node, inherited map[string]*yaml.Node) (map[string]*yaml.Node, error) {
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return inherited, nil
+	}
+	return expandTemplates(doc.Content[0], inherited)
+}
+
+// expandTemplates expands the units of the Runpfile mapping node extending a template.
+func expandTemplates(root *yaml.Node, inherited map[string]*yaml.Node) (map[string]*yaml.Node, error) {
+	templates := map[string]*yaml.Node{}
+	for name, t := range inherited {
+		templates[name] = t
+	}
+	var units *yaml.Node
+	content := []*yaml.Node{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		switch k.Value {
+		case "templates":
+			if v.Kind != yaml.MappingNode {
+				return nil, errors.Errorf("line %d: templates must be a mapping", v.Line)
+			}
+			for j := 0; j+1 < len(v.Content); j += 2 {
+				if v.Content[j+1].Kind != yaml.MappingNode {
+					return nil, errors.Errorf("line %d: invalid template %s", v.Content[j].Line, v.Content[j].Value)
+				}
+				templates[v.Content[j].Value] = v.Content[j+1]
+			}
+			continue
+		case "units":
+			units = v
+		}
+		content = append(content, k, v)
+	}
+	root.Content = content
+	if units == nil || units.Kind != yaml.MappingNode {
+		return templates, nil
+	}
+	for i := 0; i+1 < len(units.Content); i += 2 {
+		id, unit := units.Content[i].Value, units.Content[i+1]
+		if mappingValue(unit, "extends") == "" {
+			continue
+		}
+		expanded, err := expandUnit(id, unit, templates)
+		if err != nil {
+			return nil, err
+		}
+		// errors about the whole unit refer to the unit, not to the template
+		expanded.Line, expanded.Column = unit.Line, unit.Column
+		units.Content[i+1] = expanded
+	}
+	return templates, nil
+}
+
+// expandUnit returns the template extended by the unit, merged with the unit and with the params replaced.
+func expandUnit(id string, unit *yaml.Node, templates map[string]*yaml.Node) (*yaml.Node, error) {
+	name := mappingValue(unit, "extends")
+	base, err := resolveTemplate(name, templates, map[string]bool{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "line %d: unit %s", unit.Line, id)
+	}
+	expanded := mergeNodes(base, copyNode(unit))
+	params := map[string]string{"unit": id}
+	if p := lookupMappingKey(expanded, "params"); p != nil {
+		declared := map[string]string{}
+		if err := p.Decode(&declared); err != nil {
+			return nil, errors.Wrapf(err, "line %d: unit %s: invalid params", p.Line, id)
+		}
+		for k, v := range declared {
+			params[k] = v
+		}
+	}
+	if err := replaceParams(expanded, params); err != nil {
+		return nil, errors.Wrapf(err, "unit %s", id)
+	}
+	return expanded, nil
+}
+
+// resolveTemplate returns a copy of the template merged with the templates it extends.
+func resolveTemplate(name string, templates map[string]*yaml.Node, seen map[string]bool) (*yaml.Node, error) {
+	t, ok := templates[name]
+	if !ok {
+		return nil, errors.Errorf("template %s not found", name)
+	}
+	if seen[name] {
+		return nil, errors.Errorf("template %s extends itself", name)
+	}
+	seen[name] = true
+	node := copyNode(t)
+	parent := mappingValue(node, "extends")
+	if parent == "" {
+		return node, nil
+	}
+	base, err := resolveTemplate(parent, templates, seen)
+	if err != nil {
+		return nil, errors.Wrapf(err, "template %s", name)
+	}
+	return mergeNodes(base, node), nil
+}
+
+// replaceParams replaces the params in the scalar values of node.
+func replaceParams(node *yaml.Node, params map[string]string) error {
+	if node.Kind == yaml.ScalarNode {
+		var missing []string
+		node.Value = paramRegexp.ReplaceAllStringFunc(node.Value, func(m string) string {
+			name := paramRegexp.FindStringSubmatch(m)[1]
+			value, ok := params[name]
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return errors.Errorf("line %d: param %s not defined", node.Line, missing[0])
+		}
+		return nil
+	}
+	for i, n := range node.Content {
+		// keys are not replaced
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := replaceParams(n, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupMappingKey returns the value of key in the mapping node.
+func lookupMappingKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// copyNode returns a deep copy of node, so that templates are not changed by the units extending them.
+func copyNode(node *yaml.Node) *yaml.Node {
+	c := *node
+	c.Content = make([]*yaml.Node, len(node.Content))
+	for i, n := range node.Content {
+		c.Content[i] = copyNode(n)
+	}
+	return &c
+}
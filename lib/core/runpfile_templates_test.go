@@ -0,0 +1,54 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnitTemplates(t *testing.T) {
+	setupTestUI(t)
+	rp, err := LoadRunpfileFromPath("../../testdata/runpfiles/templates/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	testCases := []struct {
+		unit    string
+		command string
+		env     map[string]string
+	}{
+		{"users", "./run --port 8081", map[string]string{"LOG_LEVEL": "info", "SERVICE_NAME": "users"}},
+		{"orders", "./run --port 8082", map[string]string{"LOG_LEVEL": "debug", "SERVICE_NAME": "orders", "JAVA_OPTS": "-Xmx256m"}},
+		{"billing", "./run --port 8083", map[string]string{"LOG_LEVEL": "info", "SERVICE_NAME": "billing"}},
+	}
+	for _, tc := range testCases {
+		unit := rp.Units[tc.unit]
+		if unit == nil || unit.Host == nil {
+			t.Fatalf("Unit %s, expected host process, got %+v", tc.unit, unit)
+		}
+		if unit.Host.CommandLine != tc.command {
+			t.Errorf("Unit %s, expected command <%s>, got <%s>", tc.unit, tc.command, unit.Host.CommandLine)
+		}
+		assertMapEquals(unit.Host.Env, tc.env, tc.unit+" env", t)
+		if expected := filepath.Join(rp.Root, "services", tc.unit); unit.Process().Dir() != expected {
+			t.Errorf("Unit %s, expected workdir <%s>, got <%s>", tc.unit, expected, unit.Process().Dir())
+		}
+	}
+	if rp.Units["plain"].Host.CommandLine != "echo {{vars runp_root}}" {
+		t.Errorf("Expected vars not replaced by templates, got <%s>", rp.Units["plain"].Host.CommandLine)
+	}
+}
+
+func TestUnitTemplatesErrors(t *testing.T) {
+	setupTestUI(t)
+	testCases := map[string]string{
+		"missing-param.yml": "unit users: line 4: param port not defined",
+		"circular.yml":      "template a extends itself",
+	}
+	for file, expected := range testCases {
+		_, err := LoadRunpfileFromPath("../../testdata/runpfiles/templates/" + file)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing <%s>, got <%v>", file, expected, err)
+		}
+	}
+}
@@ -69,6 +69,24 @@ func TestRunpUnitKind(t *testing.T) {
 		}
 	})
 
+	t.Run("SSH tunnel process with forwards", func(t *testing.T) {
+		unit := &RunpUnit{
+			SSHTunnel: &SSHTunnelProcess{
+				Jump: Endpoint{Host: "jump.example.com", Port: 22},
+				Forwards: []Forward{
+					{Local: Endpoint{Port: 5432}, Target: Endpoint{Host: "db.internal", Port: 5432}},
+					{Local: Endpoint{Port: 3000}, Remote: Endpoint{Port: 9000}},
+				},
+			},
+		}
+
+		kind := unit.Kind()
+		expected := "SSH tunnel localhost:5432 -> jump.example.com:22 -> db.internal:5432, localhost:3000 <- jump.example.com:22 <- localhost:9000"
+		if kind != expected {
+			t.Errorf("Expected '%s', got '%s'", expected, kind)
+		}
+	})
+
 	// Test for a unit without processes (should return an empty string).
 	t.Run("Empty unit", func(t *testing.T) {
 		unit := &RunpUnit{}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// varCommand is the declaration of a var whose value is the output of a command.
+type varCommand struct {
+	Command string
+}
+
+// UnmarshalYAML accepts vars computed from the output of a command: `name: {command: "git rev-parse HEAD"}`,
+// and keeps the YAML of the units, used to apply overrides.
+func (rf *Runpfile) UnmarshalYAML(value *yaml.Node) error {
+	type plain Runpfile
+	node := value
+	computed := map[string]string{}
+	unitNodes := map[string]*yaml.Node{}
+	if value.Kind == yaml.MappingNode {
+		node = &yaml.Node{Kind: value.Kind, Tag: value.Tag, Line: value.Line, Column: value.Column}
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			k, v := value.Content[i], value.Content[i+1]
+			if k.Value == "vars" && v.Kind == yaml.MappingNode {
+				static, err := extractComputedVars(v, computed)
+				if err != nil {
+					return err
+				}
+				v = static
+			}
+			if k.Value == "units" && v.Kind == yaml.MappingNode {
+				for j := 0; j+1 < len(v.Content); j += 2 {
+					unitNodes[v.Content[j].Value] = v.Content[j+1]
+				}
+			}
+			node.Content = append(node.Content, k, v)
+		}
+	}
+	if err := decodeNodeStrict(node, (*plain)(rf)); err != nil {
+		return err
+	}
+	rf.computedVars = computed
+	rf.unitNodes = unitNodes
+	rf.node = value
+	return nil
+}
+
+// extractComputedVars adds the commands of the computed vars to computed and returns the node
+// with the remaining vars.
+func extractComputedVars(value *yaml.Node, computed map[string]string) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: value.Kind, Tag: value.Tag, Line: value.Line, Column: value.Column}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		k, v := value.Content[i], value.Content[i+1]
+		if v.Kind != yaml.MappingNode {
+			node.Content = append(node.Content, k, v)
+			continue
+		}
+		vc := varCommand{}
+		if err := decodeNodeStrict(v, &vc); err != nil {
+			return nil, errors.Wrapf(err, "line %d: invalid var %s", k.Line, k.Value)
+		}
+		if strings.TrimSpace(vc.Command) == "" {
+			return nil, errors.Errorf("line %d: invalid var %s: command not specified", k.Line, k.Value)
+		}
+		computed[k.Value] = vc.Command
+	}
+	return node, nil
+}
+
+// computeVars runs the commands of the computed vars in the Runpfile directory, using the default shell.
+func (rf *Runpfile) computeVars() error {
+	if len(rf.computedVars) == 0 {
+		return nil
+	}
+	if rf.Vars == nil {
+		rf.Vars = map[string]string{}
+	}
+	names := make([]string, 0, len(rf.computedVars))
+	for name := range rf.computedVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := runVarCommand(rf.computedVars[name], rf.Root)
+		if err != nil {
+			return errors.Wrapf(err, "var %s", name)
+		}
+		ui.Debugf("Computed var %s using %q", name, rf.computedVars[name])
+		rf.Vars[name] = value
+	}
+	return nil
+}
+
+// runVarCommand returns the output of the command without the trailing newlines.
+func runVarCommand(commandLine string, dir string) (string, error) {
+	c, err := cmd(commandLine)
+	if err != nil {
+		return "", err
+	}
+	c.Dir = dir
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.Errorf("command %q failed: %v: %s", commandLine, err, msg)
+		}
+		return "", errors.Errorf("command %q failed: %v", commandLine, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
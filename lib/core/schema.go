@@ -0,0 +1,198 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// schemaID is the identifier of the published schema, docs/runpfile.schema.json in the repository.
+const schemaID = "https://raw.githubusercontent.com/enr/runp/main/docs/runpfile.schema.json"
+
+// encryptedSectionSchema is the definition of encrypted sections.
+const encryptedSectionSchema = "EncryptedSection"
+
+// schemaObject is a JSON Schema.
+type schemaObject map[string]interface{}
+
+// customSchemas returns the schemas of the types with a custom YAML unmarshaler.
+func customSchemas() map[reflect.Type]func(g *schemaGenerator) schemaObject {
+	return map[reflect.Type]func(g *schemaGenerator) schemaObject{
+		reflect.TypeOf(StringList{}): func(g *schemaGenerator) schemaObject {
+			return oneOf(schemaObject{"type": "string"}, schemaObject{"type": "array", "items": schemaObject{"type": "string"}})
+		},
+		reflect.TypeOf(InheritEnv{}): func(g *schemaGenerator) schemaObject {
+			return oneOf(schemaObject{"enum": []string{"all", "none"}}, schemaObject{"type": "array", "items": schemaObject{"type": "string"}})
+		},
+		reflect.TypeOf(IncludeSpec{}): func(g *schemaGenerator) schemaObject {
+			return oneOf(schemaObject{"type": "string"}, g.object(reflect.TypeOf(IncludeSpec{}), nil))
+		},
+		reflect.TypeOf(Endpoint{}): func(g *schemaGenerator) schemaObject {
+			return g.object(reflect.TypeOf(Endpoint{}), map[string]schemaObject{
+				// a number or a template as {{vars db_port}}
+				"port": oneOf(schemaObject{"type": "integer"}, schemaObject{"type": "string"}),
+			})
+		},
+		reflect.TypeOf(EnvVarsPrecondition{}): func(g *schemaGenerator) schemaObject {
+			checks := schemaObject{"type": "array", "items": g.ref(reflect.TypeOf(EnvVarCheck{}))}
+			return oneOf(checks, schemaObject{
+				"type":                 "object",
+				"properties":           schemaObject{"env_vars": checks},
+				"additionalProperties": false,
+			})
+		},
+		reflect.TypeOf(EnvVarCondition("")): func(g *schemaGenerator) schemaObject {
+			return schemaObject{"enum": []EnvVarCondition{EnvVarConditionIsSet, EnvVarConditionIsUnset, EnvVarConditionIsEqual}}
+		},
+		reflect.TypeOf(SSHTunnelProcess{}): func(g *schemaGenerator) schemaObject {
+			return g.object(reflect.TypeOf(SSHTunnelProcess{}), map[string]schemaObject{
+				// a single jump server or the list of hops
+				"jump": g.encryptable(oneOf(g.ref(reflect.TypeOf(Endpoint{})), schemaObject{"type": "array", "items": g.ref(reflect.TypeOf(SSHHop{}))})),
+			})
+		},
+		reflect.TypeOf(Runpfile{}): func(g *schemaGenerator) schemaObject {
+			return g.object(reflect.TypeOf(Runpfile{}), map[string]schemaObject{
+				// static values or the command computing the value
+				"vars": g.encryptable(schemaObject{"type": "object", "additionalProperties": oneOf(
+					schemaObject{"type": []string{"string", "number", "boolean"}},
+					schemaObject{
+						"type":                 "object",
+						"properties":           schemaObject{"command": schemaObject{"type": "string"}},
+						"required":             []string{"command"},
+						"additionalProperties": false,
+					},
+				)}),
+				// unit templates, removed loading the Runpfile
+				"templates": g.encryptable(schemaObject{"type": "object", "additionalProperties": g.schema(reflect.TypeOf(RunpUnit{}))}),
+			})
+		},
+	}
+}
+
+// RunpfileSchema returns the JSON Schema of the Runpfile format, generated from the model.
+func RunpfileSchema() ([]byte, error) {
+	g := &schemaGenerator{defs: schemaObject{}, custom: customSchemas()}
+	// a whole Runpfile can be encrypted
+	root := g.encryptable(g.ref(reflect.TypeOf(Runpfile{})))
+	schema := schemaObject{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     schemaID,
+		"title":   "Runpfile",
+		"anyOf":   root["anyOf"],
+		"$defs":   g.defs,
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot generate the schema")
+	}
+	return append(data, '\n'), nil
+}
+
+type schemaGenerator struct {
+	defs   schemaObject
+	custom map[reflect.Type]func(g *schemaGenerator) schemaObject
+}
+
+// ref returns a reference to the definition of the type t, adding the definition if needed.
+func (g *schemaGenerator) ref(t reflect.Type) schemaObject {
+	ref := schemaObject{"$ref": "#/$defs/" + t.Name()}
+	if _, ok := g.defs[t.Name()]; ok {
+		return ref
+	}
+	// set before generating to support recursive types
+	g.defs[t.Name()] = schemaObject{}
+	if custom, ok := g.custom[t]; ok {
+		g.defs[t.Name()] = custom(g)
+	} else {
+		g.defs[t.Name()] = g.object(t, nil)
+	}
+	return ref
+}
+
+// object returns the schema of the struct t: the properties are the fields decoded by yaml, the ones in
+// properties replace the generated ones. Unknown properties are not allowed, as in the strict decoding.
+func (g *schemaGenerator) object(t reflect.Type, properties map[string]schemaObject) schemaObject {
+	props := schemaObject{}
+	for _, name := range yamlFields(t) {
+		f, _ := t.FieldByName(name.field)
+		props[name.key] = g.schema(f.Type)
+	}
+	for k, p := range properties {
+		props[k] = p
+	}
+	return schemaObject{"type": "object", "properties": props, "additionalProperties": false}
+}
+
+// schema returns the schema of the type t. Mappings and lists can be encrypted sections too.
+func (g *schemaGenerator) schema(t reflect.Type) schemaObject {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if _, ok := g.custom[t]; ok {
+		if t.Kind() == reflect.Struct || t.Kind() == reflect.Slice {
+			return g.encryptable(g.ref(t))
+		}
+		return g.ref(t)
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return g.encryptable(g.ref(t))
+	case reflect.Slice:
+		return g.encryptable(schemaObject{"type": "array", "items": g.schema(t.Elem())})
+	case reflect.Map:
+		return g.encryptable(schemaObject{"type": "object", "additionalProperties": g.schema(t.Elem())})
+	case reflect.Bool:
+		return schemaObject{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return schemaObject{"type": "integer"}
+	}
+	// numbers and booleans are decoded as strings too, as in `PORT: 8080`
+	return schemaObject{"type": []string{"string", "number", "boolean"}}
+}
+
+// yamlField is an exported field of a struct and its key in the YAML.
+type yamlField struct {
+	field string
+	key   string
+}
+
+// yamlFields returns the fields decoded by yaml, sorted by key: the key is the name in the yaml tag or
+// the lowercased field name.
+func yamlFields(t reflect.Type) []yamlField {
+	fields := []yamlField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		fields = append(fields, yamlField{field: f.Name, key: key})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+	return fields
+}
+
+// encryptable returns the schema s or an encrypted section, the string written by `runp encrypt --path`
+// with the !encrypted tag.
+func (g *schemaGenerator) encryptable(s schemaObject) schemaObject {
+	if _, ok := g.defs[encryptedSectionSchema]; !ok {
+		g.defs[encryptedSectionSchema] = schemaObject{
+			"type":        "string",
+			"description": "section encrypted by runp encrypt, tagged " + encryptedTag,
+		}
+	}
+	return schemaObject{"anyOf": []schemaObject{s, {"$ref": "#/$defs/" + encryptedSectionSchema}}}
+}
+
+func oneOf(schemas ...schemaObject) schemaObject {
+	return schemaObject{"oneOf": schemas}
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// schemaExtraProperties are the properties of the schema not decoded into a field.
+var schemaExtraProperties = map[string][]string{
+	"Runpfile": {"templates"},
+}
+
+// schemaCustomTypes are the structs with a custom YAML form, not matching their fields.
+var schemaCustomTypes = map[string]bool{
+	"InheritEnv":          true,
+	"EnvVarsPrecondition": true,
+}
+
+func TestRunpfileSchemaStructTags(t *testing.T) {
+	data, err := RunpfileSchema()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	schema := map[string]interface{}{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Invalid JSON %v", err)
+	}
+	if root, ok := withoutEncrypted(schema); !ok || root["$ref"] != "#/$defs/Runpfile" {
+		t.Errorf("Expected root reference to Runpfile or encrypted Runpfile, got %v", schema["anyOf"])
+	}
+	defs := schema["$defs"].(map[string]interface{})
+	visited := map[reflect.Type]bool{}
+	var check func(reflect.Type)
+	check = func(typ reflect.Type) {
+		for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct || visited[typ] {
+			return
+		}
+		visited[typ] = true
+		def, ok := defs[typ.Name()].(map[string]interface{})
+		if !ok {
+			t.Errorf("Missing definition of %s", typ.Name())
+			return
+		}
+		expected := append([]string{}, schemaExtraProperties[typ.Name()]...)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			tag := f.Tag.Get("yaml")
+			if f.PkgPath != "" || tag == "-" {
+				continue
+			}
+			name := strings.ToLower(f.Name)
+			if tag != "" && !strings.HasPrefix(tag, ",") {
+				name = strings.Split(tag, ",")[0]
+			}
+			expected = append(expected, name)
+			check(f.Type)
+		}
+		if schemaCustomTypes[typ.Name()] {
+			return
+		}
+		actual := []string{}
+		for name := range objectProperties(def) {
+			actual = append(actual, name)
+		}
+		sort.Strings(expected)
+		sort.Strings(actual)
+		if strings.Join(actual, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s: expected properties %v, got %v", typ.Name(), expected, actual)
+		}
+	}
+	check(reflect.TypeOf(Runpfile{}))
+	for _, name := range []string{"RunpUnit", "HostProcess", "ContainerProcess", "SSHTunnelProcess", "Preconditions", "SSHHop"} {
+		if _, ok := defs[name]; !ok {
+			t.Errorf("Missing definition of %s", name)
+		}
+	}
+}
+
+// objectProperties returns the properties of the object schema, or of the object alternative in oneOf.
+func objectProperties(def map[string]interface{}) map[string]interface{} {
+	if props, ok := def["properties"].(map[string]interface{}); ok {
+		return props
+	}
+	alternatives, _ := def["oneOf"].([]interface{})
+	for _, a := range alternatives {
+		if props, ok := a.(map[string]interface{})["properties"].(map[string]interface{}); ok {
+			return props
+		}
+	}
+	return nil
+}
+
+func TestRunpfileSchemaCustomForms(t *testing.T) {
+	data, err := RunpfileSchema()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	schema := map[string]interface{}{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Invalid JSON %v", err)
+	}
+	defs := schema["$defs"].(map[string]interface{})
+	envVars := defs["EnvVarsPrecondition"].(map[string]interface{})["oneOf"].([]interface{})
+	if len(envVars) != 2 || envVars[0].(map[string]interface{})["type"] != "array" || envVars[1].(map[string]interface{})["type"] != "object" {
+		t.Errorf("Expected env_vars as list or mapping, got %v", envVars)
+	}
+	if _, ok := objectProperties(envVars[1].(map[string]interface{}))["env_vars"]; !ok {
+		t.Errorf("Expected env_vars mapping with the env_vars key, got %v", envVars[1])
+	}
+	conditions := defs["EnvVarCondition"].(map[string]interface{})["enum"].([]interface{})
+	if len(conditions) != 3 || conditions[0] != "is_set" || conditions[1] != "is_unset" || conditions[2] != "is_equal" {
+		t.Errorf("Unexpected conditions %v", conditions)
+	}
+	jump, _ := withoutEncrypted(objectProperties(defs["SSHTunnelProcess"].(map[string]interface{}))["jump"].(map[string]interface{}))
+	if _, ok := jump["oneOf"]; !ok {
+		t.Errorf("Expected jump as endpoint or list of hops, got %v", jump)
+	}
+	if _, ok := objectProperties(defs["SSHTunnelProcess"].(map[string]interface{}))["hops"]; ok {
+		t.Errorf("Unexpected hops property")
+	}
+}
+
+// withoutEncrypted returns the schema s without the encrypted section alternative, and true if s has it.
+func withoutEncrypted(s map[string]interface{}) (map[string]interface{}, bool) {
+	alternatives, _ := s["anyOf"].([]interface{})
+	if len(alternatives) != 2 || alternatives[1].(map[string]interface{})["$ref"] != "#/$defs/EncryptedSection" {
+		return s, false
+	}
+	return alternatives[0].(map[string]interface{}), true
+}
+
+func TestRunpfileSchemaEncryptedSections(t *testing.T) {
+	data, err := RunpfileSchema()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	schema := map[string]interface{}{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Invalid JSON %v", err)
+	}
+	defs := schema["$defs"].(map[string]interface{})
+	if defs["EncryptedSection"].(map[string]interface{})["type"] != "string" {
+		t.Errorf("Expected encrypted section as string, got %v", defs["EncryptedSection"])
+	}
+	runpfile := objectProperties(defs["Runpfile"].(map[string]interface{}))
+	host := objectProperties(defs["HostProcess"].(map[string]interface{}))
+	units, _ := withoutEncrypted(runpfile["units"].(map[string]interface{}))
+	testCases := map[string]map[string]interface{}{
+		"vars":            runpfile["vars"].(map[string]interface{}),
+		"templates":       runpfile["templates"].(map[string]interface{}),
+		"units":           runpfile["units"].(map[string]interface{}),
+		"unit":            units["additionalProperties"].(map[string]interface{}),
+		"host env":        host["env"].(map[string]interface{}),
+		"host args":       host["args"].(map[string]interface{}),
+		"host await":      host["await"].(map[string]interface{}),
+		"ssh tunnel jump": objectProperties(defs["SSHTunnelProcess"].(map[string]interface{}))["jump"].(map[string]interface{}),
+	}
+	for name, property := range testCases {
+		if _, ok := withoutEncrypted(property); !ok {
+			t.Errorf("%s: expected encrypted section allowed, got %v", name, property)
+		}
+	}
+	if _, ok := withoutEncrypted(host["command"].(map[string]interface{})); ok {
+		t.Errorf("Expected scalar setting without encrypted section alternative, got %v", host["command"])
+	}
+}
+
+func TestPublishedRunpfileSchema(t *testing.T) {
+	data, err := RunpfileSchema()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	published, err := os.ReadFile("../../docs/runpfile.schema.json")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if string(published) != string(data) {
+		t.Errorf("docs/runpfile.schema.json is outdated: update it with runp schema > docs/runpfile.schema.json")
+	}
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SecretSpec declares a secret in the `secrets` section of the Runpfile.
+type SecretSpec struct {
+	// Provider is the name of the provider resolving the secret: aes, vault, pass, gpg, keyring, env, command
+	Provider string
+	// encrypted value in base 64 (aes)
+	Value string
+	// encrypted vault (vault) or gpg encrypted file (gpg), relative to the Runpfile directory
+	File string
+	// name of the secret for the provider: vault key, pass entry, environment variable.
+	// Default is the name of the secret in the Runpfile.
+	Name string
+	// keyring service and account
+	Service string
+	Account string
+	// command printing the secret (command, keyring)
+	Command string
+}
+
+// SecretRef is a secret declared in a Runpfile, with the settings needed by providers.
+type SecretRef struct {
+	// name in the Runpfile
+	Name string
+	Spec SecretSpec
+	// encryption key given using --key, --key-env or --key-file
+	Key string
+	// Runpfile directory
+	Root string
+}
+
+// name returns the name of the secret for the provider.
+func (r SecretRef) name() string {
+	if r.Spec.Name != "" {
+		return r.Spec.Name
+	}
+	return r.Name
+}
+
+// SecretProvider returns the value of secrets.
+type SecretProvider interface {
+	Secret(ref SecretRef) (string, error)
+}
+
+// SecretProviderFunc is a function implementing SecretProvider.
+type SecretProviderFunc func(ref SecretRef) (string, error)
+
+// Secret calls f.
+func (f SecretProviderFunc) Secret(ref SecretRef) (string, error) {
+	return f(ref)
+}
+
+var (
+	secretProvidersMu sync.RWMutex
+	secretProviders   = map[string]SecretProvider{
+		"aes":     SecretProviderFunc(aesSecret),
+		"vault":   SecretProviderFunc(vaultSecret),
+		"pass":    SecretProviderFunc(passSecret),
+		"gpg":     SecretProviderFunc(gpgSecret),
+		"keyring": SecretProviderFunc(keyringSecret),
+		"env":     SecretProviderFunc(envSecret),
+		"command": SecretProviderFunc(commandSecret),
+	}
+	// secrets of the running Runpfile, used by the `secret` template function
+	activeSecretsMu sync.RWMutex
+	activeSecrets   *secretStore
+)
+
+// RegisterSecretProvider adds a provider, or replaces the one with the same name.
+func RegisterSecretProvider(name string, provider SecretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	secretProviders[name] = provider
+}
+
+func secretProvider(name string) (SecretProvider, bool) {
+	secretProvidersMu.RLock()
+	defer secretProvidersMu.RUnlock()
+	p, ok := secretProviders[name]
+	return p, ok
+}
+
+// secretStore resolves the secrets of a Runpfile once, registering the values to be redacted.
+type secretStore struct {
+	mu     sync.Mutex
+	refs   map[string]SecretRef
+	values map[string]string
+	// redactOnly returns the redacted text for the declared secrets without calling the providers
+	redactOnly bool
+}
+
+func newSecretStore(rf *Runpfile) *secretStore {
+	s := &secretStore{refs: map[string]SecretRef{}, values: map[string]string{}}
+	for name, spec := range rf.Secrets {
+		s.refs[name] = SecretRef{Name: name, Spec: spec, Key: rf.SecretKey, Root: rf.secretRoot(name)}
+	}
+	return s
+}
+
+func (s *secretStore) get(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, ok := s.values[name]; ok {
+		return value, nil
+	}
+	ref, ok := s.refs[name]
+	if !ok {
+		return "", errors.Errorf("secret %s not declared in secrets", name)
+	}
+	if s.redactOnly {
+		return redactedText, nil
+	}
+	provider, ok := secretProvider(ref.Spec.Provider)
+	if !ok {
+		return "", errors.Errorf("secret %s: unknown provider %q", name, ref.Spec.Provider)
+	}
+	value, err := provider.Secret(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "secret %s", name)
+	}
+	addSecret("secret "+name, value)
+	s.values[name] = value
+	return value, nil
+}
+
+// setActiveSecrets sets the secrets available to the `secret` template function.
+func setActiveSecrets(s *secretStore) {
+	activeSecretsMu.Lock()
+	defer activeSecretsMu.Unlock()
+	activeSecrets = s
+}
+
+// lookupSecret returns the value of a secret of the running Runpfile.
+func lookupSecret(name string) (string, error) {
+	activeSecretsMu.RLock()
+	s := activeSecrets
+	activeSecretsMu.RUnlock()
+	if s == nil {
+		return "", errors.Errorf("secret %s not declared in secrets", name)
+	}
+	return s.get(name)
+}
+
+func aesSecret(ref SecretRef) (string, error) {
+	if ref.Spec.Value == "" {
+		return "", errors.New("value not specified")
+	}
+	if ref.Key == "" {
+		return "", errors.New("encryption key required but not provided, use --key, --key-env or --key-file")
+	}
+	plain, err := DecryptBase64(strings.TrimSpace(ref.Spec.Value), ref.Key)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot decrypt value")
+	}
+	return string(plain), nil
+}
+
+// vaultSecret reads the secret from an encrypted vault: a YAML map of secrets, encrypted
+// using `runp encrypt` with the Runpfile key.
+func vaultSecret(ref SecretRef) (string, error) {
+	if ref.Spec.File == "" {
+		return "", errors.New("file not specified")
+	}
+	if ref.Key == "" {
+		return "", errors.New("encryption key required but not provided, use --key, --key-env or --key-file")
+	}
+	p, err := resolvePath(ref.Spec.File, ref.Root)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot read vault")
+	}
+	plain, err := DecryptBase64(strings.TrimSpace(string(data)), ref.Key)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot decrypt vault %s", p)
+	}
+	vault := map[string]string{}
+	if err := unmarshalStrict(plain, &vault); err != nil {
+		return "", errors.Wrapf(err, "invalid vault %s", p)
+	}
+	value, ok := vault[ref.name()]
+	if !ok {
+		return "", errors.Errorf("%s not found in vault %s", ref.name(), p)
+	}
+	return value, nil
+}
+
+// passSecret returns the first line of the pass entry, as `pass -c` does.
+func passSecret(ref SecretRef) (string, error) {
+	out, err := secretCommandOutput("pass", "show", ref.name())
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(out, "\n", 2)[0], nil
+}
+
+func gpgSecret(ref SecretRef) (string, error) {
+	if ref.Spec.File == "" {
+		return "", errors.New("file not specified")
+	}
+	p, err := resolvePath(ref.Spec.File, ref.Root)
+	if err != nil {
+		return "", err
+	}
+	return secretCommandOutput("gpg", "--quiet", "--batch", "--decrypt", p)
+}
+
+// keyringSecret reads the secret from the OS keyring using the helper command of the OS:
+// secret-tool on Linux, security on macOS. Other helpers can be set using command.
+func keyringSecret(ref SecretRef) (string, error) {
+	if ref.Spec.Command != "" {
+		return commandSecret(ref)
+	}
+	if ref.Spec.Service == "" {
+		return "", errors.New("service not specified")
+	}
+	account := ref.Spec.Account
+	if account == "" {
+		account = ref.name()
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return secretCommandOutput("security", "find-generic-password", "-s", ref.Spec.Service, "-a", account, "-w")
+	case "windows":
+		return "", errors.New("no default keyring helper on Windows, set command")
+	default:
+		return secretCommandOutput("secret-tool", "lookup", "service", ref.Spec.Service, "account", account)
+	}
+}
+
+func envSecret(ref SecretRef) (string, error) {
+	value, ok := os.LookupEnv(ref.name())
+	if !ok {
+		return "", errors.Errorf("environment variable %s not set", ref.name())
+	}
+	return value, nil
+}
+
+func commandSecret(ref SecretRef) (string, error) {
+	if ref.Spec.Command == "" {
+		return "", errors.New("command not specified")
+	}
+	return runVarCommand(ref.Spec.Command, ref.Root)
+}
+
+// secretCommandOutput runs the helper and returns its output without the trailing newlines.
+func secretCommandOutput(name string, args ...string) (string, error) {
+	c := exec.Command(name, args...)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.Errorf("%s failed: %v: %s", name, err, msg)
+		}
+		return "", errors.Errorf("%s failed: %v", name, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
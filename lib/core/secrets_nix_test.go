@@ -0,0 +1,44 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+// +build darwin freebsd linux netbsd openbsd
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeHelper writes an executable script in a directory added to PATH.
+func fakeHelper(t *testing.T, name string, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestSecretHelpers(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	fakeHelper(t, "pass", `[ "$1 $2" = "show app/db" ] && printf 'pass-secret\nuser: app\n'`)
+	fakeHelper(t, "gpg", `[ "$4" = "/tmp/db.gpg" ] && echo gpg-secret`)
+	fakeHelper(t, "secret-tool", `[ "$*" = "lookup service app account db" ] && echo keyring-secret`)
+	fakeHelper(t, "security", `[ "$*" = "find-generic-password -s app -a db -w" ] && echo keyring-secret`)
+	store := newSecretStore(&Runpfile{Root: "/tmp", Secrets: map[string]SecretSpec{
+		"pass":    {Provider: "pass", Name: "app/db"},
+		"gpg":     {Provider: "gpg", File: "db.gpg"},
+		"keyring": {Provider: "keyring", Service: "app", Account: "db"},
+	}})
+	expected := map[string]string{
+		"pass":    "pass-secret",
+		"gpg":     "gpg-secret",
+		"keyring": "keyring-secret",
+	}
+	for name, value := range expected {
+		actual, err := store.get(name)
+		if err != nil || actual != value {
+			t.Errorf("Secret %s, expected <%s> got <%s> %v", name, value, actual, err)
+		}
+	}
+}
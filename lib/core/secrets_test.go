@@ -0,0 +1,177 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretProviders(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	key := "test-key"
+	encrypted, err := EncryptToBase64([]byte("aes-secret"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	vault, err := EncryptToBase64([]byte("db_password: vault-secret\napi: other\n"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "secrets.vault"), []byte(vault+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RUNP_TEST_SECRET", "env-secret")
+
+	rf := &Runpfile{
+		Root:      root,
+		SecretKey: key,
+		Secrets: map[string]SecretSpec{
+			"from_aes":     {Provider: "aes", Value: encrypted},
+			"db_password":  {Provider: "vault", File: "secrets.vault"},
+			"from_env":     {Provider: "env", Name: "RUNP_TEST_SECRET"},
+			"from_command": {Provider: "command", Command: "echo command-secret"},
+			"from_keyring": {Provider: "keyring", Command: "echo keyring-secret"},
+		},
+	}
+	store := newSecretStore(rf)
+	expected := map[string]string{
+		"from_aes":     "aes-secret",
+		"db_password":  "vault-secret",
+		"from_env":     "env-secret",
+		"from_command": "command-secret",
+		"from_keyring": "keyring-secret",
+	}
+	for name, value := range expected {
+		actual, err := store.get(name)
+		if err != nil {
+			t.Errorf("Secret %s, unexpected error %v", name, err)
+			continue
+		}
+		if actual != value {
+			t.Errorf("Secret %s, expected <%s> got <%s>", name, value, actual)
+		}
+	}
+}
+
+func TestSecretErrors(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	encrypted, err := EncryptToBase64([]byte("aes-secret"), "right-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf := &Runpfile{
+		Root:      t.TempDir(),
+		SecretKey: "wrong-key",
+		Secrets: map[string]SecretSpec{
+			"unknown":     {Provider: "unknown"},
+			"wrong_key":   {Provider: "aes", Value: encrypted},
+			"no_vault":    {Provider: "vault", File: "missing.vault"},
+			"no_env":      {Provider: "env", Name: "RUNP_TEST_SECRET_NOT_SET"},
+			"failing_cmd": {Provider: "command", Command: "exit 2"},
+		},
+	}
+	store := newSecretStore(rf)
+	expected := map[string]string{
+		"unknown":     `secret unknown: unknown provider "unknown"`,
+		"wrong_key":   "secret wrong_key: cannot decrypt value",
+		"no_vault":    "secret no_vault: cannot read vault",
+		"no_env":      "environment variable RUNP_TEST_SECRET_NOT_SET not set",
+		"failing_cmd": `command "exit 2" failed`,
+		"undeclared":  "secret undeclared not declared in secrets",
+	}
+	for name, e := range expected {
+		_, err := store.get(name)
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("Secret %s, expected error containing <%s>, got <%v>", name, e, err)
+		}
+	}
+}
+
+func TestRegisterSecretProvider(t *testing.T) {
+	calls := 0
+	RegisterSecretProvider("test", SecretProviderFunc(func(ref SecretRef) (string, error) {
+		calls++
+		if ref.name() != "custom-name" {
+			return "", errors.New("unexpected name " + ref.name())
+		}
+		return "custom-secret", nil
+	}))
+	store := newSecretStore(&Runpfile{Secrets: map[string]SecretSpec{
+		"custom": {Provider: "test", Name: "custom-name"},
+	}})
+	for i := 0; i < 2; i++ {
+		value, err := store.get("custom")
+		if err != nil || value != "custom-secret" {
+			t.Errorf("Expected custom-secret, got <%s> %v", value, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected secret resolved once, got %d calls", calls)
+	}
+}
+
+func TestSecretTemplate(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	t.Setenv("RUNP_TEST_TOKEN", "template-secret")
+	setActiveSecrets(newSecretStore(&Runpfile{Secrets: map[string]SecretSpec{
+		"token": {Provider: "env", Name: "RUNP_TEST_TOKEN"},
+	}}))
+	defer setActiveSecrets(nil)
+	p := newCliPreprocessor(map[string]string{})
+	for _, s := range []string{`Bearer {{secret token}}`, `Bearer {{secret "token"}}`} {
+		actual, err := p.execute(s)
+		if err != nil || actual != "Bearer template-secret" {
+			t.Errorf("%s: expected <Bearer template-secret>, got <%s> %v", s, actual, err)
+		}
+	}
+	if _, err := p.execute(`{{secret other}}`); err == nil || !strings.Contains(err.Error(), "secret other not declared") {
+		t.Errorf("Expected error for undeclared secret, got %v", err)
+	}
+	if actual := redact("token is template-secret"); actual != "token is "+redactedText {
+		t.Errorf("Expected secret redacted, got <%s>", actual)
+	}
+}
+
+func TestShortSecretWarning(t *testing.T) {
+	restoreRedacted(t)
+	logger := &stubLogger{}
+	ConfigureUI(logger, LoggerConfig{})
+	t.Cleanup(func() { ConfigureUI(testLogger, LoggerConfig{}) })
+	t.Setenv("RUNP_TEST_SHORT_SECRET", "pwd")
+	t.Setenv("RUNP_TEST_LONG_SECRET", "password")
+	store := newSecretStore(&Runpfile{Root: t.TempDir(), Secrets: map[string]SecretSpec{
+		"short": {Provider: "env", Name: "RUNP_TEST_SHORT_SECRET"},
+		"long":  {Provider: "env", Name: "RUNP_TEST_LONG_SECRET"},
+	}})
+	for _, name := range []string{"short", "long"} {
+		if _, err := store.get(name); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	expected := "Warning: secret short is shorter than 4 characters and it is not redacted from the output"
+	if len(logger.output) != 1 || logger.output[0] != expected {
+		t.Errorf("Expected warning <%s>, got %q", expected, logger.output)
+	}
+	if actual := redact("pwd password"); actual != "pwd "+redactedText {
+		t.Errorf("Expected long secret only redacted, got <%s>", actual)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	addRedacted("abc")
+	addRedacted("redact-me")
+	addRedacted("redact-me")
+	testCases := map[string]string{
+		"abc is short":            "abc is short",
+		"password redact-me here": "password " + redactedText + " here",
+		"redact-me,redact-me":     redactedText + "," + redactedText,
+	}
+	for in, expected := range testCases {
+		if actual := redact(in); actual != expected {
+			t.Errorf("Redact <%s>, expected <%s> got <%s>", in, expected, actual)
+		}
+	}
+}
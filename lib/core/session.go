@@ -0,0 +1,80 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Session is the state of a running Runpfile, written in ~/.runp/sessions while runp is running.
+type Session struct {
+	Runpfile string
+	Pid      int
+	Started  time.Time
+	// allocated ports by var name
+	Ports map[string]string
+}
+
+// NewSession returns the session of the Runpfile with the values of the allocated ports.
+func NewSession(rf *Runpfile, vars map[string]string) *Session {
+	s := &Session{
+		Runpfile: rf.Root,
+		Pid:      os.Getpid(),
+		Started:  time.Now(),
+		Ports:    map[string]string{},
+	}
+	for _, name := range rf.Ports {
+		s.Ports[name] = vars[name]
+	}
+	return s
+}
+
+// SessionPath returns the path of the session file of the Runpfile in root:
+// a checkout has its own session even if other checkouts of the same project are running.
+func SessionPath(root string) (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(root))
+	return filepath.FromSlash(path.Join(home, `.runp`, `sessions`, hex.EncodeToString(sum[:])[:12]+`.yaml`)), nil
+}
+
+// WriteSession writes the session file and returns its path.
+func WriteSession(s *Session) (string, error) {
+	p, err := SessionPath(s.Runpfile)
+	if err != nil {
+		return "", err
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return "", errors.Wrapf(err, "cannot create sessions directory")
+	}
+	if err := os.WriteFile(p, data, 0600); err != nil {
+		return "", errors.Wrapf(err, "cannot write session file %s", p)
+	}
+	return p, nil
+}
+
+// ReadSession reads a session file.
+func ReadSession(p string) (*Session, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, errors.Wrapf(err, "invalid session file %s", p)
+	}
+	return s, nil
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 protocol values, see RFC 1928.
+const (
+	socks5Version         = 0x05
+	socks5NoAuth          = 0x00
+	socks5NoAcceptable    = 0xff
+	socks5Connect         = 0x01
+	socks5AddrIPv4        = 0x01
+	socks5AddrDomain      = 0x03
+	socks5AddrIPv6        = 0x04
+	socks5Succeeded       = 0x00
+	socks5GeneralFailure  = 0x01
+	socks5HostUnreachable = 0x04
+	socks5CmdNotSupported = 0x07
+	socks5AddrNotSupport  = 0x08
+)
+
+// socks5Handshake reads the greeting and the request of a SOCKS5 client and returns the requested target.
+// Only the CONNECT command without authentication is supported, as for `ssh -D`.
+// On error the client has already been replied.
+func socks5Handshake(conn io.ReadWriter) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(methods, socks5NoAuth) < 0 {
+		conn.Write([]byte{socks5Version, socks5NoAcceptable})
+		return "", fmt.Errorf("SOCKS client requires authentication")
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5NoAuth}); err != nil {
+		return "", err
+	}
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != socks5Connect {
+		socks5Reply(conn, socks5CmdNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+	host, err := socks5ReadHost(conn, request[3])
+	if err != nil {
+		return "", err
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func socks5ReadHost(conn io.ReadWriter, addrType byte) (string, error) {
+	var size int
+	switch addrType {
+	case socks5AddrIPv4:
+		size = net.IPv4len
+	case socks5AddrIPv6:
+		size = net.IPv6len
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		size = int(length[0])
+	default:
+		socks5Reply(conn, socks5AddrNotSupport)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", addrType)
+	}
+	addr := make([]byte, size)
+	if _, err := io.ReadFull(conn, addr); err != nil {
+		return "", err
+	}
+	if addrType == socks5AddrDomain {
+		return string(addr), nil
+	}
+	return net.IP(addr).String(), nil
+}
+
+// socks5Reply sends the reply to a request. The bound address is not meaningful for a tunnel and is always zero.
+func socks5Reply(conn io.Writer, status byte) error {
+	_, err := conn.Write([]byte{socks5Version, status, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
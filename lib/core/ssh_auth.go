@@ -0,0 +1,257 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+
+	"github.com/enr/go-files/files"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// resolveAuthMethods returns the auth methods for the given settings.
+// The SSH client tries each method type once, so identity file, certificate and agent keys
+// are offered by a single public keys method.
+func (p *SSHTunnelProcess) resolveAuthMethods(auth Auth) ([]ssh.AuthMethod, error) {
+	signers, err := p.identitySigners(auth)
+	if err != nil {
+		return nil, err
+	}
+	agentSocket := ""
+	if auth.Agent {
+		agentSocket, err = sshAgentSocket()
+		if err != nil {
+			return nil, err
+		}
+		ui.Debugf("Connecting using SSH agent")
+	}
+	authMethods := []ssh.AuthMethod{}
+	if len(signers) > 0 || agentSocket != "" {
+		authMethods = append(authMethods, publicKeys(signers, agentSocket))
+	}
+	secret, err := p.resolveSecret(auth.Secret, auth.EncryptedSecret, "encrypted_secret")
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" {
+		ui.Debugf("Connecting using secret")
+		authMethods = append(authMethods, ssh.Password(secret))
+	}
+	if auth.KeyboardInteractive {
+		if secret == "" {
+			return nil, errors.New("keyboard_interactive auth requires secret or encrypted_secret")
+		}
+		ui.Debugf("Connecting using keyboard interactive")
+		authMethods = append(authMethods, ssh.KeyboardInteractive(keyboardInteractiveSecret(secret)))
+	}
+	if len(authMethods) == 0 {
+		return nil, errors.New("No authentication method configured")
+	}
+	return authMethods, nil
+}
+
+// resolveSecret returns the plain value, processed with vars, or the decrypted one.
+func (p *SSHTunnelProcess) resolveSecret(plain string, encrypted string, field string) (string, error) {
+	if plain != "" {
+		return newCliPreprocessor(p.vars).process(plain), nil
+	}
+	if encrypted == "" {
+		return "", nil
+	}
+	ui.Debugf("Using encryption key for %s", field)
+	if p.secretKey == "" {
+		return "", errors.Errorf("Encryption key required for %s but not provided", field)
+	}
+	secret, err := DecryptBase64(encrypted, p.secretKey)
+	if err != nil {
+		return "", err
+	}
+	addSecret("SSH "+field, string(secret))
+	return string(secret), nil
+}
+
+// identitySigners returns the signers for the identity file: the certificate one, if any, and the key one.
+func (p *SSHTunnelProcess) identitySigners(auth Auth) ([]ssh.Signer, error) {
+	if auth.IdentityFile == "" {
+		if auth.CertificateFile != "" {
+			return nil, errors.New("certificate_file requires identity_file")
+		}
+		return nil, nil
+	}
+	cliPreprocessor := newCliPreprocessor(p.vars)
+	identityFile, err := resolvePath(cliPreprocessor.process(auth.IdentityFile), "")
+	if err != nil {
+		return nil, err
+	}
+	if !files.IsRegular(identityFile) {
+		return nil, errors.New("Invalid identity file: " + identityFile)
+	}
+	ui.Debugf("Connecting using SSH identity file: %s", identityFile)
+	passphrase, err := p.resolveSecret(auth.Passphrase, auth.EncryptedPassphrase, "encrypted_passphrase")
+	if err != nil {
+		return nil, err
+	}
+	signer, err := privateKeySigner(identityFile, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	certificateFile := identityFile + "-cert.pub"
+	if auth.CertificateFile != "" {
+		certificateFile, err = resolvePath(cliPreprocessor.process(auth.CertificateFile), "")
+		if err != nil {
+			return nil, err
+		}
+	} else if !files.IsRegular(certificateFile) {
+		return []ssh.Signer{signer}, nil
+	}
+	ui.Debugf("Connecting using SSH certificate: %s", certificateFile)
+	certSigner, err := certificateSigner(certificateFile, signer)
+	if err != nil {
+		return nil, err
+	}
+	return []ssh.Signer{certSigner, signer}, nil
+}
+
+func privateKeySigner(file string, passphrase string) (ssh.Signer, error) {
+	buffer, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read SSH private key file: %s", file)
+	}
+	var key ssh.Signer
+	if passphrase != "" {
+		key, err = ssh.ParsePrivateKeyWithPassphrase(buffer, []byte(passphrase))
+	} else {
+		key, err = ssh.ParsePrivateKey(buffer)
+	}
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, errors.Errorf("SSH private key file %s is encrypted: set passphrase or encrypted_passphrase", file)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse SSH private key file: %s", file)
+	}
+	return key, nil
+}
+
+func certificateSigner(file string, signer ssh.Signer) (ssh.Signer, error) {
+	buffer, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read SSH certificate file: %s", file)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(buffer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse SSH certificate file: %s", file)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.Errorf("not an SSH certificate: %s", file)
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// sshAgentSocket returns the socket of the agent listening at SSH_AUTH_SOCK, checking that it accepts
+// connections.
+func sshAgentSocket() (string, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return "", errors.New("SSH agent auth configured but SSH_AUTH_SOCK is not set")
+	}
+	conn, err := dialSSHAgent(socket)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return socket, nil
+}
+
+func dialSSHAgent(socket string) (net.Conn, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot connect to SSH agent at %s", socket)
+	}
+	return conn, nil
+}
+
+// publicKeys offers the signers and the keys of the agent at agentSocket, if set. The agent is connected
+// for each authentication and signature, so that no connection is kept open and a restarted agent is used.
+func publicKeys(signers []ssh.Signer, agentSocket string) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		if agentSocket == "" {
+			return signers, nil
+		}
+		agentSigners, err := agentKeys(agentSocket)
+		if err != nil {
+			ui.Debugf("Error reading keys from SSH agent: %v", err)
+			return signers, nil
+		}
+		return append(append([]ssh.Signer{}, signers...), agentSigners...), nil
+	})
+}
+
+// agentKeys returns signers for the keys of the agent.
+func agentKeys(socket string) ([]ssh.Signer, error) {
+	conn, err := dialSSHAgent(socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, err
+	}
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, k := range keys {
+		signers = append(signers, &agentSigner{socket: socket, key: k})
+	}
+	return signers, nil
+}
+
+// agentSigner signs with a key of the agent, connecting to it for each signature.
+type agentSigner struct {
+	socket string
+	key    ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.key
+}
+
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+// SignWithAlgorithm signs with the algorithm requested by the server, as rsa-sha2-256 for RSA keys.
+func (s *agentSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	conn, err := dialSSHAgent(s.socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		return nil, err
+	}
+	for _, signer := range signers {
+		if !bytes.Equal(signer.PublicKey().Marshal(), s.key.Marshal()) {
+			continue
+		}
+		if as, ok := signer.(ssh.AlgorithmSigner); ok {
+			return as.SignWithAlgorithm(rand, data, algorithm)
+		}
+		return signer.Sign(rand, data)
+	}
+	return nil, errors.New("key removed from SSH agent")
+}
+
+// keyboardInteractiveSecret answers every question with the secret.
+func keyboardInteractiveSecret(secret string) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range answers {
+			answers[i] = secret
+		}
+		return answers, nil
+	}
+}
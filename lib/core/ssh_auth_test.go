@@ -0,0 +1,278 @@
+//go:build darwin || freebsd || linux || netbsd || openbsd
+
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const testAuthUser = `runp`
+
+func generateTestKey(t *testing.T) (ed25519.PrivateKey, ssh.Signer) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, signer
+}
+
+func writeTestKey(t *testing.T, dir string, key ed25519.PrivateKey, passphrase string) string {
+	t.Helper()
+	var block *pem.Block
+	var err error
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startAuthSSHServer starts a server accepting the given public key, certificates signed by ca
+// and keyboard interactive answers equal to secret.
+func startAuthSSHServer(t *testing.T, authorized ssh.PublicKey, ca ssh.PublicKey, secret string) Endpoint {
+	t.Helper()
+	ConfigureUI(testLogger, LoggerConfig{
+		Debug: true,
+		Color: false,
+	})
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return ca != nil && bytes.Equal(auth.Marshal(), ca.Marshal())
+		},
+		UserKeyFallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorized != nil && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("public key rejected")
+		},
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: checker.Authenticate,
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge(conn.User(), "", []string{"Verification code: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if secret != "" && len(answers) == 1 && answers[0] == secret {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong answer")
+		},
+	}
+	_, hostKey := generateTestKey(t)
+	config.AddHostKey(hostKey)
+	listener, host, port := testListener(Endpoint{Host: "localhost"}, t)
+	t.Cleanup(func() { listener.Close() })
+	startSSHServer(listener, config, t)
+	return Endpoint{Host: host, Port: port}
+}
+
+func assertSSHAuth(t *testing.T, p *SSHTunnelProcess) {
+	t.Helper()
+	config, err := p.resolveSSHCommandConfiguration()
+	if err != nil {
+		t.Fatalf("Unexpected error resolving configuration: %v", err)
+	}
+	config.Timeout = 5 * time.Second
+	client, err := ssh.Dial("tcp", p.Jump.String(), config)
+	if err != nil {
+		t.Fatalf("Expected successful authentication, got %v", err)
+	}
+	client.Close()
+}
+
+func TestSSHAuthEncryptedIdentityFile(t *testing.T) {
+	key, signer := generateTestKey(t)
+	jump := startAuthSSHServer(t, signer.PublicKey(), nil, "")
+	identityFile := writeTestKey(t, t.TempDir(), key, "s3cr3t")
+
+	t.Run("passphrase", func(t *testing.T) {
+		assertSSHAuth(t, &SSHTunnelProcess{
+			User:                  testAuthUser,
+			Auth:                  Auth{IdentityFile: identityFile, Passphrase: "s3cr3t"},
+			Jump:                  jump,
+			InsecureIgnoreHostKey: true,
+		})
+	})
+
+	t.Run("encrypted passphrase", func(t *testing.T) {
+		secretKey := "runp-key"
+		encrypted, err := EncryptToBase64([]byte("s3cr3t"), secretKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSSHAuth(t, &SSHTunnelProcess{
+			User:                  testAuthUser,
+			Auth:                  Auth{IdentityFile: identityFile, EncryptedPassphrase: encrypted},
+			Jump:                  jump,
+			InsecureIgnoreHostKey: true,
+			secretKey:             secretKey,
+		})
+	})
+
+	t.Run("missing passphrase", func(t *testing.T) {
+		p := &SSHTunnelProcess{
+			User:                  testAuthUser,
+			Auth:                  Auth{IdentityFile: identityFile},
+			Jump:                  jump,
+			InsecureIgnoreHostKey: true,
+		}
+		_, err := p.resolveSSHCommandConfiguration()
+		if err == nil || !strings.Contains(err.Error(), "is encrypted") {
+			t.Errorf("Expected error for encrypted identity file without passphrase, got %v", err)
+		}
+	})
+}
+
+func TestSSHAuthCertificate(t *testing.T) {
+	_, caSigner := generateTestKey(t)
+	key, signer := generateTestKey(t)
+	// only certificates are accepted
+	jump := startAuthSSHServer(t, nil, caSigner.PublicKey(), "")
+
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "runp-test",
+		ValidPrincipals: []string{testAuthUser},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	identityFile := writeTestKey(t, dir, key, "")
+
+	t.Run("certificate file", func(t *testing.T) {
+		certificateFile := filepath.Join(dir, "user-cert")
+		if err := os.WriteFile(certificateFile, ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+			t.Fatal(err)
+		}
+		assertSSHAuth(t, &SSHTunnelProcess{
+			User:                  testAuthUser,
+			Auth:                  Auth{IdentityFile: identityFile, CertificateFile: certificateFile},
+			Jump:                  jump,
+			InsecureIgnoreHostKey: true,
+		})
+	})
+
+	t.Run("default certificate file", func(t *testing.T) {
+		if err := os.WriteFile(identityFile+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+			t.Fatal(err)
+		}
+		assertSSHAuth(t, &SSHTunnelProcess{
+			User:                  testAuthUser,
+			Auth:                  Auth{IdentityFile: identityFile},
+			Jump:                  jump,
+			InsecureIgnoreHostKey: true,
+		})
+	})
+}
+
+func TestSSHAuthAgent(t *testing.T) {
+	key, signer := generateTestKey(t)
+	jump := startAuthSSHServer(t, signer.PublicKey(), nil, "")
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var open, accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&open, 1)
+			atomic.AddInt32(&accepted, 1)
+			go func() {
+				defer atomic.AddInt32(&open, -1)
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	for i := 0; i < 3; i++ {
+		assertSSHAuth(t, &SSHTunnelProcess{
+			User:                  testAuthUser,
+			Auth:                  Auth{Agent: true},
+			Jump:                  jump,
+			InsecureIgnoreHostKey: true,
+		})
+	}
+	// the agent is connected for each authentication and the connections are closed
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&open) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&open); n != 0 {
+		t.Errorf("Expected agent connections closed, got %d open of %d", n, atomic.LoadInt32(&accepted))
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	p := &SSHTunnelProcess{
+		User:                  testAuthUser,
+		Auth:                  Auth{Agent: true},
+		Jump:                  jump,
+		InsecureIgnoreHostKey: true,
+	}
+	if _, err := p.resolveSSHCommandConfiguration(); err == nil || !strings.Contains(err.Error(), "SSH_AUTH_SOCK") {
+		t.Errorf("Expected error for agent without SSH_AUTH_SOCK, got %v", err)
+	}
+}
+
+func TestSSHAuthKeyboardInteractive(t *testing.T) {
+	jump := startAuthSSHServer(t, nil, nil, "123456")
+
+	assertSSHAuth(t, &SSHTunnelProcess{
+		User:                  testAuthUser,
+		Auth:                  Auth{Secret: "123456", KeyboardInteractive: true},
+		Jump:                  jump,
+		InsecureIgnoreHostKey: true,
+	})
+
+	p := &SSHTunnelProcess{
+		User:                  testAuthUser,
+		Auth:                  Auth{KeyboardInteractive: true},
+		Jump:                  jump,
+		InsecureIgnoreHostKey: true,
+	}
+	if _, err := p.resolveSSHCommandConfiguration(); err == nil {
+		t.Errorf("Expected error for keyboard interactive without secret")
+	}
+}
@@ -0,0 +1,297 @@
+package core
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultSSHConfigFile = "~/.ssh/config"
+
+// sshConfigHost is the subset of the OpenSSH client settings used by SSH tunnels.
+type sshConfigHost struct {
+	HostName     string
+	User         string
+	Port         int
+	IdentityFile string
+	ProxyJump    string
+}
+
+// sshConfig reads the settings of a host from an OpenSSH client configuration file.
+// As OpenSSH does, the first value found for a setting wins. Match blocks are not supported.
+type sshConfig struct {
+	file string
+}
+
+// lookup returns the settings of the given host alias.
+func (c *sshConfig) lookup(alias string) (sshConfigHost, error) {
+	settings := map[string]string{}
+	if err := c.parseFile(c.file, alias, settings, true, 0); err != nil {
+		return sshConfigHost{}, err
+	}
+	host := sshConfigHost{
+		HostName:     settings["hostname"],
+		User:         settings["user"],
+		IdentityFile: settings["identityfile"],
+		ProxyJump:    settings["proxyjump"],
+	}
+	if p, ok := settings["port"]; ok {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return host, errors.Errorf("Invalid port %s for host %s in %s", p, alias, c.file)
+		}
+		host.Port = port
+	}
+	host.HostName = strings.ReplaceAll(host.HostName, "%h", alias)
+	host.IdentityFile = strings.ReplaceAll(host.IdentityFile, "%d", "~")
+	return host, nil
+}
+
+func (c *sshConfig) parseFile(file string, alias string, settings map[string]string, active bool, depth int) error {
+	if depth > 16 {
+		return errors.Errorf("Too many nested includes in %s", file)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrapf(err, "cannot read SSH config %s", file)
+	}
+	defer f.Close()
+	return c.parse(f, alias, settings, active, depth)
+}
+
+func (c *sshConfig) parse(r io.Reader, alias string, settings map[string]string, active bool, depth int) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		keyword, args := splitSSHConfigLine(scanner.Text())
+		switch keyword {
+		case "":
+			continue
+		case "host":
+			active = matchSSHConfigHost(alias, args)
+		case "match":
+			ui.Debugf("SSH config Match blocks are not supported, skipping")
+			active = false
+		case "include":
+			if !active {
+				continue
+			}
+			if err := c.include(args, alias, settings, depth); err != nil {
+				return err
+			}
+		default:
+			if _, found := settings[keyword]; active && !found && len(args) > 0 {
+				settings[keyword] = strings.Join(args, " ")
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (c *sshConfig) include(patterns []string, alias string, settings map[string]string, depth int) error {
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "~") && !filepath.IsAbs(pattern) {
+			pattern = path.Join(filepath.ToSlash(filepath.Dir(c.file)), pattern)
+		}
+		resolved, err := resolvePath(pattern, "")
+		if err != nil {
+			return err
+		}
+		matches, err := filepath.Glob(resolved)
+		if err != nil {
+			return errors.Wrapf(err, "invalid SSH config include %s", pattern)
+		}
+		for _, m := range matches {
+			if err := c.parseFile(m, alias, settings, true, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitSSHConfigLine returns the lowercase keyword and the arguments, unquoted.
+func splitSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+	args := []string{}
+	var sb strings.Builder
+	quoted := false
+	for _, r := range strings.TrimSpace(rest) {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if sb.Len() > 0 {
+				args = append(args, sb.String())
+				sb.Reset()
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	if sb.Len() > 0 {
+		args = append(args, sb.String())
+	}
+	return keyword, args
+}
+
+// matchSSHConfigHost returns true if alias matches one of the patterns and none of the negated ones.
+func matchSSHConfigHost(alias string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, err := path.Match(strings.TrimPrefix(pattern, "!"), alias)
+		if err != nil || !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// parseProxyJump parses a ProxyJump entry in the form [ssh://][user@]host[:port].
+func parseProxyJump(entry string) (user string, host string, port int, err error) {
+	entry = strings.TrimPrefix(strings.TrimSpace(entry), "ssh://")
+	if i := strings.LastIndex(entry, "@"); i >= 0 {
+		user = entry[:i]
+		entry = entry[i+1:]
+	}
+	host = entry
+	if i := strings.LastIndex(entry, ":"); i >= 0 && !strings.HasSuffix(entry, "]") {
+		host = entry[:i]
+		port, err = strconv.Atoi(entry[i+1:])
+		if err != nil {
+			return "", "", 0, errors.Errorf("Invalid ProxyJump port in %s", entry)
+		}
+	}
+	host = strings.Trim(host, "[]")
+	return user, host, port, nil
+}
+
+// applySSHConfig fills the settings not specified in the unit using the SSH config host.
+func (p *SSHTunnelProcess) applySSHConfig() error {
+	if p.SSHConfigHost == "" || p.sshConfigApplied {
+		return p.sshConfigErr
+	}
+	p.sshConfigApplied = true
+	p.sshConfigErr = p.doApplySSHConfig()
+	return p.sshConfigErr
+}
+
+func (p *SSHTunnelProcess) sshConfigFile() (string, error) {
+	file := p.SSHConfigFile
+	if file == "" {
+		file = defaultSSHConfigFile
+	}
+	// relative paths are resolved from the unit working directory
+	return resolvePath(newCliPreprocessor(p.vars).process(file), p.WorkingDir)
+}
+
+func (p *SSHTunnelProcess) doApplySSHConfig() error {
+	file, err := p.sshConfigFile()
+	if err != nil {
+		return err
+	}
+	config := &sshConfig{file: file}
+	alias := newCliPreprocessor(p.vars).process(p.SSHConfigHost)
+	host, err := config.lookup(alias)
+	if err != nil {
+		return err
+	}
+	ui.Debugf("SSH config host %s from %s: %+v", alias, file, host)
+	if p.User == "" {
+		p.User = host.User
+	}
+	if p.Jump.Host == "" {
+		p.Jump.Host = alias
+		if host.HostName != "" {
+			p.Jump.Host = host.HostName
+		}
+	}
+	if p.Jump.Port == 0 {
+		p.Jump.Port = 22
+		if host.Port != 0 {
+			p.Jump.Port = host.Port
+		}
+	}
+	if p.Auth.IdentityFile == "" {
+		p.Auth.IdentityFile = host.IdentityFile
+	}
+	if len(p.Hops) > 0 || host.ProxyJump == "" || strings.EqualFold(host.ProxyJump, "none") {
+		return nil
+	}
+	hops := []SSHHop{}
+	for _, entry := range strings.Split(host.ProxyJump, ",") {
+		hop, err := proxyJumpHop(config, entry)
+		if err != nil {
+			return err
+		}
+		hops = append(hops, hop)
+	}
+	p.Hops = append(hops, SSHHop{Host: p.Jump.Host, Port: p.Jump.Port})
+	return nil
+}
+
+// proxyJumpHop returns the hop for a ProxyJump entry, using the SSH config settings if the host is an alias.
+func proxyJumpHop(config *sshConfig, entry string) (SSHHop, error) {
+	user, alias, port, err := parseProxyJump(entry)
+	if err != nil {
+		return SSHHop{}, err
+	}
+	host, err := config.lookup(alias)
+	if err != nil {
+		return SSHHop{}, err
+	}
+	hop := SSHHop{Host: alias, Port: port, User: user}
+	if host.HostName != "" {
+		hop.Host = host.HostName
+	}
+	if hop.Port == 0 {
+		hop.Port = 22
+		if host.Port != 0 {
+			hop.Port = host.Port
+		}
+	}
+	if hop.User == "" {
+		hop.User = host.User
+	}
+	if host.IdentityFile != "" {
+		hop.Auth = Auth{IdentityFile: host.IdentityFile}
+	}
+	return hop, nil
+}
+
+// Details returns the resolved connection settings.
+func (p *SSHTunnelProcess) Details() []string {
+	details := []string{}
+	if p.SSHConfigHost != "" {
+		file, _ := p.sshConfigFile()
+		details = append(details, "ssh config host: "+p.SSHConfigHost+" ("+file+")")
+		if err := p.applySSHConfig(); err != nil {
+			details = append(details, "ssh config error: "+err.Error())
+		}
+	}
+	details = append(details, "user: "+p.User, "jump: "+p.JumpDescription())
+	if p.Auth.IdentityFile != "" {
+		details = append(details, "identity file: "+p.Auth.IdentityFile)
+	}
+	return details
+}
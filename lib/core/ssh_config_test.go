@@ -0,0 +1,122 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSSHConfigFile = "../../testdata/ssh_config/config"
+
+func TestSSHConfigLookup(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{
+		Debug: true,
+		Color: false,
+	})
+	config := &sshConfig{file: testSSHConfigFile}
+
+	testCases := []struct {
+		alias    string
+		expected sshConfigHost
+	}{
+		{"bastion-prod", sshConfigHost{HostName: "bastion.example.com", User: "deploy", Port: 2222, IdentityFile: "~/.ssh/bastion_ed25519"}},
+		{"internal-db", sshConfigHost{HostName: "db-jump.internal", User: "app", Port: 22, IdentityFile: "~/.ssh/internal key", ProxyJump: "bastion-prod,ops@relay.example.com:2200"}},
+		{"other", sshConfigHost{User: "nobody", Port: 22}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.alias, func(t *testing.T) {
+			host, err := config.lookup(tc.alias)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if host != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, host)
+			}
+		})
+	}
+
+	missing := &sshConfig{file: "../../testdata/ssh_config/not-found"}
+	if _, err := missing.lookup("bastion-prod"); err == nil {
+		t.Errorf("Expected error for missing SSH config file")
+	}
+}
+
+func TestSSHConfigHostPatterns(t *testing.T) {
+	testCases := []struct {
+		alias    string
+		patterns []string
+		expected bool
+	}{
+		{"bastion", []string{"bastion"}, true},
+		{"bastion", []string{"bast?on"}, true},
+		{"internal-db", []string{"internal-*", "!internal-db"}, false},
+		{"internal-web", []string{"internal-*", "!internal-db"}, true},
+		{"other", []string{"bastion", "internal-*"}, false},
+	}
+	for _, tc := range testCases {
+		if got := matchSSHConfigHost(tc.alias, tc.patterns); got != tc.expected {
+			t.Errorf("Match %s with %v: expected %v, got %v", tc.alias, tc.patterns, tc.expected, got)
+		}
+	}
+}
+
+func TestSSHTunnelProcess_ApplySSHConfig(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{
+		Debug: true,
+		Color: false,
+	})
+
+	t.Run("fill settings", func(t *testing.T) {
+		p := &SSHTunnelProcess{
+			SSHConfigHost: "bastion-prod",
+			SSHConfigFile: testSSHConfigFile,
+		}
+		if err := p.applySSHConfig(); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if p.User != "deploy" || p.Jump.Host != "bastion.example.com" || p.Jump.Port != 2222 {
+			t.Errorf("Unexpected settings user=%s jump=%s", p.User, p.Jump.String())
+		}
+		if p.Auth.IdentityFile != "~/.ssh/bastion_ed25519" {
+			t.Errorf("Unexpected identity file %s", p.Auth.IdentityFile)
+		}
+	})
+
+	t.Run("explicit fields win", func(t *testing.T) {
+		p := &SSHTunnelProcess{
+			SSHConfigHost: "bastion-prod",
+			SSHConfigFile: testSSHConfigFile,
+			User:          "runp",
+			Jump:          Endpoint{Port: 22},
+			Auth:          Auth{IdentityFile: "~/.ssh/runp"},
+		}
+		if err := p.applySSHConfig(); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if p.User != "runp" || p.Jump.String() != "bastion.example.com:22" || p.Auth.IdentityFile != "~/.ssh/runp" {
+			t.Errorf("Unexpected settings user=%s jump=%s identity=%s", p.User, p.Jump.String(), p.Auth.IdentityFile)
+		}
+	})
+
+	t.Run("proxy jump", func(t *testing.T) {
+		p := &SSHTunnelProcess{
+			SSHConfigHost: "internal-db",
+			SSHConfigFile: testSSHConfigFile,
+		}
+		expected := "bastion.example.com:2222 -> relay.example.com:2200 -> db-jump.internal:22"
+		if got := p.JumpDescription(); got != expected {
+			t.Errorf("Expected jump '%s', got '%s'", expected, got)
+		}
+		if p.Hops[0].User != "deploy" || p.Hops[0].Auth.IdentityFile != "~/.ssh/bastion_ed25519" {
+			t.Errorf("Unexpected first hop %+v", p.Hops[0])
+		}
+		if p.Hops[1].User != "ops" {
+			t.Errorf("Expected user from ProxyJump entry, got %+v", p.Hops[1])
+		}
+		details := strings.Join(p.Details(), "\n")
+		for _, d := range []string{"ssh config host: internal-db", "user: app", "jump: " + expected} {
+			if !strings.Contains(details, d) {
+				t.Errorf("Expected <%s> in details:\n%s", d, details)
+			}
+		}
+	})
+}
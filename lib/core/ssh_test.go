@@ -63,11 +63,19 @@ func startSSHServer(listener net.Listener, config *ssh.ServerConfig, t *testing.
 
 	r := easyssh.NewGlobalMultipleRequestsMux()
 
-	r.HandleRequestFunc(easyssh.RemoteForwardRequest, easyssh.TCPIPForwardRequest)
+	r.HandleRequestFunc(easyssh.RemoteForwardRequest, remoteForwardRequest)
+	// easyssh panics on unhandled global requests: listeners are closed with the connection
+	r.HandleRequestFunc(easyssh.CancelRemoteForwardRequest, func(req *ssh.Request, sshConn ssh.Conn) {
+		req.Reply(true, nil)
+	})
+	r.HandleRequestFunc("keepalive@openssh.com", func(req *ssh.Request, sshConn ssh.Conn) {
+		req.Reply(true, nil)
+	})
 	channelHandler.HandleChannel(easyssh.SessionRequest, easyssh.SessionHandler())
 
 	channelHandler.HandleChannel(easyssh.DirectForwardRequest, easyssh.DirectPortForwardHandler())
 	handler.MultipleChannelsHandler = channelHandler
+	handler.GlobalMultipleRequestsHandler = r
 
 	s.Handler = handler
 	go func() {
@@ -75,6 +83,54 @@ func startSSHServer(listener net.Listener, config *ssh.ServerConfig, t *testing.
 	}()
 }
 
+// remoteForwardRequest serves "tcpip-forward" requests.
+// easyssh.TCPIPForwardRequest blocks discarding channel requests before copying data.
+func remoteForwardRequest(req *ssh.Request, sshConn ssh.Conn) {
+	var payload struct {
+		Host string
+		Port uint32
+	}
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		req.Reply(false, nil)
+		return
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		req.Reply(false, nil)
+		return
+	}
+	req.Reply(true, nil)
+	go func() {
+		sshConn.Wait()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			host, port, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			originPort, _ := strconv.Atoi(port)
+			ch, reqs, err := sshConn.OpenChannel(easyssh.ForwardedTCPReturnRequest, ssh.Marshal(&struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}{payload.Host, payload.Port, host, uint32(originPort)}))
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			easyssh.CopyReadWriters(conn, ch, func() {
+				ch.Close()
+				conn.Close()
+			})
+		}(conn)
+	}
+}
+
 func httpServer(e Endpoint, stubResponse string, t *testing.T) *http.Server {
 	ts := &http.Server{
 		Addr: e.String(),
@@ -4,12 +4,15 @@ package core
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -142,3 +145,532 @@ func TestSSHTunnelHappyPath(t *testing.T) {
 		}
 	}
 }
+
+func TestSSHTunnelForwards(t *testing.T) {
+
+	ConfigureUI(testLogger, LoggerConfig{
+		Debug: true,
+		Color: false,
+	})
+
+	sshUser := `test`
+	sshSecret := `test`
+
+	sshKey, err := filepath.Abs(`../../testdata/keys/runp`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jump := Endpoint{
+		Host: "localhost",
+		Port: 6667,
+	}
+	jumpListener, _, _ := testListener(jump, t)
+	defer jumpListener.Close()
+	sshConfig, err := sshServerConfig(sshUser, sshSecret, sshKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startSSHServer(jumpListener, sshConfig, t)
+
+	targets := map[Endpoint]string{
+		{Host: "localhost", Port: 8091}: `target-one`,
+		{Host: "localhost", Port: 8092}: `target-two`,
+		{Host: "localhost", Port: 8093}: `laptop`,
+	}
+	for e, response := range targets {
+		https := httpServer(e, response, t)
+		defer https.Close()
+	}
+
+	knownHostsFile := knownHostsFileForTest(t,
+		fmt.Sprintf("[%s]:%d", jump.Host, jump.Port),
+		"../../testdata/keys/runp.pub",
+	)
+
+	tunnel := &SSHTunnelProcess{
+		User: sshUser,
+		Auth: Auth{
+			Secret: sshSecret,
+		},
+		Jump: jump,
+		Forwards: []Forward{
+			{Local: Endpoint{Port: 3101}, Target: Endpoint{Host: "localhost", Port: 8091}},
+			{Local: Endpoint{Port: 3102}, Target: Endpoint{Host: "localhost", Port: 8092}},
+			{Local: Endpoint{Host: "localhost", Port: 8093}, Remote: Endpoint{Host: "localhost", Port: 3103}},
+		},
+		KnownHostsFile: knownHostsFile,
+	}
+
+	cmd, err := tunnel.StartCommand()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer cmd.Stop()
+
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("Unexpected error %v", err)
+		}
+	}()
+
+	expectations := map[string]string{
+		`localhost:3101`: `target-one`,
+		`localhost:3102`: `target-two`,
+		`localhost:3103`: `laptop`,
+	}
+	for address, expected := range expectations {
+		body, err := getWithRetry(fmt.Sprintf(`http://%s`, address))
+		if err != nil {
+			t.Errorf("Error calling %s: %v", address, err)
+			continue
+		}
+		if body != expected {
+			t.Errorf("Response from %s, expected: <%s> got <%s>", address, expected, body)
+		}
+	}
+
+	wrapper := cmd.(*SSHTunnelCommandWrapper)
+	if wrapper.chain == nil {
+		t.Errorf("Expected SSH connection to jump server")
+	}
+}
+
+func TestSSHTunnelForwardsMisconfiguration(t *testing.T) {
+	testCases := []struct {
+		name     string
+		forwards []Forward
+		expected string
+	}{
+		{"no local port", []Forward{{Target: Endpoint{Port: 80}}}, `Local endpoint misconfiguration`},
+		{"no target port", []Forward{{Local: Endpoint{Port: 8080}}}, `Target endpoint misconfiguration`},
+		{"target and remote", []Forward{{Local: Endpoint{Port: 8080}, Target: Endpoint{Port: 80}, Remote: Endpoint{Port: 9090}}}, `both target and remote`},
+		{"dynamic with target", []Forward{{Local: Endpoint{Port: 1080}, Target: Endpoint{Port: 80}, Dynamic: true}}, `dynamic forward with target or remote`},
+		{"dynamic without local port", []Forward{{Dynamic: true}}, `Local endpoint misconfiguration`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tunnel := &SSHTunnelProcess{
+				User:                  `test`,
+				Auth:                  Auth{Secret: `test`},
+				Jump:                  Endpoint{Host: "localhost", Port: 22},
+				Forwards:              tc.forwards,
+				InsecureIgnoreHostKey: true,
+			}
+			_, err := tunnel.StartCommand()
+			if err == nil {
+				t.Fatalf("Expected error for %v", tc.forwards)
+			}
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing <%s>, got <%v>", tc.expected, err)
+			}
+		})
+	}
+}
+
+// getWithRetry calls url until it responds, giving time to the servers to start.
+func getWithRetry(url string) (string, error) {
+	return getWithClientRetry(&http.Client{
+		Timeout: time.Second * 2,
+	}, url)
+}
+
+func getWithClientRetry(client *http.Client, url string) (string, error) {
+	var err error
+	for i := 0; i < 20; i++ {
+		var resp *http.Response
+		resp, err = client.Get(url)
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return strings.TrimSpace(string(body)), nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return "", err
+}
+
+func TestSSHTunnelDynamicForward(t *testing.T) {
+
+	ConfigureUI(testLogger, LoggerConfig{
+		Debug: true,
+		Color: false,
+	})
+
+	sshKey, err := filepath.Abs(`../../testdata/keys/runp`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jump := Endpoint{Host: "localhost", Port: 6671}
+	jumpListener, _, _ := testListener(jump, t)
+	defer jumpListener.Close()
+	sshConfig, err := sshServerConfig(`test`, `test`, sshKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startSSHServer(jumpListener, sshConfig, t)
+
+	for e, response := range map[Endpoint]string{
+		{Host: "localhost", Port: 8096}: `dashboard-one`,
+		{Host: "localhost", Port: 8097}: `dashboard-two`,
+	} {
+		https := httpServer(e, response, t)
+		defer https.Close()
+	}
+
+	tunnel := &SSHTunnelProcess{
+		User:                  `test`,
+		Auth:                  Auth{Secret: `test`},
+		Jump:                  jump,
+		Forwards:              []Forward{{Local: Endpoint{Host: "localhost", Port: 3106}, Dynamic: true}},
+		InsecureIgnoreHostKey: true,
+	}
+	if d := tunnel.ForwardsDescription(); d != `localhost:3106 -> localhost:6671 (SOCKS5)` {
+		t.Errorf("Unexpected forwards description %s", d)
+	}
+	cmd, err := tunnel.StartCommand()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	stdout := &syncBuffer{}
+	cmd.Stdout(stdout)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer cmd.Stop()
+	go cmd.Wait()
+
+	proxy, _ := url.Parse(`socks5://localhost:3106`)
+	client := &http.Client{
+		Timeout:   time.Second * 2,
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxy)},
+	}
+	for address, expected := range map[string]string{
+		`localhost:8096`: `dashboard-one`,
+		`localhost:8097`: `dashboard-two`,
+	} {
+		body, err := getWithClientRetry(client, fmt.Sprintf(`http://%s`, address))
+		if err != nil {
+			t.Errorf("Error calling %s through SOCKS5 proxy: %v", address, err)
+			continue
+		}
+		if body != expected {
+			t.Errorf("Response from %s, expected: <%s> got <%s>", address, expected, body)
+		}
+		if !strings.Contains(stdout.String(), "to "+address) {
+			t.Errorf("Expected connection to %s logged, got:\n%s", address, stdout.String())
+		}
+	}
+
+	if _, err := client.Get(`http://localhost:8098`); err == nil {
+		t.Errorf("Expected error calling unreachable target through SOCKS5 proxy")
+	}
+}
+
+func TestSSHTunnelHops(t *testing.T) {
+
+	ConfigureUI(testLogger, LoggerConfig{
+		Debug: true,
+		Color: false,
+	})
+
+	sshKey, err := filepath.Abs(`../../testdata/keys/runp`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bastion := Endpoint{Host: "localhost", Port: 6668}
+	internal := Endpoint{Host: "localhost", Port: 6669}
+	for _, s := range []struct {
+		e      Endpoint
+		user   string
+		secret string
+	}{
+		{bastion, `bastion-user`, `bastion-secret`},
+		{internal, `internal-user`, `internal-secret`},
+	} {
+		listener, _, _ := testListener(s.e, t)
+		defer listener.Close()
+		sshConfig, err := sshServerConfig(s.user, s.secret, sshKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		startSSHServer(listener, sshConfig, t)
+	}
+
+	target := Endpoint{Host: "localhost", Port: 8094}
+	https := httpServer(target, `behind-hops`, t)
+	defer https.Close()
+
+	newTunnel := func(internalSecret string) *SSHTunnelProcess {
+		return &SSHTunnelProcess{
+			User: `bastion-user`,
+			Auth: Auth{
+				Secret: `bastion-secret`,
+			},
+			Hops: []SSHHop{
+				{Host: bastion.Host, Port: bastion.Port},
+				{Host: internal.Host, Port: internal.Port, User: `internal-user`, Auth: Auth{Secret: internalSecret}},
+			},
+			Local:                 Endpoint{Port: 3104},
+			Target:                target,
+			InsecureIgnoreHostKey: true,
+		}
+	}
+
+	t.Run("chain", func(t *testing.T) {
+		cmd, err := newTunnel(`internal-secret`).StartCommand()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		defer cmd.Stop()
+		go cmd.Wait()
+
+		body, err := getWithRetry(`http://localhost:3104`)
+		if err != nil {
+			t.Fatalf("Error calling local: %v", err)
+		}
+		if body != `behind-hops` {
+			t.Errorf("Response from local, expected: <behind-hops> got <%s>", body)
+		}
+		wrapper := cmd.(*SSHTunnelCommandWrapper)
+		if wrapper.chain == nil || len(wrapper.chain.clients) != 2 {
+			t.Errorf("Expected SSH connections to 2 hops, got %v", wrapper.chain)
+		}
+	})
+
+	t.Run("failing hop", func(t *testing.T) {
+		tunnel := newTunnel(`wrong-secret`)
+		tunnel.TestCommand = `true`
+		_, err := tunnel.executeCmd(tunnel.TestCommand)
+		if err == nil {
+			t.Fatalf("Expected error connecting with wrong secret")
+		}
+		expected := fmt.Sprintf("hop 2/2 (%s)", internal.String())
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error naming <%s>, got <%v>", expected, err)
+		}
+	})
+}
+
+func TestSSHTunnelReconnect(t *testing.T) {
+
+	ConfigureUI(testLogger, LoggerConfig{
+		Debug: true,
+		Color: false,
+	})
+
+	sshKey, err := filepath.Abs(`../../testdata/keys/runp`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jump := Endpoint{Host: "localhost", Port: 6670}
+	jumpListener, _, _ := testListener(jump, t)
+	defer jumpListener.Close()
+	sshConfig, err := sshServerConfig(`test`, `test`, sshKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startSSHServer(jumpListener, sshConfig, t)
+
+	target := Endpoint{Host: "localhost", Port: 8095}
+	https := httpServer(target, `reconnected`, t)
+	defer https.Close()
+
+	tunnel := &SSHTunnelProcess{
+		User:                  `test`,
+		Auth:                  Auth{Secret: `test`},
+		Jump:                  jump,
+		Local:                 Endpoint{Port: 3105},
+		Target:                target,
+		KeepaliveInterval:     `50ms`,
+		InsecureIgnoreHostKey: true,
+	}
+	cmd, err := tunnel.StartCommand()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	wrapper := cmd.(*SSHTunnelCommandWrapper)
+	wrapper.minBackoff = 10 * time.Millisecond
+	out := &syncBuffer{}
+	cmd.Stdout(out)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer cmd.Stop()
+	go cmd.Wait()
+
+	if _, err := getWithRetry(`http://localhost:3105`); err != nil {
+		t.Fatalf("Error calling local: %v", err)
+	}
+	// keepalive requests are answered, the connection is kept
+	time.Sleep(200 * time.Millisecond)
+	if strings.Contains(out.String(), "lost") {
+		t.Fatalf("Unexpected connection loss:\n%s", out.String())
+	}
+
+	// drop the connection
+	wrapper.mu.Lock()
+	first := wrapper.chain
+	first.clients[0].Close()
+	wrapper.mu.Unlock()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		wrapper.mu.Lock()
+		reconnected := wrapper.chain != nil && wrapper.chain != first
+		wrapper.mu.Unlock()
+		if reconnected {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	body, err := getWithRetry(`http://localhost:3105`)
+	if err != nil {
+		t.Fatalf("Error calling local after reconnect: %v", err)
+	}
+	if body != `reconnected` {
+		t.Errorf("Response from local, expected: <reconnected> got <%s>", body)
+	}
+	log := out.String()
+	for _, expected := range []string{`lost`, `Connected to jump server localhost:6670`} {
+		if !strings.Contains(log, expected) {
+			t.Errorf("Expected <%s> in tunnel log:\n%s", expected, log)
+		}
+	}
+	if strings.Count(log, `Connected to jump server`) < 2 {
+		t.Errorf("Expected reconnection in tunnel log:\n%s", log)
+	}
+}
+
+func TestSSHTunnelStopWhileDialing(t *testing.T) {
+
+	ConfigureUI(testLogger, LoggerConfig{
+		Debug: true,
+		Color: false,
+	})
+
+	// the jump server accepts connections but never completes the handshake
+	jump := Endpoint{Host: "localhost", Port: 6672}
+	jumpListener, _, _ := testListener(jump, t)
+	defer jumpListener.Close()
+	go func() {
+		for {
+			conn, err := jumpListener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tunnel := &SSHTunnelProcess{
+		User:                  `test`,
+		Auth:                  Auth{Secret: `test`},
+		Jump:                  jump,
+		Local:                 Endpoint{Port: 3107},
+		Target:                Endpoint{Host: "localhost", Port: 8098},
+		InsecureIgnoreHostKey: true,
+	}
+	cmd, err := tunnel.StartCommand()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	wrapper := cmd.(*SSHTunnelCommandWrapper)
+	wrapper.hops[0].config.Timeout = 300 * time.Millisecond
+	wrapper.minBackoff = time.Hour
+	out := &syncBuffer{}
+	cmd.Stdout(out)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	go cmd.Wait()
+
+	// the handshake times out
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) && !strings.Contains(out.String(), "timed out") {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !strings.Contains(out.String(), "SSH handshake with localhost:6672 timed out after 300ms") {
+		t.Errorf("Expected handshake timeout in tunnel log:\n%s", out.String())
+	}
+
+	// stop does not wait for a connection in progress
+	wrapper.hops[0].config.Timeout = time.Hour
+	dialed := make(chan error, 1)
+	go func() {
+		_, err := wrapper.sshClient()
+		dialed <- err
+	}()
+	for time.Now().Before(deadline) {
+		wrapper.mu.Lock()
+		dialing := wrapper.dialing != nil
+		wrapper.mu.Unlock()
+		if dialing {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- cmd.Stop()
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Stop blocked by the connection in progress")
+	}
+	if !wrapper.isStopped() {
+		t.Errorf("Expected tunnel stopped")
+	}
+	// closing the connections held by the jump server ends the connection in progress
+	jumpListener.Close()
+	select {
+	case err := <-dialed:
+		if err == nil {
+			t.Errorf("Expected connection to fail")
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Connection in progress not ended")
+	}
+}
+
+func TestSSHTunnelProcess_KeepaliveInterval(t *testing.T) {
+	p := &SSHTunnelProcess{}
+	if d, _ := p.keepaliveInterval(); d != 30*time.Second {
+		t.Errorf("Expected default keepalive 30s, got %s", d)
+	}
+	p.KeepaliveInterval = `0s`
+	if d, _ := p.keepaliveInterval(); d != 0 {
+		t.Errorf("Expected keepalive disabled, got %s", d)
+	}
+	p.KeepaliveInterval = `often`
+	if _, err := p.keepaliveInterval(); err == nil {
+		t.Errorf("Expected error for invalid keepalive interval")
+	}
+}
+
+// syncBuffer is a buffer safe for concurrent writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
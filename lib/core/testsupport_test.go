@@ -29,3 +29,16 @@ func assertSliceEquals(actual []string, expected []string, desc string, t *testi
 		}
 	}
 }
+
+// restoreRedacted removes the values registered for redaction during the test, so that they do not
+// change the output checked by the following tests.
+func restoreRedacted(t *testing.T) {
+	redacted.RLock()
+	values := append([]string{}, redacted.values...)
+	redacted.RUnlock()
+	t.Cleanup(func() {
+		redacted.Lock()
+		redacted.values = values
+		redacted.Unlock()
+	})
+}
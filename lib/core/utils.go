@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	"github.com/mitchellh/go-homedir"
@@ -19,7 +20,7 @@ import (
 )
 
 // ErrFmtCreateProcess format used for error in process creation.
-const ErrFmtCreateProcess = "Unable to create process for unit %s: exactly one of Host, SSHTunnel, or Container must be defined"
+const ErrFmtCreateProcess = "Unable to create process for unit %s: exactly one of Host, SSHTunnel, Container, KubeForward, or Proxy must be defined"
 
 var (
 	ui                         Logger
@@ -60,21 +61,12 @@ func IsRunpfileValid(runpfile *Runpfile) (bool, []error) {
 		errs = append(errs, errors.New("No units defined in Runpfile"))
 	}
 	for id, unit := range runpfile.Units {
-		modes := []string{}
-		if unit.Container != nil {
-			modes = append(modes, "container")
-		}
-		if unit.Host != nil {
-			modes = append(modes, "host")
-		}
-		if unit.SSHTunnel != nil {
-			modes = append(modes, "ssh_tunnel")
-		}
+		modes := unit.processKinds()
 		if len(modes) > 1 {
-			errs = append(errs, errors.New("Unit "+id+" cannot have multiple process types: Host, Container, and SSHTunnel are mutually exclusive"))
+			errs = append(errs, errors.New("Unit "+id+" cannot have multiple process types: Host, Container, SSHTunnel, KubeForward, and Proxy are mutually exclusive"))
 		}
 		if len(modes) < 1 {
-			errs = append(errs, errors.New("Unit "+id+" must define exactly one process type: Host, SSHTunnel, or Container"))
+			errs = append(errs, errors.New("Unit "+id+" must define exactly one process type: Host, SSHTunnel, Container, KubeForward, or Proxy"))
 		}
 	}
 	return (len(errs) == 0), errs
@@ -83,13 +75,22 @@ func IsRunpfileValid(runpfile *Runpfile) (bool, []error) {
 type runpfileSource struct {
 	path       string
 	importedBy string
-	chain      []string // ordered list of paths from root to this file's importer
+	chain      []string              // ordered list of paths from root to this file's importer
+	key        string                // key decrypting encrypted sections
+	templates  map[string]*yaml.Node // unit templates of the including Runpfiles
 }
 
 // LoadRunpfileFromPath returns an Runpfile object reading file from path.
 func LoadRunpfileFromPath(runpfilePath string) (*Runpfile, error) {
+	return LoadRunpfileFromPathWithKey(runpfilePath, "")
+}
+
+// LoadRunpfileFromPathWithKey returns an Runpfile object reading file from path and decrypting
+// the encrypted sections of the Runpfile and of the included ones using key.
+func LoadRunpfileFromPathWithKey(runpfilePath string, key string) (*Runpfile, error) {
 	rps := runpfileSource{
 		path: runpfilePath,
+		key:  key,
 	}
 	visited := make(map[string]runpfileSource)
 	return loadRunpfileFromPath(rps, visited)
@@ -117,7 +118,12 @@ func loadRunpfileFromPath(runpfile runpfileSource, visited map[string]runpfileSo
 	if err != nil {
 		return nil, err
 	}
-	rf, err := loadRunpfileFromData(data)
+	var rf *Runpfile
+	if isComposeData(data) {
+		rf, err = loadComposeFromData(runpfile.path, data)
+	} else {
+		rf, err = loadRunpfileFromData(data, runpfile)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -125,42 +131,73 @@ func loadRunpfileFromPath(runpfile runpfileSource, visited map[string]runpfileSo
 	if err != nil {
 		return nil, err
 	}
+	rf.path = runpfile.path
+	if err := rf.computeVars(); err != nil {
+		return nil, errors.Wrapf(err, "failed to compute vars of %s", runpfile.path)
+	}
 	for id, unit := range rf.Units {
 		unit.vars = rf.Vars
-		if unit.Name == "" {
-			unit.Name = id
-		}
-		if unit.Process() == nil {
-			return nil, errors.New(fmt.Sprintf(ErrFmtCreateProcess, id))
-		}
-		wd, fail := resolveWorkingDir(rf, unit)
-		if fail != nil {
-			ui.WriteLinef("Failed to resolve working directory for unit %s (path: %s): %v", unit.Name, unit.Process().Dir(), fail)
-			return nil, fail
+		unit.root = rf.Root
+		unit.file = runpfile.path
+		unit.node = rf.unitNodes[id]
+		if err := setupUnit(id, unit); err != nil {
+			return nil, err
 		}
-		ui.Debugf("Resolved working directory for unit %s: %s -> %s", id, unit.Process().Dir(), wd)
-		unit.Process().SetPreconditions(unit.Preconditions)
-		unit.Process().SetDir(wd)
-		unit.Process().SetID(unit.Name)
 	}
-	for _, inc := range rf.Include {
+	includes, err := resolveIncludes(rf.Root, runpfile.path, rf.Include)
+	if err != nil {
+		return nil, err
+	}
+	for _, inc := range includes {
 		err = merge(runpfile, rf, inc, visited)
 		if err != nil {
 			return nil, err
 		}
 	}
-	if runpfile.importedBy == "" {
-		ui.WriteLinef("Runpfile root directory: %s", rf.Root)
+	overrides, err := resolveIncludes(rf.Root, runpfile.path, rf.Overrides)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range overrides {
+		if err := applyOverrides(rf, o, runpfile.key); err != nil {
+			return nil, err
+		}
+	}
+	// included units have the env of their Runpfile first
+	for _, unit := range rf.Units {
+		unit.globalEnv = mergeEnv(rf.Env, unit.globalEnv)
 	}
 	return rf, nil
 }
 
-func merge(runpfile runpfileSource, rf *Runpfile, inc string, visited map[string]runpfileSource) error {
-	rpp := filepath.ToSlash(filepath.Join(rf.Root, inc))
-	ui.Debugf("Including Runpfile from %s: %s", runpfile.path, rpp)
-	if !files.Exists(rpp) {
-		return fmt.Errorf("included Runpfile not found: %s", rpp)
+// setupUnit sets name, working directory and preconditions of the unit, resolving paths from the unit root.
+func setupUnit(id string, unit *RunpUnit) error {
+	if unit.Name == "" {
+		unit.Name = id
+	}
+	if unit.Process() == nil {
+		return errors.New(fmt.Sprintf(ErrFmtCreateProcess, id))
+	}
+	wd, fail := resolveWorkingDir(unit.root, unit)
+	if fail != nil {
+		ui.WriteLinef("Failed to resolve working directory for unit %s (path: %s): %v", unit.Name, unit.Process().Dir(), fail)
+		return fail
+	}
+	ui.Debugf("Resolved working directory for unit %s: %s -> %s", id, unit.Process().Dir(), wd)
+	if unit.Host != nil {
+		unit.Host.root = unit.root
+	}
+	if unit.Container != nil {
+		unit.Container.root = unit.root
 	}
+	unit.Process().SetPreconditions(unit.Preconditions)
+	unit.Process().SetDir(wd)
+	unit.Process().SetID(unit.Name)
+	return nil
+}
+
+func merge(runpfile runpfileSource, rf *Runpfile, rpp string, visited map[string]runpfileSource) error {
+	ui.Debugf("Including Runpfile from %s: %s", runpfile.path, rpp)
 	newChain := make([]string, len(runpfile.chain)+1)
 	copy(newChain, runpfile.chain)
 	newChain[len(runpfile.chain)] = runpfile.path
@@ -168,6 +205,8 @@ func merge(runpfile runpfileSource, rf *Runpfile, inc string, visited map[string
 		path:       rpp,
 		importedBy: runpfile.path,
 		chain:      newChain,
+		key:        runpfile.key,
+		templates:  rf.templates,
 	}
 	if rf.Units == nil {
 		rf.Units = map[string]*RunpUnit{}
@@ -176,13 +215,7 @@ func merge(runpfile runpfileSource, rf *Runpfile, inc string, visited map[string
 	if err != nil {
 		return err
 	}
-	for k, v := range included.Units {
-		if _, ok := rf.Units[k]; ok {
-			return fmt.Errorf("duplicate unit identifier: %s", k)
-		}
-		rf.Units[k] = v
-	}
-	return nil
+	return mergeIncluded(rf, runpfile.path, included, rpp)
 }
 
 func sliceContains(s []string, e string) bool {
@@ -202,9 +235,30 @@ func envAsArray(in map[string]string) (out []string) {
 	return out
 }
 
-func loadRunpfileFromData(data []byte) (*Runpfile, error) {
+func loadRunpfileFromData(data []byte, source runpfileSource) (*Runpfile, error) {
 	rf := &Runpfile{}
-	err := unmarshalStrict(data, &rf)
+	doc, err := parseRunpfileData(data, source.key)
+	if err != nil {
+		return rf, err
+	}
+	templates, err := expandDocumentTemplates(doc, source.templates)
+	if err != nil {
+		return rf, err
+	}
+	// the node is decoded without marshaling it again, so errors refer to the lines of the file
+	if len(doc.Content) > 0 {
+		err = doc.Decode(rf)
+	}
+	rf.templates = templates
+	return rf, err
+}
+
+func loadComposeFromData(path string, data []byte) (*Runpfile, error) {
+	ui.Debugf("Loading %s as docker-compose file", path)
+	rf, warnings, err := RunpfileFromCompose(data)
+	for _, w := range warnings {
+		ui.WriteLinef("Compose file %s: %s", path, w)
+	}
 	return rf, err
 }
 
@@ -217,16 +271,93 @@ func unmarshalStrict(data []byte, out interface{}) error {
 	return nil
 }
 
-func resolveWorkingDir(rf *Runpfile, unit *RunpUnit) (string, error) {
+// decodeNodeStrict decodes a node rejecting unknown fields, as unmarshalStrict does.
+// Node.Decode does not keep the strict mode of the decoder calling custom unmarshalers, so unknown fields
+// are checked on the node, keeping the positions of the document.
+func decodeNodeStrict(node *yaml.Node, out interface{}) error {
+	unknown := []string{}
+	checkKnownFields(node, reflect.TypeOf(out), true, &unknown)
+	err := node.Decode(out)
+	if len(unknown) == 0 {
+		return err
+	}
+	if te, ok := err.(*yaml.TypeError); ok {
+		unknown = append(unknown, te.Errors...)
+	} else if err != nil {
+		return err
+	}
+	return &yaml.TypeError{Errors: unknown}
+}
+
+// checkKnownFields adds to unknown the keys of node not matching a field of the type t, with the message
+// of the strict decoder. Types with a custom unmarshaler, but the root one, check their fields.
+func checkKnownFields(node *yaml.Node, t reflect.Type, root bool, unknown *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(yaml.Node{}) {
+		return
+	}
+	if !root && reflect.PtrTo(t).Implements(reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()) {
+		return
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			checkKnownFields(node.Content[0], t, root, unknown)
+		}
+		return
+	case yaml.AliasNode:
+		checkKnownFields(node.Alias, t, root, unknown)
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := map[string]reflect.Type{}
+		for _, f := range yamlFields(t) {
+			sf, _ := t.FieldByName(f.field)
+			fields[f.key] = sf.Type
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i]
+			if k.Value == "<<" {
+				continue
+			}
+			ft, ok := fields[k.Value]
+			if !ok {
+				*unknown = append(*unknown, fmt.Sprintf("line %d: field %s not found in type %s", k.Line, k.Value, t))
+				continue
+			}
+			checkKnownFields(node.Content[i+1], ft, false, unknown)
+		}
+	case reflect.Map:
+		if node.Kind == yaml.MappingNode {
+			for i := 1; i < len(node.Content); i += 2 {
+				checkKnownFields(node.Content[i], t.Elem(), false, unknown)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind == yaml.SequenceNode {
+			for _, n := range node.Content {
+				checkKnownFields(n, t.Elem(), false, unknown)
+			}
+		}
+	}
+}
+
+func resolveWorkingDir(root string, unit *RunpUnit) (string, error) {
 	process := unit.Process()
 	pd := process.Dir()
 	if unit.SkipDirResolution() {
 		return pd, nil
 	}
 	if pd == "" {
-		return rf.Root, nil
+		return root, nil
 	}
-	return resolvePath(pd, rf.Root)
+	return resolvePath(pd, root)
 }
 
 func resolvePath(pd string, root string) (string, error) {
@@ -2,7 +2,9 @@ package core
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -81,6 +83,27 @@ func TestRunpfileLoad(t *testing.T) {
 	}
 }
 
+func TestRunpfileLoadErrorPositions(t *testing.T) {
+	setupTestUI(t)
+	testCases := map[string]string{
+		"# comment\nname: test\n\nunits:\n  # the web server\n  web:\n    host:\n      bogus: 1\n      command: echo\n": "line 8: field bogus not found in type core.HostProcess",
+		"# comment\n\nbogus: 1\nunits: {}\n":                                                         "line 3: field bogus not found in type core.plain",
+		"vars:\n  # computed\n\n  rev:\n    command: git rev-parse HEAD\n    shell: sh\n":            "line 6: field shell not found in type core.varCommand",
+		"units:\n  db:\n\n    ssh_tunnel:\n      jump:\n        - host: a\n\n          bogus: 1\n":   "line 8: field bogus not found in type core.SSHHop",
+		"units:\n  web:\n    host:\n      command: echo\n\n      await: {resource: x, timeot: 1s}\n": "line 6: field timeot not found in type core.AwaitCondition",
+	}
+	for content, expected := range testCases {
+		path := filepath.Join(t.TempDir(), "Runpfile.yml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadRunpfileFromPath(path)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error <%s>, got <%v>", expected, err)
+		}
+	}
+}
+
 func TestMultiError(t *testing.T) {
 	expected := `e1
 error 2
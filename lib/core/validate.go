@@ -0,0 +1,286 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// ValidationError is a problem found in a Runpfile, with the position of the setting.
+// Line and Column are 0 if the position is not known, as for the units of compose files.
+type ValidationError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// processKeys are the keys of the process settings in the YAML of a unit.
+var processKeys = []string{"host", "container", "ssh_tunnel", "kube_forward", "proxy"}
+
+// Validate checks the Runpfile, with the vars in rf.Vars, without running anything and returns the problems
+// sorted by file and position: process types, durations, vars and secrets, ports, working directories,
+// env_vars preconditions and SSH endpoints.
+// Secret providers are not called.
+func Validate(rf *Runpfile) []ValidationError {
+	v := &validator{rf: rf}
+	if len(rf.Units) == 0 {
+		v.add(rf.path, rf.node, "", "no units defined in Runpfile")
+	}
+	e := NewExecutor(rf)
+	e.dryRun = true
+	e.initializeUnits()
+	defer setActiveSecrets(nil)
+
+	v.envVarsPreconditions(rf.path, rf.node, "preconditions: ", rf.Preconditions.EnvVars)
+	ids := make([]string, 0, len(rf.Units))
+	for id := range rf.Units {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		v.unit(rf.Units[id])
+	}
+	v.ports(ids)
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		a, b := v.errs[i], v.errs[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.errs
+}
+
+type validator struct {
+	rf   *Runpfile
+	errs []ValidationError
+}
+
+// add adds the error at the position of the setting at path, or of the nearest parent found.
+func (v *validator) add(file string, node *yaml.Node, path string, format string, a ...interface{}) {
+	err := ValidationError{File: file, Message: fmt.Sprintf(format, a...)}
+	if node != nil {
+		n := nodeAt(node, path)
+		err.Line, err.Column = n.Line, n.Column
+	}
+	v.errs = append(v.errs, err)
+}
+
+func (v *validator) addUnit(u *RunpUnit, path string, format string, a ...interface{}) {
+	v.add(u.file, u.node, path, "unit "+u.Name+": "+format, a...)
+}
+
+func (v *validator) unit(u *RunpUnit) {
+	kinds := u.processKinds()
+	if len(kinds) != 1 {
+		v.addUnit(u, "", "exactly one process type is required: host, container, ssh_tunnel, kube_forward or proxy")
+		return
+	}
+	key := processKey(u)
+	if u.StopTimeout != "" {
+		if _, err := time.ParseDuration(u.StopTimeout); err != nil {
+			v.addUnit(u, "stop_timeout", "invalid stop_timeout %q, use a duration as 10s", u.StopTimeout)
+		}
+	}
+	process := u.Process()
+	if process.AwaitTimeout() != "" {
+		if _, err := time.ParseDuration(process.AwaitTimeout()); err != nil {
+			v.addUnit(u, key+".await.timeout", "invalid await timeout %q, use a duration as 0h0m10s", process.AwaitTimeout())
+		}
+	} else if process.AwaitResource() != "" {
+		v.addUnit(u, key+".await", "await resource %s requires a timeout", process.AwaitResource())
+	}
+	v.templates(u)
+	for _, err := range u.resolvePorts() {
+		v.addUnit(u, key, "%v", strings.TrimPrefix(err.Error(), "unit "+u.Name+": "))
+	}
+	if u.Container == nil && nodeAt(u.node, key+".workdir") != nodeAt(u.node, key) {
+		dir := process.Dir()
+		if info, err := os.Stat(dir); !strings.Contains(dir, "{{") && (err != nil || !info.IsDir()) {
+			v.addUnit(u, key+".workdir", "workdir %s not found", dir)
+		}
+	}
+	v.envVarsPreconditions(u.file, u.node, "unit "+u.Name+": preconditions: ", u.Preconditions.EnvVars)
+	if u.SSHTunnel != nil {
+		v.sshTunnel(u)
+	}
+}
+
+// templates checks the vars and secrets used in the settings of the unit.
+func (v *validator) templates(u *RunpUnit) {
+	p := newCliPreprocessor(u.vars)
+	if u.node == nil {
+		for _, err := range u.templateErrors() {
+			v.add(u.file, nil, "", "%v", err)
+		}
+		return
+	}
+	walkScalars(u.node, func(n *yaml.Node) {
+		if _, err := p.execute(n.Value); err != nil {
+			v.errs = append(v.errs, ValidationError{File: u.file, Line: n.Line, Column: n.Column,
+				Message: fmt.Sprintf("unit %s: %v", u.Name, err)})
+		}
+	})
+}
+
+func (v *validator) envVarsPreconditions(file string, node *yaml.Node, prefix string, p EnvVarsPrecondition) {
+	path := "preconditions"
+	for i, check := range p.EnvVars {
+		if msg := validateCheckConfig(check); msg != "" {
+			at := fmt.Sprintf("%s.env_vars.%d", path, i)
+			if n := nodeAt(node, path+".env_vars"); n != nil && n.Kind == yaml.MappingNode {
+				at = fmt.Sprintf("%s.env_vars.env_vars.%d", path, i)
+			}
+			v.add(file, node, at, "%s%s", prefix, msg)
+		}
+	}
+}
+
+// sshTunnel checks the keepalive interval and the ports of jump servers and forwards: jump ports can be set
+// in the SSH configuration.
+func (v *validator) sshTunnel(u *RunpUnit) {
+	t := u.SSHTunnel
+	if t.KeepaliveInterval != "" {
+		if _, err := time.ParseDuration(t.KeepaliveInterval); err != nil {
+			v.addUnit(u, "ssh_tunnel.keepalive_interval", "invalid keepalive_interval %q, use a duration as 30s", t.KeepaliveInterval)
+		}
+	}
+	if t.SSHConfigHost == "" {
+		if len(t.Hops) > 0 {
+			for i, h := range t.Hops {
+				if h.Port == 0 {
+					v.addUnit(u, fmt.Sprintf("ssh_tunnel.jump.%d", i), "port not specified for jump server %s", h.Host)
+				}
+			}
+		} else if t.Jump.Port == 0 && t.Jump.portTemplate == "" {
+			v.addUnit(u, "ssh_tunnel.jump", "port not specified for jump server %s", t.Jump.Host)
+		}
+	}
+	for i, f := range t.forwards() {
+		path := "ssh_tunnel"
+		if len(t.Forwards) > 0 {
+			path = fmt.Sprintf("ssh_tunnel.forwards.%d", i)
+		}
+		if f.Local.Port == 0 && f.Local.portTemplate == "" {
+			v.addUnit(u, path+".local", "port not specified for local endpoint")
+		}
+		if !f.Dynamic && !f.IsRemote() && f.Target.Port == 0 && f.Target.portTemplate == "" {
+			v.addUnit(u, path+".target", "port not specified for target endpoint")
+		}
+	}
+}
+
+// binding is a local port listened by a unit.
+type binding struct {
+	unit *RunpUnit
+	path string
+	port int
+}
+
+// ports checks that units do not listen on the same local port.
+func (v *validator) ports(ids []string) {
+	used := map[int]binding{}
+	for _, id := range ids {
+		for _, b := range unitBindings(v.rf.Units[id]) {
+			if other, ok := used[b.port]; ok && other.unit != b.unit {
+				v.addUnit(b.unit, b.path, "port %d already used by unit %s", b.port, other.unit.Name)
+				continue
+			}
+			used[b.port] = b
+		}
+	}
+}
+
+func unitBindings(u *RunpUnit) []binding {
+	bindings := []binding{}
+	p := newCliPreprocessor(u.vars)
+	add := func(path string, port string) {
+		if n, err := strconv.Atoi(strings.TrimSpace(p.process(port))); err == nil && n > 0 {
+			bindings = append(bindings, binding{unit: u, path: path, port: n})
+		}
+	}
+	switch {
+	case u.Container != nil:
+		// [ip:]host:container[/protocol], the host port is optional
+		for i, ports := range u.Container.Ports {
+			parts := strings.Split(strings.SplitN(ports, "/", 2)[0], ":")
+			if len(parts) > 1 {
+				add(fmt.Sprintf("container.ports.%d", i), parts[len(parts)-2])
+			}
+		}
+	case u.KubeForward != nil:
+		// local:remote or port
+		for i, ports := range u.KubeForward.Ports {
+			add(fmt.Sprintf("kube_forward.ports.%d", i), strings.SplitN(ports, ":", 2)[0])
+		}
+	case u.SSHTunnel != nil:
+		for i, f := range u.SSHTunnel.forwards() {
+			if f.IsRemote() {
+				continue
+			}
+			path := "ssh_tunnel.local"
+			if len(u.SSHTunnel.Forwards) > 0 {
+				path = fmt.Sprintf("ssh_tunnel.forwards.%d.local", i)
+			}
+			add(path, strconv.Itoa(f.Local.Port))
+		}
+	case u.Proxy != nil:
+		add("proxy.listen", strconv.Itoa(u.Proxy.Listen.Port))
+	}
+	return bindings
+}
+
+// processKey returns the key of the process settings in the YAML of the unit.
+func processKey(u *RunpUnit) string {
+	for i, p := range []bool{u.Host != nil, u.Container != nil, u.SSHTunnel != nil, u.KubeForward != nil, u.Proxy != nil} {
+		if p {
+			return processKeys[i]
+		}
+	}
+	return ""
+}
+
+// nodeAt returns the node at path, keys and sequence indexes separated by dots, or the nearest parent found.
+func nodeAt(node *yaml.Node, path string) *yaml.Node {
+	if node == nil || path == "" {
+		return node
+	}
+	for _, segment := range strings.Split(path, ".") {
+		next, err := lookupNode(node, segment)
+		if err != nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+// walkScalars calls f for the scalar values of node, mapping keys excluded.
+func walkScalars(node *yaml.Node, f func(*yaml.Node)) {
+	if node.Kind == yaml.ScalarNode {
+		f(node)
+		return
+	}
+	for i, n := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		walkScalars(n, f)
+	}
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	setupTestUI(t)
+	rf, err := LoadRunpfileFromPath("../../testdata/runpfiles/validate/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []struct {
+		line    int
+		column  int
+		message string
+	}{
+		{6, 7, "preconditions: Invalid condition 'is_present' for environment variable 'CI'"},
+		{10, 19, `unit api: invalid stop_timeout "10 seconds"`},
+		{12, 16, "unit api: var db_url not defined"},
+		{13, 16, "unit api: workdir "},
+		{16, 18, `unit api: invalid await timeout "ten"`},
+		{21, 11, "unit web: port 8080 already used by unit proxy"},
+		{31, 11, "unit tunnel: preconditions: Environment variable 'VPN' requires 'value' field"},
+		{36, 9, "unit tunnel: port not specified for jump server bastion"},
+		{40, 9, "unit tunnel: port not specified for target endpoint"},
+		{41, 27, `unit tunnel: invalid keepalive_interval "often"`},
+	}
+	errs := Validate(rf)
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	for i, e := range expected {
+		actual := errs[i]
+		if actual.Line != e.line || actual.Column != e.column || !strings.Contains(actual.Message, e.message) {
+			t.Errorf("Error %d, expected %d:%d <%s>, got %v", i, e.line, e.column, e.message, actual)
+		}
+		if !strings.HasSuffix(actual.File, "validate/Runpfile.yml") {
+			t.Errorf("Error %d, unexpected file %s", i, actual.File)
+		}
+	}
+}
+
+func TestValidateTemplatesPositions(t *testing.T) {
+	setupTestUI(t)
+	rf, err := LoadRunpfileFromPath("../../testdata/runpfiles/validate/templates.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.Vars = map[string]string{}
+	expected := []string{
+		`templates.yml:17:19: unit api: invalid stop_timeout "10 seconds", use a duration as 10s`,
+		`templates.yml:21:19: unit web: invalid stop_timeout "soon", use a duration as 10s`,
+	}
+	errs := Validate(rf)
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	for i, e := range expected {
+		if !strings.HasSuffix(errs[i].Error(), e) {
+			t.Errorf("Error %d, expected <%s>, got <%s>", i, e, errs[i].Error())
+		}
+	}
+
+	// decoding errors of units extending a template
+	data, err := os.ReadFile("../../testdata/runpfiles/validate/templates.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "Runpfile.yml")
+	data = []byte(strings.Replace(string(data), "    stop_timeout: soon", "    stop_timeout: 1s\n    # misspelled\n    extend: service", 1))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRunpfileFromPath(path); err == nil || !strings.Contains(err.Error(), "line 23: field extend not found in type core.RunpUnit") {
+		t.Errorf("Expected unknown field at line 23, got %v", err)
+	}
+}
+
+func TestValidateValidRunpfile(t *testing.T) {
+	setupTestUI(t)
+	rf, err := LoadRunpfileFromPath("../../testdata/runpfiles/templates/Runpfile.yml")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	rf.Vars = map[string]string{"runp_root": rf.Root}
+	if errs := Validate(rf); len(errs) > 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+}
+
+func TestValidateProcessTypes(t *testing.T) {
+	setupTestUI(t)
+	rf := &Runpfile{path: "Runpfile.yml", Units: map[string]*RunpUnit{
+		"none": {Name: "none", file: "Runpfile.yml"},
+		"both": {Name: "both", file: "Runpfile.yml", Host: &HostProcess{Executable: "echo"}, Proxy: &ProxyProcess{}},
+	}}
+	errs := Validate(rf)
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %v", errs)
+	}
+	for _, e := range errs {
+		if !strings.HasPrefix(e.Error(), "Runpfile.yml: unit ") || !strings.Contains(e.Message, "exactly one process type is required") {
+			t.Errorf("Unexpected error %v", e)
+		}
+	}
+	if errs := Validate(&Runpfile{path: "Runpfile.yml"}); len(errs) != 1 || errs[0].Error() != "Runpfile.yml: no units defined in Runpfile" {
+		t.Errorf("Expected no units error, got %v", errs)
+	}
+}
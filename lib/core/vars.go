@@ -0,0 +1,167 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/enr/go-files/files"
+	"github.com/pkg/errors"
+	"github.com/subosito/gotenv"
+)
+
+const (
+	// VarsEnvPrefix is the prefix of the environment variables overriding vars.
+	VarsEnvPrefix = "RUNP_VAR_"
+	// VarSourceRunpfile is the source of vars declared in the Runpfile, followed by the path of included Runpfiles.
+	VarSourceRunpfile = "Runpfile"
+	// VarSourceFlag is the source of vars set using --var.
+	VarSourceFlag = "--var"
+	// VarSourceImplicit is the source of vars added by runp.
+	VarSourceImplicit = "implicit"
+	// VarSourceCommand is the source of vars computed running a command, followed by the command.
+	VarSourceCommand = "command"
+
+	dotenvFileName = ".env"
+)
+
+// Vars are the resolved vars and the source of each value.
+// Sources in increasing precedence are: the Runpfile (including computed vars), the ports section,
+// the .env file next to the Runpfile, var files, RUNP_VAR_* environment variables, --var flags, implicit vars.
+type Vars struct {
+	Values  map[string]string
+	Sources map[string]string
+}
+
+// NewVars returns vars initialized from the Runpfile.
+func NewVars(rf *Runpfile) *Vars {
+	v := &Vars{
+		Values:  map[string]string{},
+		Sources: map[string]string{},
+	}
+	for name, value := range rf.Vars {
+		source := VarSourceRunpfile
+		if origin, ok := rf.varOrigins[name]; ok {
+			source = VarSourceRunpfile + " " + origin
+		}
+		if command, ok := rf.computedVars[name]; ok {
+			source = VarSourceCommand + " " + command
+		}
+		v.Set(name, value, source)
+	}
+	return v
+}
+
+// Set sets the value of a var.
+func (v *Vars) Set(name string, value string, source string) {
+	v.Values[name] = value
+	v.Sources[name] = source
+}
+
+// Names returns the names of the vars, sorted.
+func (v *Vars) Names() []string {
+	names := make([]string, 0, len(v.Values))
+	for name := range v.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadVars returns the vars of the Runpfile and the allocated ports, overridden by the .env file
+// next to the Runpfile, by the var files and by the RUNP_VAR_* environment variables.
+func LoadVars(rf *Runpfile, varFiles []string) (*Vars, error) {
+	v := NewVars(rf)
+	if err := v.allocatePorts(rf); err != nil {
+		return nil, err
+	}
+	dotenv := filepath.Join(rf.Root, dotenvFileName)
+	if files.IsRegular(dotenv) {
+		if err := v.loadFile(dotenv, dotenvFileName+" "+dotenv); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range varFiles {
+		if err := v.loadFile(f, "var file "+f); err != nil {
+			return nil, err
+		}
+	}
+	v.loadEnvironment(os.Environ())
+	return v, nil
+}
+
+// allocatePorts sets the vars of the ports section to free local ports.
+func (v *Vars) allocatePorts(rf *Runpfile) error {
+	for _, name := range rf.Ports {
+		if _, ok := v.Values[name]; ok {
+			return errors.Errorf("port %s is declared in vars too", name)
+		}
+	}
+	ports, err := AllocatePorts(rf.Ports)
+	if err != nil {
+		return err
+	}
+	for name, port := range ports {
+		v.Set(name, strconv.Itoa(port), VarSourcePorts)
+	}
+	return nil
+}
+
+// loadFile loads a YAML file if it has extension .yml or .yaml, a dotenv file otherwise.
+func (v *Vars) loadFile(path string, source string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "cannot read var file %s", path)
+	}
+	values := map[string]string{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = unmarshalStrict(data, &values)
+	default:
+		values, err = gotenv.StrictParse(bytes.NewReader(data))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "invalid var file %s", path)
+	}
+	ui.Debugf("Loaded %d vars from %s", len(values), path)
+	for name, value := range values {
+		v.Set(name, value, source)
+	}
+	return nil
+}
+
+// loadEnvironment overrides the vars already defined using the RUNP_VAR_<name> environment variables.
+// The name is matched ignoring case, so that RUNP_VAR_DB_HOST overrides db_host.
+func (v *Vars) loadEnvironment(environ []string) {
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, VarsEnvPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(kv, VarsEnvPrefix), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, found := v.lookupName(parts[0])
+		if !found {
+			ui.Debugf("Ignoring %s%s: var not declared", VarsEnvPrefix, parts[0])
+			continue
+		}
+		v.Set(name, parts[1], "environment "+VarsEnvPrefix+parts[0])
+	}
+}
+
+// lookupName returns the declared var with the given name, the exact match or the one differing only in case.
+func (v *Vars) lookupName(name string) (string, bool) {
+	if _, ok := v.Values[name]; ok {
+		return name, true
+	}
+	for _, declared := range v.Names() {
+		if strings.EqualFold(declared, name) {
+			return declared, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadVarsPrecedence(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	t.Setenv("RUNP_VAR_from_environment", "environment")
+	root, err := filepath.Abs("../../testdata/vars-sources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf := &Runpfile{
+		Root: root,
+		Vars: map[string]string{
+			"from_runpfile":    "runpfile",
+			"from_dotenv":      "runpfile",
+			"from_var_file":    "runpfile",
+			"from_environment": "runpfile",
+		},
+	}
+	vars, err := LoadVars(rf, []string{filepath.Join(root, "vars.yml")})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := map[string]string{
+		"from_runpfile":    "runpfile",
+		"from_dotenv":      "dotenv",
+		"from_var_file":    "yaml",
+		"from_environment": "environment",
+	}
+	for name, value := range expected {
+		if vars.Values[name] != value {
+			t.Errorf("Var %s, expected <%s> got <%s> (%s)", name, value, vars.Values[name], vars.Sources[name])
+		}
+	}
+}
+
+func TestLoadVarsInvalidFile(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	dir := t.TempDir()
+	testCases := map[string]string{
+		"vars.yml": "foo: [1, 2]",
+		"vars.env": "foo bar",
+	}
+	for name, content := range testCases {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadVars(&Runpfile{Root: dir}, []string{path})
+		if err == nil || !strings.Contains(err.Error(), "invalid var file") {
+			t.Errorf("Expected error for invalid var file %s, got %v", name, err)
+		}
+	}
+	if _, err := LoadVars(&Runpfile{Root: dir}, []string{filepath.Join(dir, "missing.yml")}); err == nil {
+		t.Errorf("Expected error for missing var file")
+	}
+}
+
+func writeRunpfile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "Runpfile")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestComputedVars(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	path := writeRunpfile(t, `
+vars:
+  static: value
+  greeting:
+    command: echo hello
+units:
+  test:
+    host:
+      command: echo {{vars greeting}}
+`)
+	rf, err := LoadRunpfileFromPath(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := map[string]string{"static": "value", "greeting": "hello"}
+	assertMapEquals(rf.Vars, expected, "Vars", t)
+	vars := NewVars(rf)
+	if vars.Sources["greeting"] != "command echo hello" {
+		t.Errorf("Expected source of greeting <command echo hello>, got <%s>", vars.Sources["greeting"])
+	}
+	if vars.Sources["static"] != VarSourceRunpfile {
+		t.Errorf("Expected source of static <%s>, got <%s>", VarSourceRunpfile, vars.Sources["static"])
+	}
+}
+
+func TestComputedVarsErrors(t *testing.T) {
+	ConfigureUI(testLogger, LoggerConfig{})
+	testCases := map[string][]string{
+		"vars:\n  v:\n    command: echo oops 1>&2 && exit 3\n": {"failed to compute vars", "var v", `command "echo oops 1>&2 && exit 3" failed`, "oops"},
+		"vars:\n  v:\n    cmd: echo\n":                         {"invalid var v", "cmd"},
+		"vars:\n  v: {}\n":                                     {"invalid var v: command not specified"},
+		"vars:\n  v: x\nunknown: y\n":                          {"unknown"},
+	}
+	for content, expected := range testCases {
+		_, err := LoadRunpfileFromPath(writeRunpfile(t, content))
+		if err == nil {
+			t.Errorf("Expected error loading %q", content)
+			continue
+		}
+		for _, e := range expected {
+			if !strings.Contains(err.Error(), e) {
+				t.Errorf("Loading %q, expected error containing <%s>, got <%v>", content, e, err)
+			}
+		}
+	}
+}